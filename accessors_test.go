@@ -0,0 +1,74 @@
+package jmongo
+
+import (
+	"github.com/JackWSK/jmongo/entity"
+	"reflect"
+	"testing"
+)
+
+//go:generate go run ./cmd/jmongogen -type Test -noinit collection_test.go
+
+// useGeneratedAccessors access the fields of Test by the generated accessors until tb ends
+func useGeneratedAccessors(tb testing.TB) {
+	registerTestAccessors()
+	tb.Cleanup(func() {
+		entity.UnregisterAccessors(&Test{})
+	})
+}
+
+func Test_GeneratedAccessors(t *testing.T) {
+	useGeneratedAccessors(t)
+	schema, err := entity.GetOrParse(&Test{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model := &Test{Id: NewSObjectId(), Name: "tom", Age: 12, UserPassword: 7}
+	for _, field := range schema.Fields {
+		accessor := field.ReflectAccessor()
+		for _, value := range []reflect.Value{reflect.ValueOf(model), reflect.ValueOf(*model), reflect.ValueOf(model).Elem()} {
+			got, gotZero := field.ValueOf(value)
+			want, wantZero := accessor.ValueOf(value)
+			if !reflect.DeepEqual(got, want) || gotZero != wantZero {
+				t.Fatalf("ValueOf of %s = %v, %v, want %v, %v", field.Name, got, gotZero, want, wantZero)
+			}
+		}
+	}
+
+	age, _ := schema.MustLookUpField("Age")
+	age.ReflectValueOf(reflect.ValueOf(model)).SetInt(30)
+	if model.Age != 30 {
+		t.Fatalf("Age = %d, want 30", model.Age)
+	}
+}
+
+func Benchmark_FieldValueOf(b *testing.B) {
+	useGeneratedAccessors(b)
+	schema, err := entity.GetOrParse(&Test{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	value := reflect.ValueOf(&Test{Name: "tom", Age: 12})
+
+	// a string is boxed by both, a small int is boxed without allocating only by the generated accessor
+	for _, name := range []string{"Name", "Age"} {
+		field, err := schema.MustLookUpField(name)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(name+"/reflection", func(b *testing.B) {
+			valueOf := field.ReflectAccessor().ValueOf
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				valueOf(value)
+			}
+		})
+		b.Run(name+"/generated", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				field.ValueOf(value)
+			}
+		})
+	}
+}
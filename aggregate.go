@@ -0,0 +1,566 @@
+package jmongo
+
+import (
+	"context"
+	"github.com/JackWSK/jmongo/entity"
+	"github.com/JackWSK/jmongo/errortype"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+var decimalType = reflect.TypeOf(primitive.Decimal128{})
+
+// AggregateBuilder build an aggregation pipeline on a collection,
+// the first error of the stages is kept and returned by Build and the terminal methods
+type AggregateBuilder[MODEL any, ID any] struct {
+	collection *Collection[MODEL, ID]
+	pipeline   mongo.Pipeline
+	err        error
+}
+
+// Aggregation create a pipeline builder on the collection
+func (th *Collection[MODEL, ID]) Aggregation() *AggregateBuilder[MODEL, ID] {
+	return &AggregateBuilder[MODEL, ID]{collection: th}
+}
+
+// Match append a $match stage, filter is converted the same way as Find and soft deleted documents are excluded
+// unless filter has a condition on the field. A Match after a $group keeps its documents, the missing field is null
+func (th *AggregateBuilder[MODEL, ID]) Match(filter any) *AggregateBuilder[MODEL, ID] {
+	query, _, err := th.collection.convertFilter(filter)
+	if err != nil {
+		return th.fail(err)
+	}
+	return th.Stage(bson.D{{Key: "$match", Value: th.collection.liveFilter(query, false)}})
+}
+
+// Project append a $project stage, fields is a *Projection or a bson.M,
+// keys of the bson.M naming a model field are resolved to the db name, the others are used literally
+func (th *AggregateBuilder[MODEL, ID]) Project(fields any) *AggregateBuilder[MODEL, ID] {
+	switch v := fields.(type) {
+	case *Projection:
+		stage, err := v.toStage(th.collection.schema)
+		if err != nil {
+			return th.fail(err)
+		}
+		return th.Stage(bson.D{{Key: "$project", Value: stage}})
+	case bson.M:
+		stage := bson.M{}
+		for key, value := range v {
+			if field := th.collection.schema.LookUpField(key); field != nil {
+				key = field.DBName
+			}
+			stage[key] = value
+		}
+		return th.Stage(bson.D{{Key: "$project", Value: stage}})
+	}
+	return th.fail(errors.WithStack(errortype.ErrUnsupportedDataType))
+}
+
+// Group append a $group stage, id is the field grouped by, a go name or db name of the model, or an expression used literally,
+// e.g. nil for all documents. accumulators are the fields of the groups, e.g. {"total": {"$sum": "$amount"}}
+func (th *AggregateBuilder[MODEL, ID]) Group(id any, accumulators bson.M) *AggregateBuilder[MODEL, ID] {
+	if name, ok := id.(string); ok {
+		if field := th.collection.schema.LookUpField(strings.TrimPrefix(name, "$")); field != nil {
+			id = "$" + field.DBName
+		}
+	}
+
+	// the fields in order of name, so the same groups build the same stage
+	keys := make([]string, 0, len(accumulators))
+	for key := range accumulators {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	group := bson.D{{Key: "_id", Value: id}}
+	for _, key := range keys {
+		group = append(group, bson.E{Key: key, Value: accumulators[key]})
+	}
+	return th.Stage(bson.D{{Key: "$group", Value: group}})
+}
+
+// Sort append a $sort stage by field, a go name or db name of the model, or a field of the former stages.
+// Sorts called one after another make one $sort in their order, a $sort added by Stage is left as it is
+func (th *AggregateBuilder[MODEL, ID]) Sort(field string, asc bool) *AggregateBuilder[MODEL, ID] {
+	if f := th.collection.schema.LookUpField(field); f != nil {
+		field = f.DBName
+	}
+	order := -1
+	if asc {
+		order = 1
+	}
+
+	key := bson.E{Key: field, Value: order}
+	if th.endsWith("$sort") {
+		last := th.pipeline[len(th.pipeline)-1]
+		if keys, ok := last[0].Value.(bson.D); ok {
+			last[0].Value = append(keys, key)
+			return th
+		}
+	}
+	return th.Stage(bson.D{{Key: "$sort", Value: bson.D{key}}})
+}
+
+// Limit append a $limit stage
+func (th *AggregateBuilder[MODEL, ID]) Limit(n int64) *AggregateBuilder[MODEL, ID] {
+	return th.Stage(bson.D{{Key: "$limit", Value: n}})
+}
+
+// Skip append a $skip stage
+func (th *AggregateBuilder[MODEL, ID]) Skip(n int64) *AggregateBuilder[MODEL, ID] {
+	return th.Stage(bson.D{{Key: "$skip", Value: n}})
+}
+
+// Sample append a $sample stage selecting n random documents
+func (th *AggregateBuilder[MODEL, ID]) Sample(n int) *AggregateBuilder[MODEL, ID] {
+	return th.Stage(bson.D{{Key: "$sample", Value: bson.D{{Key: "size", Value: n}}}})
+}
+
+// Stage append a raw stage
+func (th *AggregateBuilder[MODEL, ID]) Stage(stage bson.D) *AggregateBuilder[MODEL, ID] {
+	th.pipeline = append(th.pipeline, stage)
+	return th
+}
+
+// BucketResult a bucket of Bucket or BucketAuto, decoded by Buckets
+type BucketResult struct {
+	// the lower boundary of the bucket or the default bucket, {min, max} for BucketAuto
+	Id any `bson:"_id"`
+	// documents of the bucket, set when output is nil or has count
+	Count int64 `bson:"count"`
+	// the other fields of output
+	Output bson.M `bson:",inline"`
+}
+
+// Bucket append a $bucket stage grouping the documents by field into the ranges of boundaries, e.g. a histogram of amounts.
+// Documents out of the boundaries go to defaultBucket, they fail the stage when it is nil.
+// output are the accumulators of each bucket, the server counts the documents when it is nil
+func (th *AggregateBuilder[MODEL, ID]) Bucket(field string, boundaries []float64, defaultBucket any, output bson.M) *AggregateBuilder[MODEL, ID] {
+	groupBy, err := th.collection.schema.MustLookUpField(field)
+	if err != nil {
+		return th.fail(err)
+	}
+
+	bucket := bson.D{
+		{Key: "groupBy", Value: "$" + groupBy.DBName},
+		{Key: "boundaries", Value: boundaries},
+	}
+	if defaultBucket != nil {
+		bucket = append(bucket, bson.E{Key: "default", Value: defaultBucket})
+	}
+	if output != nil {
+		bucket = append(bucket, bson.E{Key: "output", Value: output})
+	}
+	return th.Stage(bson.D{{Key: "$bucket", Value: bucket}})
+}
+
+// BucketAuto append a $bucketAuto stage grouping the documents by field into buckets of about the same size,
+// output are the accumulators of each bucket, the server counts the documents when it is nil
+func (th *AggregateBuilder[MODEL, ID]) BucketAuto(field string, buckets int, output bson.M) *AggregateBuilder[MODEL, ID] {
+	groupBy, err := th.collection.schema.MustLookUpField(field)
+	if err != nil {
+		return th.fail(err)
+	}
+
+	bucket := bson.D{
+		{Key: "groupBy", Value: "$" + groupBy.DBName},
+		{Key: "buckets", Value: buckets},
+	}
+	if output != nil {
+		bucket = append(bucket, bson.E{Key: "output", Value: output})
+	}
+	return th.Stage(bson.D{{Key: "$bucketAuto", Value: bucket}})
+}
+
+// Buckets run the pipeline ended by Bucket or BucketAuto and decode the buckets
+func (th *AggregateBuilder[MODEL, ID]) Buckets(ctx context.Context, opts ...*options.AggregateOptions) ([]BucketResult, error) {
+	if th.err != nil {
+		return nil, th.err
+	}
+	if !th.endsWith("$bucket") && !th.endsWith("$bucketAuto") {
+		return nil, errors.New("Buckets needs a pipeline ended by $bucket or $bucketAuto")
+	}
+
+	var results []BucketResult
+	if err := th.collection.Aggregate(ctx, th.pipeline, &results, opts...); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return results, nil
+}
+
+// Merge append a $merge stage writing the results into the collection of into, a model or a collection name,
+// e.g. to keep a materialized view. on are the fields of into matching the results, _id when empty,
+// whenMatched and whenNotMatched are the actions of $merge, the defaults of the server when empty. Run the pipeline by Run
+func (th *AggregateBuilder[MODEL, ID]) Merge(into any, on []string, whenMatched string, whenNotMatched string) *AggregateBuilder[MODEL, ID] {
+	collection, schema, err := targetCollection(into)
+	if err != nil {
+		return th.fail(err)
+	}
+
+	merge := bson.D{{Key: "into", Value: collection}}
+	if len(on) > 0 {
+		fields := make(bson.A, 0, len(on))
+		for _, name := range on {
+			if schema != nil {
+				field, err := schema.MustLookUpField(name)
+				if err != nil {
+					return th.fail(err)
+				}
+				name = field.DBName
+			}
+			fields = append(fields, name)
+		}
+		merge = append(merge, bson.E{Key: "on", Value: fields})
+	}
+	if whenMatched != "" {
+		merge = append(merge, bson.E{Key: "whenMatched", Value: whenMatched})
+	}
+	if whenNotMatched != "" {
+		merge = append(merge, bson.E{Key: "whenNotMatched", Value: whenNotMatched})
+	}
+	return th.Stage(bson.D{{Key: "$merge", Value: merge}})
+}
+
+// Out append an $out stage replacing the collection of into, a model or a collection name, by the results.
+// Every document of the collection is dropped, it is created when it does not exist. Run the pipeline by Run
+func (th *AggregateBuilder[MODEL, ID]) Out(into any) *AggregateBuilder[MODEL, ID] {
+	collection, _, err := targetCollection(into)
+	if err != nil {
+		return th.fail(err)
+	}
+	return th.Stage(bson.D{{Key: "$out", Value: collection}})
+}
+
+// Run run a pipeline ended by $merge or $out, which write their results instead of returning them
+func (th *AggregateBuilder[MODEL, ID]) Run(ctx context.Context, opts ...*options.AggregateOptions) error {
+	if th.err != nil {
+		return th.err
+	}
+	if !th.endsWith("$merge") && !th.endsWith("$out") {
+		return errors.New("Run needs a pipeline ended by $merge or $out")
+	}
+
+	col := th.collection
+	ctx, cancel := col.withTimeout(ctx)
+	defer cancel()
+	cursor, err := retry(ctx, col.retryPolicy(), true, func() (*mongo.Cursor, error) {
+		cursor, err := col.collection.Aggregate(ctx, th.pipeline, opts...)
+		return cursor, errors.WithStack(err)
+	})
+	if err != nil {
+		return err
+	}
+	col.markWrite()
+	return errors.WithStack(cursor.Close(ctx))
+}
+
+// Build return the pipeline, use it to drop down to the driver
+func (th *AggregateBuilder[MODEL, ID]) Build() (mongo.Pipeline, error) {
+	return th.pipeline, th.err
+}
+
+// All run the pipeline and decode every result into results, a pointer to a slice, a slice of the model is decoded as Find does
+func (th *AggregateBuilder[MODEL, ID]) All(ctx context.Context, results any, opts ...*options.AggregateOptions) error {
+	if th.err != nil {
+		return th.err
+	}
+	return errors.WithStack(th.collection.Aggregate(ctx, th.pipeline, results, opts...))
+}
+
+// Count run the pipeline terminated by a $count stage, return the number of documents reaching it
+func (th *AggregateBuilder[MODEL, ID]) Count(ctx context.Context) (int64, error) {
+	pipeline, err := th.countPipeline()
+	if err != nil {
+		return 0, err
+	}
+
+	var results []struct {
+		Count int64 `bson:"count"`
+	}
+	err = th.collection.Aggregate(ctx, pipeline, &results)
+	if err != nil {
+		return 0, err
+	}
+
+	// $count output nothing when no document reaches it
+	if len(results) == 0 {
+		return 0, nil
+	}
+	return results[0].Count, nil
+}
+
+// AggregateEach run pipeline and call each with every result decoded into a fresh T, e.g. the model, a DTO or bson.M,
+// so huge outputs are not loaded at once. It stops at the first error of each, which is returned, the cursor is always closed
+func AggregateEach[T any, MODEL any, ID any](ctx context.Context, col *Collection[MODEL, ID], pipeline any, each func(doc T) error, opts ...*options.AggregateOptions) error {
+	ctx, cancel := col.withTimeout(ctx)
+	defer cancel()
+
+	cursor, err := retry(ctx, col.retryPolicy(), false, func() (*mongo.Cursor, error) {
+		return col.reader().Aggregate(ctx, pipeline, opts...)
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return eachDecoded(ctx, cursor, rawDecoder[T](col), each)
+}
+
+// rawDecoder decode a document into T, the model is decoded as Find does, its setter fields,
+// the raw field and the location of the times included, any other T by aggregateRegistry
+func rawDecoder[T any, MODEL any, ID any](col *Collection[MODEL, ID]) func(raw bson.Raw, out *T) error {
+	docType := reflect.TypeOf((*T)(nil)).Elem()
+	for docType.Kind() == reflect.Ptr {
+		docType = docType.Elem()
+	}
+	if docType == col.schema.ModelType {
+		return func(raw bson.Raw, out *T) error {
+			return col.decodeRaw(raw, col.schema, out)
+		}
+	}
+	return func(raw bson.Raw, out *T) error {
+		return errors.WithStack(bson.UnmarshalWithRegistry(aggregateRegistry, raw, out))
+	}
+}
+
+// eachDecoded decode every document of cursor into a fresh T by decode and call each with it, then close the cursor
+func eachDecoded[T any](ctx context.Context, cursor *mongo.Cursor, decode func(raw bson.Raw, out *T) error, each func(doc T) error) error {
+	defer func() {
+		// close the cursor on the server even when ctx is done
+		closeCtx := ctx
+		if ctx.Err() != nil {
+			closeCtx = context.Background()
+		}
+		_ = cursor.Close(closeCtx)
+	}()
+
+	for cursor.Next(ctx) {
+		var doc T
+		if err := decode(cursor.Current, &doc); err != nil {
+			return err
+		}
+		if err := each(doc); err != nil {
+			return err
+		}
+	}
+	return errors.WithStack(cursor.Err())
+}
+
+// SumField sum of the numeric field over the documents matched by filter
+func (th *Collection[MODEL, ID]) SumField(ctx context.Context, field string, filter any) (float64, error) {
+	return th.aggregateField(ctx, "$sum", field, filter)
+}
+
+// MinField minimum of the numeric field over the documents matched by filter, 0 when nothing matched
+func (th *Collection[MODEL, ID]) MinField(ctx context.Context, field string, filter any) (float64, error) {
+	return th.aggregateField(ctx, "$min", field, filter)
+}
+
+// MaxField maximum of the numeric field over the documents matched by filter, 0 when nothing matched
+func (th *Collection[MODEL, ID]) MaxField(ctx context.Context, field string, filter any) (float64, error) {
+	return th.aggregateField(ctx, "$max", field, filter)
+}
+
+// AvgField average of the numeric field over the documents matched by filter, 0 when nothing matched
+func (th *Collection[MODEL, ID]) AvgField(ctx context.Context, field string, filter any) (float64, error) {
+	return th.aggregateField(ctx, "$avg", field, filter)
+}
+
+// SumDecimal sum of the primitive.Decimal128 field over the documents matched by filter,
+// the sum is computed and decoded as decimal so no precision is lost, e.g. for amounts of money
+func (th *Collection[MODEL, ID]) SumDecimal(ctx context.Context, field string, filter any) (primitive.Decimal128, error) {
+	var sum primitive.Decimal128
+	pipeline, err := th.sumDecimalPipeline(field, filter)
+	if err != nil {
+		return sum, err
+	}
+
+	var results []struct {
+		Value primitive.Decimal128 `bson:"v"`
+	}
+	err = th.Aggregate(ctx, pipeline, &results)
+	if err != nil {
+		return sum, errors.WithStack(err)
+	}
+
+	if len(results) == 0 {
+		return sum, nil
+	}
+	return results[0].Value, nil
+}
+
+func (th *Collection[MODEL, ID]) sumDecimalPipeline(field string, filter any) (mongo.Pipeline, error) {
+	schemaField, err := th.mustSchemaField(field)
+	if err != nil {
+		return nil, err
+	}
+
+	fieldType := schemaField.FieldType
+	for fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+	if fieldType != decimalType {
+		return nil, errors.Errorf("field %s is not primitive.Decimal128", schemaField.Name)
+	}
+
+	// $sum gives the integer 0 when no value is summed
+	project := bson.D{{Key: "$project", Value: bson.D{
+		{Key: "v", Value: bson.D{{Key: "$toDecimal", Value: "$v"}}},
+	}}}
+	return th.Aggregation().Match(filter).Stage(groupField("$sum", schemaField)).Stage(project).Build()
+}
+
+// CountBy number of the documents matched by filter for every value of field, e.g. for histograms.
+// Keys are the values decoded as interface, e.g. string, int32, primitive.ObjectID, nil for null or missing,
+// documents and arrays are keyed by their extended json since they can not be map keys
+func (th *Collection[MODEL, ID]) CountBy(ctx context.Context, field string, filter any) (map[any]int64, error) {
+	pipeline, err := th.countByPipeline(field, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []struct {
+		Value bson.RawValue `bson:"_id"`
+		Count int64         `bson:"n"`
+	}
+	err = th.Aggregate(ctx, pipeline, &results)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	counts := make(map[any]int64, len(results))
+	for _, result := range results {
+		key, err := groupKey(result.Value)
+		if err != nil {
+			return nil, err
+		}
+		counts[key] = result.Count
+	}
+	return counts, nil
+}
+
+func (th *Collection[MODEL, ID]) countByPipeline(field string, filter any) (mongo.Pipeline, error) {
+	schemaField, err := th.mustSchemaField(field)
+	if err != nil {
+		return nil, err
+	}
+
+	group := bson.D{{Key: "$group", Value: bson.D{
+		{Key: "_id", Value: "$" + schemaField.DBName},
+		{Key: "n", Value: bson.D{{Key: "$sum", Value: 1}}},
+	}}}
+	return th.Aggregation().Match(filter).Stage(group).Build()
+}
+
+// groupKey the value of a group as a map key
+func groupKey(value bson.RawValue) (any, error) {
+	switch value.Type {
+	case bsontype.Null, bsontype.Undefined, 0:
+		return nil, nil
+	case bsontype.EmbeddedDocument, bsontype.Array:
+		return value.String(), nil
+	}
+
+	var key any
+	if err := value.UnmarshalWithRegistry(DefaultRegistry, &key); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return key, nil
+}
+
+func (th *Collection[MODEL, ID]) aggregateField(ctx context.Context, operator string, field string, filter any) (float64, error) {
+	pipeline, err := th.aggregateFieldPipeline(operator, field, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	var results []struct {
+		Value float64 `bson:"v"`
+	}
+	err = th.Aggregate(ctx, pipeline, &results)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	if len(results) == 0 {
+		return 0, nil
+	}
+	return results[0].Value, nil
+}
+
+func (th *Collection[MODEL, ID]) aggregateFieldPipeline(operator string, field string, filter any) (mongo.Pipeline, error) {
+	schemaField, err := th.mustSchemaField(field)
+	if err != nil {
+		return nil, err
+	}
+
+	fieldType := schemaField.FieldType
+	for fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+	switch fieldType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+	default:
+		return nil, errors.Errorf("field %s is not numeric", schemaField.Name)
+	}
+
+	return th.Aggregation().Match(filter).Stage(groupField(operator, schemaField)).Build()
+}
+
+// groupField the stage applying the accumulator operator to field over all documents
+func groupField(operator string, field *entity.EntityField) bson.D {
+	return bson.D{{Key: "$group", Value: bson.D{
+		{Key: "_id", Value: nil},
+		{Key: "v", Value: bson.D{{Key: operator, Value: "$" + field.DBName}}},
+	}}}
+}
+
+func (th *AggregateBuilder[MODEL, ID]) countPipeline() (mongo.Pipeline, error) {
+	return th.terminate(bson.D{{Key: "$count", Value: "count"}})
+}
+
+// return a copy of the pipeline ended with stage, the builder itself is unchanged
+func (th *AggregateBuilder[MODEL, ID]) terminate(stage bson.D) (mongo.Pipeline, error) {
+	if th.err != nil {
+		return nil, th.err
+	}
+	pipeline := make(mongo.Pipeline, 0, len(th.pipeline)+1)
+	pipeline = append(pipeline, th.pipeline...)
+	return append(pipeline, stage), nil
+}
+
+// targetCollection the collection written by $merge or $out, into is a collection name or a model,
+// the entity of the model is returned to resolve its fields
+func targetCollection(into any) (string, *entity.Entity, error) {
+	if name, ok := into.(string); ok {
+		return name, nil, nil
+	}
+	schema, err := entity.GetOrParseDocument(into)
+	if err != nil {
+		return "", nil, err
+	}
+	return schema.Collection, schema, nil
+}
+
+// endsWith whether the last stage of the pipeline is the stage named by key
+func (th *AggregateBuilder[MODEL, ID]) endsWith(key string) bool {
+	if len(th.pipeline) == 0 {
+		return false
+	}
+	last := th.pipeline[len(th.pipeline)-1]
+	return len(last) > 0 && last[0].Key == key
+}
+
+func (th *AggregateBuilder[MODEL, ID]) fail(err error) *AggregateBuilder[MODEL, ID] {
+	if th.err == nil {
+		th.err = err
+	}
+	return th
+}
@@ -0,0 +1,658 @@
+package jmongo
+
+import (
+	"context"
+	"github.com/JackWSK/jmongo/entity"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"reflect"
+	"testing"
+)
+
+func Test_Aggregate_CountPipeline(t *testing.T) {
+	col := newOfflineCollection(t)
+
+	builder := col.Aggregation().Match(TestFilter{Id: "6425087c44ad0aff2c691cea"})
+	pipeline, err := builder.countPipeline()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	expect := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"_id": SObjectId("6425087c44ad0aff2c691cea")}}},
+		{{Key: "$count", Value: "count"}},
+	}
+	if !reflect.DeepEqual(pipeline, expect) {
+		t.Errorf("unexpected pipeline %v", pipeline)
+	}
+
+	// the builder is not changed by the terminal stage
+	if built, _ := builder.Build(); len(built) != 1 {
+		t.Errorf("expect 1 stage left in builder, got %d", len(built))
+	}
+}
+
+func Test_Aggregate_Count(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Test, SObjectId](&Test{}, c.Database("test"))
+
+	count, err := col.Aggregation().Match(bson.M{"name": "abc"}).Count(context.Background())
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	expect, err := col.Count(context.Background(), bson.M{"name": "abc"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if count != expect {
+		t.Errorf("expect %d, got %d", expect, count)
+	}
+}
+
+func Test_Aggregate_SamplePipeline(t *testing.T) {
+	col := newOfflineCollection(t)
+
+	pipeline, err := col.samplePipeline(2, bson.M{"name": "abc"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	expect := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"name": "abc"}}},
+		{{Key: "$sample", Value: bson.D{{Key: "size", Value: 2}}}},
+	}
+	if !reflect.DeepEqual(pipeline, expect) {
+		t.Errorf("unexpected pipeline %v", pipeline)
+	}
+
+	if _, err := col.samplePipeline(0, nil); err == nil {
+		t.Error("expect error for sample size 0")
+	}
+}
+
+func Test_Aggregate_Sample(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Test, SObjectId](&Test{}, c.Database("test"))
+	ctx := context.Background()
+
+	_, err := col.Delete(ctx, bson.M{"name": "sample"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := col.InsertOne(ctx, &Test{Name: "sample", Age: i}); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+
+	results, err := col.Sample(ctx, 3, bson.M{"name": "sample"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(results) != 3 {
+		t.Errorf("expect 3 documents, got %d", len(results))
+	}
+}
+
+func Test_Aggregate_Project(t *testing.T) {
+	col := newOfflineCollection(t)
+
+	pipeline, err := col.Aggregation().
+		Project(Project().Include("Age").Include("Name").As("fullName").Compute("double", bson.M{"$multiply": bson.A{"$happy", 2}})).
+		Build()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	expect := mongo.Pipeline{
+		{{Key: "$project", Value: bson.D{
+			{Key: "_id", Value: 1},
+			{Key: "happy", Value: 1},
+			{Key: "fullName", Value: "$name"},
+			{Key: "double", Value: bson.M{"$multiply": bson.A{"$happy", 2}}},
+		}}},
+	}
+	if !reflect.DeepEqual(pipeline, expect) {
+		t.Errorf("unexpected pipeline %v", pipeline)
+	}
+
+	pipeline, err = col.Aggregation().Project(Project().Exclude("UserPassword").ExcludeID()).Build()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect = mongo.Pipeline{
+		{{Key: "$project", Value: bson.D{{Key: "_id", Value: 0}, {Key: "userPassword", Value: 0}}}},
+	}
+	if !reflect.DeepEqual(pipeline, expect) {
+		t.Errorf("unexpected pipeline %v", pipeline)
+	}
+
+	if _, err := col.Aggregation().Project(Project().Include("Name").Exclude("Age")).Build(); err == nil {
+		t.Error("expect error when mixing inclusion and exclusion")
+	}
+}
+
+func Test_Aggregate_FieldPipeline(t *testing.T) {
+	col := NewCollection[*Product, SObjectId](&Product{}, newOfflineDatabase(t))
+
+	pipeline, err := col.aggregateFieldPipeline("$sum", "Stock", bson.M{"code": "a"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"code": "a"}}},
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: nil}, {Key: "v", Value: bson.D{{Key: "$sum", Value: "$stock"}}}}}},
+	}
+	if !reflect.DeepEqual(pipeline, expect) {
+		t.Errorf("unexpected pipeline %v", pipeline)
+	}
+
+	if _, err := col.aggregateFieldPipeline("$sum", "Name", nil); err == nil {
+		t.Error("expect error for a field which is not numeric")
+	}
+}
+
+func Test_Aggregate_Fields(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Product, SObjectId](&Product{}, c.Database("test"))
+	ctx := context.Background()
+
+	filter := bson.M{"code": "aggregate"}
+	if _, err := col.Delete(ctx, filter); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for _, stock := range []int{1, 2, 6} {
+		if err := col.InsertOne(ctx, &Product{Code: "aggregate", Stock: stock}); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+
+	for _, c := range []struct {
+		name      string
+		aggregate func(ctx context.Context, field string, filter any) (float64, error)
+		expect    float64
+	}{
+		{"sum", col.SumField, 9},
+		{"min", col.MinField, 1},
+		{"max", col.MaxField, 6},
+		{"avg", col.AvgField, 3},
+	} {
+		value, err := c.aggregate(ctx, "Stock", filter)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if value != c.expect {
+			t.Errorf("expect %s %v, got %v", c.name, c.expect, value)
+		}
+	}
+}
+
+type Payment struct {
+	Id     SObjectId            `bson:"_id,omitempty"`
+	Code   string               `bson:"code"`
+	Amount primitive.Decimal128 `bson:"amount"`
+}
+
+func Test_Aggregate_SumDecimalPipeline(t *testing.T) {
+	col := NewCollection[*Payment, SObjectId](&Payment{}, newOfflineDatabase(t))
+
+	pipeline, err := col.sumDecimalPipeline("Amount", bson.M{"code": "a"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"code": "a"}}},
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: nil}, {Key: "v", Value: bson.D{{Key: "$sum", Value: "$amount"}}}}}},
+		{{Key: "$project", Value: bson.D{{Key: "v", Value: bson.D{{Key: "$toDecimal", Value: "$v"}}}}}},
+	}
+	if !reflect.DeepEqual(pipeline, expect) {
+		t.Errorf("unexpected pipeline %v", pipeline)
+	}
+
+	if _, err := col.sumDecimalPipeline("Code", nil); err == nil {
+		t.Error("expect error for a field which is not decimal")
+	}
+}
+
+func Test_Aggregate_SumDecimal(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Payment, SObjectId](&Payment{}, c.Database("test"))
+	ctx := context.Background()
+
+	filter := bson.M{"code": "sumDecimal"}
+	if _, err := col.Delete(ctx, filter); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	// 0.1 + 0.2 is not 0.3 in float64
+	for _, amount := range []string{"0.1", "0.2", "1000000000000000.01"} {
+		value, err := primitive.ParseDecimal128(amount)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := col.InsertOne(ctx, &Payment{Code: "sumDecimal", Amount: value}); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+
+	sum, err := col.SumDecimal(ctx, "Amount", filter)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if sum.String() != "1000000000000000.31" {
+		t.Errorf("expect 1000000000000000.31, got %s", sum)
+	}
+}
+
+type StockByCode struct {
+	Code  string `bson:"_id"`
+	Stock int    `bson:"stock"`
+}
+
+func Test_Aggregate_EachDecoded(t *testing.T) {
+	documents := []any{bson.M{"_id": "a", "stock": 1}, bson.M{"_id": "b", "stock": 2}, bson.M{"_id": "c", "stock": 3}}
+	cursor, err := mongo.NewCursorFromDocuments(documents, nil, DefaultRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var codes []string
+	stop := errors.New("stop")
+	decode := rawDecoder[StockByCode](newOfflineCollection(t))
+	err = eachDecoded(context.Background(), cursor, decode, func(doc StockByCode) error {
+		codes = append(codes, doc.Code)
+		if doc.Stock == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Errorf("expect the error of the callback, got %v", err)
+	}
+	if !reflect.DeepEqual(codes, []string{"a", "b"}) {
+		t.Errorf("expect to stop after b, got %v", codes)
+	}
+}
+
+func Test_Aggregate_EachDecodedModel(t *testing.T) {
+	col := NewCollection[*Contract, SObjectId](&Contract{}, newOfflineDatabase(t))
+	documents := []any{bson.M{"_id": primitive.NewObjectID(), "title": "a", "createdAt": int64(1700000000)}}
+	cursor, err := mongo.NewCursorFromDocuments(documents, nil, DefaultRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the unix time is decoded by its setter as Find does
+	var contracts []*Contract
+	err = eachDecoded(context.Background(), cursor, rawDecoder[*Contract](col), func(doc *Contract) error {
+		contracts = append(contracts, doc)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(contracts) != 1 || contracts[0].CreatedAt.Unix() != 1700000000 {
+		t.Errorf("expect the model decoded with its unix time, got %+v", contracts)
+	}
+}
+
+func Test_Aggregate_Each(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Product, SObjectId](&Product{}, c.Database("test"))
+	ctx := context.Background()
+
+	filter := bson.M{"code": bson.M{"$in": bson.A{"each-a", "each-b"}}}
+	if _, err := col.Delete(ctx, filter); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for _, product := range []*Product{{Code: "each-a", Stock: 1}, {Code: "each-a", Stock: 2}, {Code: "each-b", Stock: 5}} {
+		if err := col.InsertOne(ctx, product); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$code"}, {Key: "stock", Value: bson.D{{Key: "$sum", Value: "$stock"}}}}}},
+	}
+	stocks := map[string]int{}
+	err := AggregateEach(ctx, col, pipeline, func(doc StockByCode) error {
+		stocks[doc.Code] = doc.Stock
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(stocks, map[string]int{"each-a": 3, "each-b": 5}) {
+		t.Errorf("unexpected stocks %v", stocks)
+	}
+}
+
+type TestFlags struct {
+	Name     string `bson:"name"`
+	HasOrder bool   `bson:"hasOrder"`
+}
+
+func Test_Aggregate_ProjectHasValue(t *testing.T) {
+	col := newOfflineCollection(t)
+
+	pipeline, err := col.Aggregation().Project(Project().ExcludeID().Include("Name").HasValue("hasOrder", "OrderId")).Build()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	hasValue := bson.M{"$ne": bson.A{bson.M{"$ifNull": bson.A{"$orderId", nil}}, nil}}
+	expect := mongo.Pipeline{
+		{{Key: "$project", Value: bson.D{{Key: "_id", Value: 0}, {Key: "name", Value: 1}, {Key: "hasOrder", Value: hasValue}}}},
+	}
+	if !reflect.DeepEqual(pipeline, expect) {
+		t.Errorf("unexpected pipeline %v", pipeline)
+	}
+
+	// the output of the stage decodes into the dto
+	cursor, err := mongo.NewCursorFromDocuments([]any{bson.M{"name": "a", "hasOrder": true}, bson.M{"name": "b", "hasOrder": false}}, nil, DefaultRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var flags []TestFlags
+	if err := cursor.All(context.Background(), &flags); err != nil {
+		t.Fatal(err)
+	}
+	if len(flags) != 2 || !flags[0].HasOrder || flags[1].HasOrder {
+		t.Errorf("unexpected flags %+v", flags)
+	}
+}
+
+func Test_Aggregate_HasValue(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Test, SObjectId](&Test{}, c.Database("test"))
+	ctx := context.Background()
+
+	if _, err := col.Delete(ctx, bson.M{"name": "flags"}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := col.InsertOne(ctx, &Test{Name: "flags", OrderId: NewSObjectId()}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := col.InsertOne(ctx, &Test{Name: "flags"}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	pipeline, err := col.Aggregation().Match(bson.M{"name": "flags"}).Project(Project().Include("Name").HasValue("hasOrder", "OrderId")).Build()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var flags []TestFlags
+	if err := col.Aggregate(ctx, pipeline, &flags); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	count := 0
+	for _, flag := range flags {
+		if flag.HasOrder {
+			count++
+		}
+	}
+	if len(flags) != 2 || count != 1 {
+		t.Errorf("expect one of two with order, got %+v", flags)
+	}
+}
+
+func Test_Aggregate_CountByPipeline(t *testing.T) {
+	col := NewCollection[*Product, SObjectId](&Product{}, newOfflineDatabase(t))
+
+	pipeline, err := col.countByPipeline("Code", bson.M{"stock": 1})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"stock": 1}}},
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$code"}, {Key: "n", Value: bson.D{{Key: "$sum", Value: 1}}}}}},
+	}
+	if !reflect.DeepEqual(pipeline, expect) {
+		t.Errorf("unexpected pipeline %v", pipeline)
+	}
+
+	members := NewCollection[*Member, SObjectId](&Member{}, newOfflineDatabase(t))
+	pipeline, err = members.countByPipeline("Phone", bson.M{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if match := pipeline[0][0].Value; !reflect.DeepEqual(match, bson.M{"deletedAt": nil}) {
+		t.Errorf("expect the soft deleted documents excluded, got %v", match)
+	}
+
+	// keys of the groups
+	for _, c := range []struct {
+		value  any
+		expect any
+	}{
+		{"a", "a"},
+		{int32(3), int32(3)},
+		{nil, nil},
+	} {
+		valueType, data, _ := bson.MarshalValue(c.value)
+		key, err := groupKey(bson.RawValue{Type: valueType, Value: data})
+		if err != nil || key != c.expect {
+			t.Errorf("expect key %v, got %v %v", c.expect, key, err)
+		}
+	}
+	data, _ := bson.Marshal(bson.M{"x": 1})
+	if key, _ := groupKey(bson.RawValue{Type: bsontype.EmbeddedDocument, Value: data}); key != `{"x": {"$numberInt":"1"}}` {
+		t.Errorf("expect a document keyed by json, got %v", key)
+	}
+}
+
+func Test_CountBy(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Product, SObjectId](&Product{}, c.Database("test"))
+	ctx := context.Background()
+
+	filter := bson.M{"stock": bson.M{"$gte": 100}}
+	if _, err := col.Delete(ctx, filter); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for _, code := range []string{"a", "b", "a", "c", "a"} {
+		if err := col.InsertOne(ctx, &Product{Code: code, Stock: 100}); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+
+	counts, err := col.CountBy(ctx, "Code", filter)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := map[any]int64{"a": 3, "b": 1, "c": 1}
+	if !reflect.DeepEqual(counts, expect) {
+		t.Errorf("expect %v, got %v", expect, counts)
+	}
+}
+
+type AgeSummary struct {
+	Name  string `bson:"_id"`
+	Total int    `bson:"total"`
+}
+
+func Test_Aggregate_Merge(t *testing.T) {
+	col := newOfflineCollection(t)
+
+	pipeline, err := col.Aggregation().
+		Stage(bson.D{{Key: "$group", Value: bson.M{"_id": "$name", "total": bson.M{"$sum": "$happy"}}}}).
+		Merge(&AgeSummary{}, []string{"Name"}, "replace", "insert").
+		Build()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := bson.D{{Key: "$merge", Value: bson.D{
+		{Key: "into", Value: "ageSummary"},
+		{Key: "on", Value: bson.A{"_id"}},
+		{Key: "whenMatched", Value: "replace"},
+		{Key: "whenNotMatched", Value: "insert"},
+	}}}
+	if len(pipeline) != 2 || !reflect.DeepEqual(pipeline[1], expect) {
+		t.Errorf("unexpected pipeline %v", pipeline)
+	}
+
+	pipeline, err = col.Aggregation().Merge("views", nil, "", "").Build()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(pipeline[0], bson.D{{Key: "$merge", Value: bson.D{{Key: "into", Value: "views"}}}}) {
+		t.Errorf("unexpected pipeline %v", pipeline)
+	}
+
+	if _, err := col.Aggregation().Merge(&AgeSummary{}, []string{"Unknown"}, "", "").Build(); err == nil {
+		t.Error("expect error for unknown on field")
+	}
+	if err := col.Aggregation().Match(bson.M{"name": "a"}).Run(context.Background()); err == nil {
+		t.Error("expect error for a pipeline not ended by $merge")
+	}
+}
+
+func Test_Aggregate_Out(t *testing.T) {
+	col := newOfflineCollection(t)
+
+	pipeline, err := col.Aggregation().Match(bson.M{"name": "a"}).Out(&AgeSummary{}).Build()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	schema, _ := entity.GetOrParseDocument(&AgeSummary{})
+	if len(pipeline) != 2 || !reflect.DeepEqual(pipeline[1], bson.D{{Key: "$out", Value: schema.Collection}}) {
+		t.Errorf("unexpected pipeline %v", pipeline)
+	}
+
+	if _, err := col.Aggregation().Out(1).Build(); err == nil {
+		t.Error("expect error for a target which is not a model")
+	}
+}
+
+type Sale struct {
+	Id     SObjectId `bson:"_id,omitempty"`
+	Amount float64   `bson:"amount"`
+}
+
+func Test_Aggregate_Bucket(t *testing.T) {
+	col := NewCollection[*Sale, SObjectId](&Sale{}, newOfflineDatabase(t))
+
+	pipeline, err := col.Aggregation().Bucket("Amount", []float64{0, 100, 1000}, "other", bson.M{"total": bson.M{"$sum": "$amount"}}).Build()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := mongo.Pipeline{{{Key: "$bucket", Value: bson.D{
+		{Key: "groupBy", Value: "$amount"},
+		{Key: "boundaries", Value: []float64{0, 100, 1000}},
+		{Key: "default", Value: "other"},
+		{Key: "output", Value: bson.M{"total": bson.M{"$sum": "$amount"}}},
+	}}}}
+	if !reflect.DeepEqual(pipeline, expect) {
+		t.Errorf("unexpected pipeline %v", pipeline)
+	}
+
+	pipeline, err = col.Aggregation().BucketAuto("amount", 4, nil).Build()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect = mongo.Pipeline{{{Key: "$bucketAuto", Value: bson.D{{Key: "groupBy", Value: "$amount"}, {Key: "buckets", Value: 4}}}}}
+	if !reflect.DeepEqual(pipeline, expect) {
+		t.Errorf("unexpected pipeline %v", pipeline)
+	}
+
+	if _, err := col.Aggregation().Bucket("Price", []float64{0, 1}, nil, nil).Build(); err == nil {
+		t.Error("expect error for unknown field")
+	}
+	if _, err := col.Aggregation().Match(bson.M{}).Buckets(context.Background()); err == nil {
+		t.Error("expect error for a pipeline not ended by $bucket")
+	}
+}
+
+func Test_BucketResult_Decode(t *testing.T) {
+	data, err := bson.Marshal(bson.M{"_id": 100.0, "count": 3, "total": 450.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result BucketResult
+	if err := bson.UnmarshalWithRegistry(DefaultRegistry, data, &result); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if result.Id != 100.0 || result.Count != 3 || !reflect.DeepEqual(result.Output, bson.M{"total": 450.5}) {
+		t.Errorf("unexpected bucket %+v", result)
+	}
+}
+
+func Test_Aggregate_GroupSortLimit(t *testing.T) {
+	col := newOfflineCollection(t)
+
+	pipeline, err := col.Aggregation().
+		Match(TestFilter{Id: "6425087c44ad0aff2c691cea"}).
+		Group("Name", bson.M{"total": bson.M{"$sum": "$happy"}, "count": bson.M{"$sum": 1}}).
+		Sort("total", false).
+		Sort("Age", true).
+		Skip(10).
+		Limit(5).
+		Build()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	expect := mongo.Pipeline{
+		pipeline[0],
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$name"},
+			{Key: "count", Value: bson.M{"$sum": 1}},
+			{Key: "total", Value: bson.M{"$sum": "$happy"}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "total", Value: -1}, {Key: "happy", Value: 1}}}},
+		{{Key: "$skip", Value: int64(10)}},
+		{{Key: "$limit", Value: int64(5)}},
+	}
+	if !reflect.DeepEqual(pipeline, expect) {
+		t.Errorf("unexpected pipeline %v", pipeline)
+	}
+
+	// a $sort of Stage is not a bson.D to append to
+	pipeline, err = col.Aggregation().Stage(bson.D{{Key: "$sort", Value: bson.M{"total": -1}}}).Sort("Age", true).Build()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect = mongo.Pipeline{
+		{{Key: "$sort", Value: bson.M{"total": -1}}},
+		{{Key: "$sort", Value: bson.D{{Key: "happy", Value: 1}}}},
+	}
+	if !reflect.DeepEqual(pipeline, expect) {
+		t.Errorf("expect a new $sort after the one of Stage, got %v", pipeline)
+	}
+
+	pipeline, err = col.Aggregation().Group(nil, bson.M{"n": bson.M{"$sum": 1}}).Build()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(pipeline[0], bson.D{{Key: "$group", Value: bson.D{{Key: "_id", Value: nil}, {Key: "n", Value: bson.M{"$sum": 1}}}}}) {
+		t.Errorf("unexpected group %v", pipeline[0])
+	}
+}
+
+func Test_Aggregate_All(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Test, SObjectId](&Test{}, c.Database("test"))
+	ctx := context.Background()
+
+	for _, age := range []int{1, 2} {
+		if err := col.InsertOne(ctx, &Test{Id: NewSObjectId(), Name: "all", Age: age}); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+
+	var results []struct {
+		Name  string `bson:"_id"`
+		Total int    `bson:"total"`
+	}
+	err := col.Aggregation().Match(bson.M{"name": "all"}).Group("Name", bson.M{"total": bson.M{"$sum": "$happy"}}).All(ctx, &results)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(results) != 1 || results[0].Total < 3 {
+		t.Errorf("unexpected results %+v", results)
+	}
+}
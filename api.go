@@ -0,0 +1,38 @@
+package jmongo
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CollectionAPI read and write methods of Collection,
+// keep a collection as CollectionAPI in services, so tests can replace it with jmongotest.FakeCollection
+type CollectionAPI[MODEL any, ID any] interface {
+	FindOneById(ctx context.Context, id ID, opts ...*FindOption) (MODEL, error)
+
+	FindOneByFilter(ctx context.Context, filter any, opts ...*FindOption) (MODEL, error)
+
+	Find(ctx context.Context, filter any, opts ...*FindOption) ([]MODEL, error)
+
+	Count(ctx context.Context, filter any, opts ...*FindOption) (int64, error)
+
+	Exists(ctx context.Context, filter any, opts ...*FindOption) (bool, error)
+
+	InsertOne(ctx context.Context, model MODEL, opts ...*options.InsertOneOptions) error
+
+	InsertMany(ctx context.Context, models []MODEL, opts ...*options.InsertManyOptions) ([]ID, error)
+
+	UpdateOneById(ctx context.Context, id ID, model MODEL, opts ...*options.UpdateOptions) (bool, error)
+
+	UpdateOne(ctx context.Context, filter any, model MODEL, opts ...*UpdateOption) (matched int64, modified int64, err error)
+
+	UpdateMany(ctx context.Context, filter any, model MODEL, opts ...*options.UpdateOptions) (int64, error)
+
+	DeleteOneById(ctx context.Context, id ID) (bool, error)
+
+	DeleteOne(ctx context.Context, filter any) (bool, error)
+
+	Delete(ctx context.Context, filter any) (bool, error)
+}
+
+var _ CollectionAPI[any, any] = (*Collection[any, any])(nil)
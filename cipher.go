@@ -0,0 +1,149 @@
+package jmongo
+
+import (
+	"github.com/JackWSK/jmongo/entity"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"reflect"
+)
+
+// Cipher encrypt the fields tagged by jmongo:"encrypt" before they are written and decrypt them when read,
+// the ciphertext is stored as binary
+type Cipher interface {
+	Encrypt(plaintext []byte) ([]byte, error)
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+// SetCipher set the cipher of the encrypted fields of every collection of the client,
+// call it when setting up the client
+func (c *Client) SetCipher(cipher Cipher) {
+	c.cipher = cipher
+}
+
+func (th *Collection[MODEL, ID]) cipher() (Cipher, error) {
+	if th.client == nil || th.client.cipher == nil {
+		return nil, errors.New("the client has no cipher for the encrypted fields, call Client.SetCipher")
+	}
+	return th.client.cipher, nil
+}
+
+// encryptValue the ciphertext of the value of an encrypted field, a nil []byte is kept as null
+func (th *Collection[MODEL, ID]) encryptValue(field *entity.EntityField, value any) (any, error) {
+	var plaintext []byte
+	switch v := reflect.ValueOf(value); {
+	case v.Kind() == reflect.String:
+		plaintext = []byte(v.String())
+	case v.Kind() == reflect.Slice && v.IsNil():
+		return nil, nil
+	case v.Kind() == reflect.Slice:
+		plaintext = v.Bytes()
+	default:
+		return nil, errors.Errorf("can not encrypt %T of field %s", value, field.Name)
+	}
+
+	cipher, err := th.cipher()
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := cipher.Encrypt(plaintext)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return primitive.Binary{Data: ciphertext}, nil
+}
+
+// setDecrypted set the string or []byte field from the ciphertext
+func (th *Collection[MODEL, ID]) setDecrypted(field *entity.EntityField, value bson.RawValue, target reflect.Value) error {
+	switch value.Type {
+	case bsontype.Null, bsontype.Undefined:
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	case bsontype.Binary:
+	default:
+		return errors.Errorf("can not decrypt %s of encrypted field %s", value.Type, field.Name)
+	}
+
+	cipher, err := th.cipher()
+	if err != nil {
+		return err
+	}
+	_, ciphertext := value.Binary()
+	plaintext, err := cipher.Decrypt(ciphertext)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if target.Kind() == reflect.String {
+		target.SetString(string(plaintext))
+	} else {
+		target.SetBytes(plaintext)
+	}
+	return nil
+}
+
+// encryptUpdate replace the values of the encrypted fields in the $set of an update by their ciphertext
+func (th *Collection[MODEL, ID]) encryptUpdate(set bson.M) error {
+	for _, field := range th.schema.EncryptedFields {
+		object, ok := set[field.DBName]
+		if !ok {
+			continue
+		}
+		ciphertext, err := th.encryptValue(field, object)
+		if err != nil {
+			return err
+		}
+		set[field.DBName] = ciphertext
+	}
+	return nil
+}
+
+// encryptDocument the document to insert for model, the values of the encrypted fields are replaced by their ciphertext.
+// model is returned as it is when it has no encrypted field
+func (th *Collection[MODEL, ID]) encryptDocument(model any) (any, error) {
+	if len(th.schema.EncryptedFields) == 0 {
+		return model, nil
+	}
+
+	raw, err := bson.MarshalWithRegistry(DefaultRegistry, model)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	elements, err := bson.Raw(raw).Elements()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	encrypted := map[string]*entity.EntityField{}
+	for _, field := range th.schema.EncryptedFields {
+		encrypted[field.DBName] = field
+	}
+
+	value := reflect.ValueOf(model)
+	index, doc := bsoncore.AppendDocumentStart(nil)
+	for _, element := range elements {
+		field, ok := encrypted[element.Key()]
+		if !ok {
+			doc = append(doc, element...)
+			continue
+		}
+
+		object, _ := field.ValueOf(value)
+		ciphertext, err := th.encryptValue(field, object)
+		if err != nil {
+			return nil, err
+		}
+		if binary, ok := ciphertext.(primitive.Binary); ok {
+			doc = bsoncore.AppendBinaryElement(doc, field.DBName, binary.Subtype, binary.Data)
+		} else {
+			doc = bsoncore.AppendNullElement(doc, field.DBName)
+		}
+	}
+	doc, err = bsoncore.AppendDocumentEnd(doc, index)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return bson.Raw(doc), nil
+}
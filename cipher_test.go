@@ -0,0 +1,242 @@
+package jmongo
+
+import (
+	"bytes"
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"testing"
+)
+
+// reverseCipher reverses the bytes behind a prefix, enough to tell ciphertext from plaintext
+type reverseCipher struct{}
+
+func (reverseCipher) Encrypt(plaintext []byte) ([]byte, error) {
+	return append([]byte("enc:"), reverse(plaintext)...), nil
+}
+
+func (reverseCipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	return reverse(bytes.TrimPrefix(ciphertext, []byte("enc:"))), nil
+}
+
+func reverse(data []byte) []byte {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[len(data)-1-i] = b
+	}
+	return out
+}
+
+type Patient struct {
+	Id     SObjectId `bson:"_id,omitempty"`
+	Name   string    `bson:"name"`
+	Phone  string    `bson:"phone" jmongo:"encrypt"`
+	Record []byte    `bson:"record" jmongo:"encrypt"`
+}
+
+func Test_Cipher_RoundTrip(t *testing.T) {
+	database := newOfflineDatabase(t)
+	database.client.SetCipher(reverseCipher{})
+	col := NewCollection[*Patient, SObjectId](&Patient{}, database)
+
+	patient := &Patient{Id: NewSObjectId(), Name: "abc", Phone: "123456", Record: []byte("xyz")}
+	document, err := col.encryptDocument(patient)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	// ciphertext on the wire
+	raw := document.(bson.Raw)
+	if raw.Lookup("name").StringValue() != "abc" {
+		t.Errorf("expect plain fields to be kept, got %s", raw)
+	}
+	for field, expect := range map[string]string{"phone": "enc:654321", "record": "enc:zyx"} {
+		value := raw.Lookup(field)
+		if value.Type != bsontype.Binary {
+			t.Fatalf("expect %s to be binary, got %s", field, value.Type)
+		}
+		if _, data := value.Binary(); string(data) != expect {
+			t.Errorf("expect %s to be %s, got %s", field, expect, data)
+		}
+	}
+
+	// plaintext in go
+	var decoded *Patient
+	if err := col.decodeRaw(raw, col.schema, &decoded); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if decoded.Name != "abc" || decoded.Phone != "123456" || string(decoded.Record) != "xyz" || decoded.Id != patient.Id {
+		t.Errorf("unexpected decoded %+v", decoded)
+	}
+
+	// updates are encrypted too
+	update, err := col.mapToUpdate(&Patient{Phone: "1"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if set := update["$set"].(bson.M); set["phone"] == "1" {
+		t.Errorf("expect the phone to be encrypted, got %v", set)
+	}
+
+	// so are the updates of the builder
+	update, err = col.builderUpdate(Update().Set("Phone", "1").Set("Name", "abc"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	set := update["$set"].(bson.M)
+	if phone, _ := set["phone"].(primitive.Binary); string(phone.Data) != "enc:1" || set["name"] != "abc" {
+		t.Errorf("expect only the phone to be encrypted, got %v", set)
+	}
+}
+
+func Test_Cipher_Missing(t *testing.T) {
+	col := NewCollection[*Patient, SObjectId](&Patient{}, newOfflineDatabase(t))
+	if _, err := col.encryptDocument(&Patient{Phone: "1"}); err == nil {
+		t.Error("expect error without a cipher")
+	}
+}
+
+func Test_Cipher_Insert(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	c.SetCipher(reverseCipher{})
+	col := NewCollection[*Patient, SObjectId](&Patient{}, c.Database("test"))
+	ctx := context.Background()
+
+	patient := &Patient{Id: NewSObjectId(), Name: "abc", Phone: "123456"}
+	if err := col.InsertOne(ctx, patient); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	stored, err := col.collection.FindOne(ctx, bson.M{"_id": patient.Id}).DecodeBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, data := stored.Lookup("phone").Binary(); string(data) != "enc:654321" {
+		t.Errorf("expect the ciphertext to be stored, got %s", stored)
+	}
+
+	found, err := col.FindOneById(ctx, patient.Id)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if found.Phone != "123456" {
+		t.Errorf("expect the plaintext, got %+v", found)
+	}
+}
+
+func Test_Cipher_UpdateWith(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	c.SetCipher(reverseCipher{})
+	col := NewCollection[*Patient, SObjectId](&Patient{}, c.Database("test"))
+	ctx := context.Background()
+
+	patient := &Patient{Id: NewSObjectId(), Name: "abc", Phone: "123456"}
+	if err := col.InsertOne(ctx, patient); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, _, err := col.UpdateOneWith(ctx, bson.M{"_id": patient.Id}, Update().Set("Phone", "654")); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	found, err := col.FindOneById(ctx, patient.Id)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if found.Phone != "654" {
+		t.Errorf("expect the updated plaintext, got %+v", found)
+	}
+}
+
+func Test_Cipher_BulkWrite(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	c.SetCipher(reverseCipher{})
+	col := NewCollection[*Patient, SObjectId](&Patient{}, c.Database("test"))
+	ctx := context.Background()
+
+	patient := &Patient{Id: NewSObjectId(), Name: "abc", Phone: "123456"}
+	insert := mongo.NewInsertOneModel().SetDocument(patient)
+	if _, err := col.BulkWrite(ctx, []mongo.WriteModel{insert}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if insert.Document != patient {
+		t.Errorf("expect the model of the caller to keep its document, got %v", insert.Document)
+	}
+
+	found, err := col.FindOneById(ctx, patient.Id)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if found.Phone != "123456" {
+		t.Errorf("expect the plaintext, got %+v", found)
+	}
+}
+
+func Test_Cipher_DecodeAggregated(t *testing.T) {
+	database := newOfflineDatabase(t)
+	database.client.SetCipher(reverseCipher{})
+	col := NewCollection[*Patient, SObjectId](&Patient{}, database)
+
+	document, err := col.encryptDocument(&Patient{Id: NewSObjectId(), Name: "abc", Phone: "123456"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	cursor, err := mongo.NewCursorFromDocuments([]any{document}, nil, DefaultRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the results of Sample and AggregateBuilder.All are decrypted as Find does
+	var patients []*Patient
+	if err := col.decodeAggregated(context.Background(), cursor, &patients); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(patients) != 1 || patients[0].Phone != "123456" || patients[0].Name != "abc" {
+		t.Errorf("expect the plaintext, got %+v", patients)
+	}
+}
+
+func Test_Cipher_Sample(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	c.SetCipher(reverseCipher{})
+	col := NewCollection[*Patient, SObjectId](&Patient{}, c.Database("test"))
+	ctx := context.Background()
+
+	patient := &Patient{Id: NewSObjectId(), Name: "sample", Phone: "123456"}
+	if err := col.InsertOne(ctx, patient); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	sampled, err := col.Sample(ctx, 1, bson.M{"_id": patient.Id})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(sampled) != 1 || sampled[0].Phone != "123456" {
+		t.Errorf("expect the plaintext, got %+v", sampled)
+	}
+}
+
+func Benchmark_InsertDocument(b *testing.B) {
+	col := NewCollection[*Patient, SObjectId](&Patient{}, newOfflineDatabase(b))
+	col.client.SetCipher(reverseCipher{})
+	patient := &Patient{Id: NewSObjectId(), Name: "abc", Phone: "123456", Record: []byte("record")}
+
+	// the driver marshals the model itself when no field is encrypted
+	b.Run("marshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := bson.MarshalWithRegistry(DefaultRegistry, patient); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("encryptDocument", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := col.encryptDocument(patient); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -2,27 +2,86 @@ package jmongo
 
 import (
 	"context"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
+	"time"
 )
 
 type Client struct {
 	client *mongo.Client
+	// timeout of operations whose ctx has no deadline, 0 means no timeout
+	defaultTimeout time.Duration
+	retryPolicy    *RetryPolicy
+	operationTimes *operationTimes
+	// encrypt the fields tagged by jmongo:"encrypt"
+	cipher Cipher
+	// database named by the connection string, empty when there is none
+	defaultDatabase string
+	// location of the times read, nil keeps them in UTC
+	location *time.Location
+	// limit of finds without one, 0 means no cap
+	maxUnboundedLimit int64
 }
 
 func NewClient(opts ...*options.ClientOptions) (*Client, error) {
+	client := &Client{operationTimes: &operationTimes{}}
+
+	// record the operation times of writes, the monitor of the caller is kept, the last one wins like the driver
+	var monitor *event.CommandMonitor
+	var uri string
+	for _, opt := range opts {
+		if opt != nil && opt.Monitor != nil {
+			monitor = opt.Monitor
+		}
+		if opt != nil && opt.GetURI() != "" {
+			uri = opt.GetURI()
+		}
+	}
+	opts = append(opts, options.Client().SetMonitor(client.operationTimes.monitor(monitor)))
+
 	c, err := mongo.NewClient(opts...)
 	if err != nil {
 		return nil, err
 	}
-	return &Client{client: c}, nil
+	client.client = c
+
+	if uri != "" {
+		cs, err := connstring.ParseAndValidate(uri)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		client.defaultDatabase = cs.Database
+	}
+	return client, nil
 }
 
 func (c *Client) Client() *mongo.Client {
 	return c.client
 }
 
+// SetDefaultTimeout set the timeout of every operation whose ctx has no deadline,
+// Collection.Timeout and a deadline of ctx take precedence, call it when setting up the client
+func (c *Client) SetDefaultTimeout(d time.Duration) {
+	c.defaultTimeout = d
+}
+
+// SetLocation convert the times read by every collection of the client to location,
+// Collection.InLocation takes precedence, call it when setting up the client
+func (c *Client) SetLocation(location *time.Location) {
+	c.location = location
+}
+
+// SetMaxUnboundedLimit cap every find without a limit at n documents and log a warning, so a forgotten limit
+// does not read a whole collection. Collection.MaxUnboundedLimit takes precedence, call it when setting up the client
+func (c *Client) SetMaxUnboundedLimit(n int64) {
+	c.maxUnboundedLimit = n
+}
+
 func (c *Client) Connect(ctx context.Context) error {
 	return c.client.Connect(ctx)
 }
@@ -31,6 +90,24 @@ func (c *Client) Ping(ctx context.Context, rp *readpref.ReadPref) error {
 	return c.client.Ping(ctx, rp)
 }
 
+// DatabaseNames names of all databases on the server
+func (c *Client) DatabaseNames(ctx context.Context) ([]string, error) {
+	names, err := c.client.ListDatabaseNames(ctx, bson.D{})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return names, nil
+}
+
+// DefaultDatabase the database named by the connection string, e.g. mydb of mongodb://localhost:27017/mydb,
+// an error when the connection string names none
+func (c *Client) DefaultDatabase(opts ...*options.DatabaseOptions) (*Database, error) {
+	if c.defaultDatabase == "" {
+		return nil, errors.New("the connection string names no default database")
+	}
+	return c.Database(c.defaultDatabase, opts...), nil
+}
+
 // Database returns a handle for a database with the given name configured with the given DatabaseOptions.
 func (c *Client) Database(name string, opts ...*options.DatabaseOptions) *Database {
 	return NewDatabase(c.client.Database(name, opts...), c)
@@ -0,0 +1,28 @@
+package jmongo
+
+import (
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"testing"
+)
+
+func Test_Client_DefaultDatabase(t *testing.T) {
+	client, err := NewClient(options.Client().ApplyURI("mongodb://localhost:27017/mydb?connect=direct"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	database, err := client.DefaultDatabase()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if database.Name() != "mydb" {
+		t.Errorf("expect mydb, got %s", database.Name())
+	}
+
+	client, err = NewClient(options.Client().ApplyURI("mongodb://localhost:27017"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.DefaultDatabase(); err == nil {
+		t.Error("expect error without a database in the connection string")
+	}
+}
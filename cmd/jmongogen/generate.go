@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// kinds of the zero check of a field, by the type expression of the field
+const (
+	zeroString = iota
+	zeroNumber
+	zeroBool
+	zeroNil
+	// types which can not be told from the source, e.g. named types, checked by entity.IsZero
+	zeroOther
+)
+
+var basicZeros = map[string]int{
+	"string": zeroString,
+	"bool":   zeroBool,
+	"any":    zeroNil,
+	"error":  zeroNil,
+}
+
+func init() {
+	for _, number := range []string{"int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64",
+		"uintptr", "float32", "float64", "byte", "rune"} {
+		basicZeros[number] = zeroNumber
+	}
+}
+
+type accessorField struct {
+	name string
+	zero int
+}
+
+// generate the source registering the accessors of typeNames declared in src, by init unless noInit
+func generate(filename string, src []byte, typeNames []string, noInit bool) ([]byte, error) {
+	file, err := parser.ParseFile(token.NewFileSet(), filename, src, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by jmongogen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\n", file.Name.Name)
+	fmt.Fprintf(&buf, "import (\n\t\"github.com/JackWSK/jmongo/entity\"\n\t\"reflect\"\n)\n")
+
+	for _, typeName := range typeNames {
+		structType := findStruct(file, typeName)
+		if structType == nil {
+			return nil, fmt.Errorf("struct %s can not be found in %s", typeName, filename)
+		}
+		writeAccessors(&buf, typeName, accessorFields(structType), noInit)
+	}
+
+	return format.Source(buf.Bytes())
+}
+
+func findStruct(file *ast.File, typeName string) *ast.StructType {
+	for _, decl := range file.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range genDecl.Specs {
+			typeSpec := spec.(*ast.TypeSpec)
+			if structType, ok := typeSpec.Type.(*ast.StructType); ok && typeSpec.Name.Name == typeName {
+				return structType
+			}
+		}
+	}
+	return nil
+}
+
+// accessorFields the stored fields declared by the struct, embedded and inline fields are left to reflection
+func accessorFields(structType *ast.StructType) []accessorField {
+	var fields []accessorField
+	for _, field := range structType.Fields.List {
+		if len(field.Names) == 0 {
+			continue
+		}
+
+		var tag reflect.StructTag
+		if field.Tag != nil {
+			unquoted, _ := strconv.Unquote(field.Tag.Value)
+			tag = reflect.StructTag(unquoted)
+		}
+		bsonTag := tag.Get("bson")
+		if bsonTag == "-" || strings.Contains(bsonTag, ",inline") {
+			continue
+		}
+
+		for _, name := range field.Names {
+			if name.IsExported() {
+				fields = append(fields, accessorField{name: name.Name, zero: zeroKind(field.Type)})
+			}
+		}
+	}
+	return fields
+}
+
+func zeroKind(expr ast.Expr) int {
+	switch v := expr.(type) {
+	case *ast.Ident:
+		if zero, ok := basicZeros[v.Name]; ok {
+			return zero
+		}
+	case *ast.StarExpr, *ast.MapType, *ast.InterfaceType, *ast.FuncType, *ast.ChanType:
+		return zeroNil
+	case *ast.ArrayType:
+		// slices, arrays are compared element by element
+		if v.Len == nil {
+			return zeroNil
+		}
+	}
+	return zeroOther
+}
+
+func writeAccessors(buf *bytes.Buffer, typeName string, fields []accessorField, noInit bool) {
+	modelOf := "jmongogen" + typeName
+
+	if noInit {
+		register := "register" + typeName + "Accessors"
+		fmt.Fprintf(buf, "\n// %s use the generated accessors for %s until entity.UnregisterAccessors\n", register, typeName)
+		fmt.Fprintf(buf, "func %s() {\n", register)
+	} else {
+		fmt.Fprintf(buf, "\nfunc init() {\n")
+	}
+	fmt.Fprintf(buf, "\tentity.RegisterAccessors(&%s{}, map[string]entity.FieldAccessor{\n", typeName)
+	for _, field := range fields {
+		value := "m." + field.name
+		var zero string
+		switch field.zero {
+		case zeroString:
+			zero = value + ` == ""`
+		case zeroNumber:
+			zero = value + " == 0"
+		case zeroBool:
+			zero = "!" + value
+		case zeroNil:
+			zero = value + " == nil"
+		default:
+			zero = "entity.IsZero(" + value + ")"
+		}
+
+		fmt.Fprintf(buf, "\t\t%q: {\n", field.name)
+		fmt.Fprintf(buf, "\t\t\tValueOf: func(value reflect.Value) (any, bool) {\n\t\t\t\tm := %s(value)\n\t\t\t\treturn %s, %s\n\t\t\t},\n", modelOf, value, zero)
+		fmt.Fprintf(buf, "\t\t\tReflectValueOf: func(value reflect.Value) reflect.Value {\n\t\t\t\treturn reflect.ValueOf(&%s(value).%s).Elem()\n\t\t\t},\n", modelOf, field.name)
+		fmt.Fprintf(buf, "\t\t},\n")
+	}
+	fmt.Fprintf(buf, "\t})\n}\n")
+
+	fmt.Fprintf(buf, `
+// %s the model of value, a *%s or an addressable %s
+func %s(value reflect.Value) *%s {
+	if value.Kind() == reflect.Ptr {
+		return value.Interface().(*%s)
+	}
+	if value.CanAddr() {
+		return value.Addr().Interface().(*%s)
+	}
+	model := value.Interface().(%s)
+	return &model
+}
+`, modelOf, typeName, typeName, modelOf, typeName, typeName, typeName, typeName)
+}
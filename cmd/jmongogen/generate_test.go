@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_Generate(t *testing.T) {
+	src := []byte(`package model
+
+type Base struct{ Version int }
+
+type User struct {
+	Base     ` + "`bson:\",inline\"`" + `
+	Name     string
+	Tags     []string
+	Children map[string]int
+	Deleted  bool
+	Extra    any ` + "`bson:\"-\"`" + `
+	secret   string
+}
+`)
+	code, err := generate("model.go", src, []string{"User"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := string(code)
+	for _, want := range []string{`m.Name == ""`, "m.Tags == nil", "m.Children == nil", "!m.Deleted", "func jmongogenUser("} {
+		if !strings.Contains(source, want) {
+			t.Fatalf("generated code has no %q:\n%s", want, source)
+		}
+	}
+	for _, unwanted := range []string{`"Base"`, `"Extra"`, `"secret"`} {
+		if strings.Contains(source, unwanted) {
+			t.Fatalf("generated code has %s:\n%s", unwanted, source)
+		}
+	}
+
+	if _, err := generate("model.go", src, []string{"Group"}, false); err == nil {
+		t.Fatal("expected an error for a missing struct")
+	}
+
+	code, err = generate("model.go", src, []string{"User"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source := string(code); !strings.Contains(source, "func registerUserAccessors() {") || strings.Contains(source, "func init()") {
+		t.Fatalf("expected a register function instead of init:\n%s", source)
+	}
+}
+
+func Test_GeneratedUpToDate(t *testing.T) {
+	src, err := os.ReadFile("../../collection_test.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := generate("collection_test.go", src, []string{"Test"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	committed, err := os.ReadFile("../../collection_jmongo_test.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(code, committed) {
+		t.Fatal("collection_jmongo_test.go is out of date, run go generate")
+	}
+}
+
+func Test_OutputName(t *testing.T) {
+	if name := outputName("model.go"); name != "model_jmongo.go" {
+		t.Fatalf("outputName = %s", name)
+	}
+	if name := outputName("model_test.go"); name != "model_jmongo_test.go" {
+		t.Fatalf("outputName = %s", name)
+	}
+}
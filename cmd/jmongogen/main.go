@@ -0,0 +1,57 @@
+// Command jmongogen generates the field accessors of models, so jmongo reads and sets their fields
+// without walking the struct by reflection, e.g.
+//
+//	//go:generate go run github.com/JackWSK/jmongo/cmd/jmongogen -type User,Order model.go
+//
+// writes model_jmongo.go next to model.go. Inline embedded fields keep using reflection.
+// With -noinit the accessors are registered by calling registerUserAccessors and so on instead of init,
+// e.g. tests using them only for a while
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func main() {
+	typeNames := flag.String("type", "", "comma separated names of the models, required")
+	output := flag.String("o", "", "output file, <file>_jmongo.go by default")
+	noInit := flag.Bool("noinit", false, "write register<Model>Accessors functions instead of init")
+	flag.Parse()
+
+	if *typeNames == "" || flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: jmongogen -type Model[,Model...] [-o output.go] [-noinit] file.go")
+		os.Exit(2)
+	}
+
+	filename := flag.Arg(0)
+	if err := run(filename, strings.Split(*typeNames, ","), *output, *noInit); err != nil {
+		fmt.Fprintf(os.Stderr, "jmongogen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(filename string, typeNames []string, output string, noInit bool) error {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	code, err := generate(filename, src, typeNames, noInit)
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		output = outputName(filename)
+	}
+	return os.WriteFile(output, code, 0644)
+}
+
+// outputName model.go -> model_jmongo.go, model_test.go -> model_jmongo_test.go
+func outputName(filename string) string {
+	if strings.HasSuffix(filename, "_test.go") {
+		return strings.TrimSuffix(filename, "_test.go") + "_jmongo_test.go"
+	}
+	return strings.TrimSuffix(filename, ".go") + "_jmongo.go"
+}
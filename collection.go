@@ -5,13 +5,19 @@ import (
 	"fmt"
 	"github.com/JackWSK/jmongo/entity"
 	"github.com/JackWSK/jmongo/errortype"
+	"github.com/JackWSK/jmongo/extype"
 	filterPkg "github.com/JackWSK/jmongo/filter"
 	"github.com/JackWSK/jmongo/internal/utils"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -20,30 +26,272 @@ type Collection[MODEL any, ID any] struct {
 	collection      *mongo.Collection
 	lastResumeToken bson.Raw
 	client          *Client
+
+	// reads use primary within primaryAfterWrite after the last write, 0 means disabled
+	primaryAfterWrite time.Duration
+	primary           *mongo.Collection
+	// unix nano of the last write
+	lastWrite int64
+	now       func() time.Time
+
+	// timeout of operations whose ctx has no deadline, replace the default timeout of the client, 0 means not set
+	timeout time.Duration
+	// Count uses CountDocuments even without a filter
+	exactCount bool
+	// location of the times read, replace the location of the client, nil means not set
+	location *time.Location
+	// ids of a query of FindByIDs, 0 means defaultIdChunkSize
+	idChunkSize int
+	// limit of finds without one, replace the cap of the client, 0 means not set
+	maxUnboundedLimit int64
 }
 
+// defaultIdChunkSize the ids of a query of FindByIDs, keeping the $in far below the size limit of a command
+const defaultIdChunkSize = 1000
+
 func NewCollection[MODEL any, ID any](model MODEL, database *Database, opts ...*options.CollectionOptions) *Collection[MODEL, ID] {
 	schema, err := entity.GetOrParse(model)
 	if err != nil {
 		panic(err)
 	}
-	col := database.db.Collection(schema.Collection, opts...)
+	return newCollection[MODEL, ID](schema.Collection, schema, database, opts)
+}
+
+// NewCollectionNamed use name as the collection, model is only used for mapping and decoding,
+// e.g. the same model stored in collections sharded by month
+func NewCollectionNamed[MODEL any, ID any](name string, model MODEL, database *Database, opts ...*options.CollectionOptions) *Collection[MODEL, ID] {
+	schema, err := entity.GetOrParse(model)
+	if err != nil {
+		panic(err)
+	}
+	return newCollection[MODEL, ID](name, schema, database, opts)
+}
+
+func newCollection[MODEL any, ID any](name string, schema *entity.Entity, database *Database, opts []*options.CollectionOptions) *Collection[MODEL, ID] {
+	// user options are applied later, so they can still replace the registry
+	opts = append([]*options.CollectionOptions{options.Collection().SetRegistry(DefaultRegistry)}, opts...)
+	col := database.db.Collection(name, opts...)
 
 	return &Collection[MODEL, ID]{
 		collection: col,
 		schema:     schema,
 		client:     database.client,
+		now:        time.Now,
 	}
 }
 
+// Entity the parsed model of the collection
+func (th *Collection[MODEL, ID]) Entity() *entity.Entity {
+	return th.schema
+}
+
 func (th *Collection[MODEL, ID]) Client() *Client {
 	return th.client
 }
 
-func (th *Collection[MODEL, ID]) FindOneById(ctx context.Context, id ID, opts ...*options.FindOneOptions) (MODEL, error) {
+// PrimaryAfterWrite reads from primary within d after a write on this collection,
+// so the collection reads its own writes even if secondaries lag, call it when setting up the collection
+func (th *Collection[MODEL, ID]) PrimaryAfterWrite(d time.Duration) *Collection[MODEL, ID] {
+	primary, err := th.collection.Clone(options.Collection().SetReadPreference(readpref.Primary()))
+	if err != nil {
+		panic(err)
+	}
+	th.primary = primary
+	th.primaryAfterWrite = d
+	return th
+}
+
+// Timeout set the timeout of operations whose ctx has no deadline, it takes precedence over Client.SetDefaultTimeout
+func (th *Collection[MODEL, ID]) Timeout(d time.Duration) *Collection[MODEL, ID] {
+	th.timeout = d
+	return th
+}
+
+// MaxUnboundedLimit cap the finds without a limit at n documents and log a warning, it takes precedence over
+// Client.SetMaxUnboundedLimit. A limit set by the options or Option().NoLimit() is kept, call it when setting up the collection
+func (th *Collection[MODEL, ID]) MaxUnboundedLimit(n int64) *Collection[MODEL, ID] {
+	th.maxUnboundedLimit = n
+	return th
+}
+
+// capLimit add the cap of MaxUnboundedLimit to the options of a find without a limit
+func (th *Collection[MODEL, ID]) capLimit(option *FindOption, findOpts []*options.FindOptions) []*options.FindOptions {
+	limit := th.maxUnboundedLimit
+	if limit == 0 && th.client != nil {
+		limit = th.client.maxUnboundedLimit
+	}
+	if limit <= 0 || (option != nil && (option.limit > 0 || option.noLimit)) || options.MergeFindOptions(findOpts...).Limit != nil {
+		return findOpts
+	}
+
+	if DefaultLogger != nil {
+		DefaultLogger.Warn(fmt.Sprintf("find on %s has no limit, it is capped at %d documents", th.collection.Name(), limit))
+	}
+	return append(findOpts, options.Find().SetLimit(limit))
+}
+
+// InLocation convert the times read to location, it takes precedence over Client.SetLocation.
+// Times are always stored in UTC, call it when setting up the collection
+func (th *Collection[MODEL, ID]) InLocation(location *time.Location) *Collection[MODEL, ID] {
+	th.location = location
+	return th
+}
+
+// readLocation the location of the times read, nil to keep them as decoded
+func (th *Collection[MODEL, ID]) readLocation() *time.Location {
+	if th.location != nil {
+		return th.location
+	}
+	if th.client != nil {
+		return th.client.location
+	}
+	return nil
+}
+
+// withTimeout derive ctx with the timeout of the collection or the client when ctx has no deadline,
+// the caller must call cancel when the operation is done
+func (th *Collection[MODEL, ID]) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+
+	timeout := th.timeout
+	if timeout == 0 && th.client != nil {
+		timeout = th.client.defaultTimeout
+	}
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// deadlineMaxTime the time left before the deadline of ctx, sent as maxTimeMS so that the server stops the operation
+// when the client gives up, ok is false without a deadline or when it has passed
+func deadlineMaxTime(ctx context.Context) (maxTime time.Duration, ok bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	maxTime = time.Until(deadline)
+	if maxTime <= 0 {
+		return 0, false
+	}
+	// maxTimeMS of 0 is no limit
+	if maxTime < time.Millisecond {
+		maxTime = time.Millisecond
+	}
+	return maxTime, true
+}
+
+// retry policy of the client, nil when retrying is disabled
+func (th *Collection[MODEL, ID]) retryPolicy() *RetryPolicy {
+	if th.client == nil {
+		return nil
+	}
+	return th.client.retryPolicy
+}
+
+// collection used by reads
+func (th *Collection[MODEL, ID]) reader() *mongo.Collection {
+	if th.primaryAfterWrite > 0 {
+		lastWrite := atomic.LoadInt64(&th.lastWrite)
+		if lastWrite > 0 && th.now().Sub(time.Unix(0, lastWrite)) < th.primaryAfterWrite {
+			return th.primary
+		}
+	}
+	return th.collection
+}
+
+// record the time of a write
+func (th *Collection[MODEL, ID]) markWrite() {
+	if th.primaryAfterWrite > 0 {
+		atomic.StoreInt64(&th.lastWrite, th.now().UnixNano())
+	}
+}
+
+// withClusterTime make ctx run in a causally consistent session reading after the AfterClusterTime of option,
+// the caller must call end when the read is done
+func (th *Collection[MODEL, ID]) withClusterTime(ctx context.Context, option *FindOption) (context.Context, func(), error) {
+	end := func() {}
+	if option == nil || option.afterClusterTime == nil {
+		return ctx, end, nil
+	}
+
+	// advance the session of the caller
+	if session := mongo.SessionFromContext(ctx); session != nil {
+		return ctx, end, errors.WithStack(session.AdvanceOperationTime(option.afterClusterTime))
+	}
+
+	session, err := th.collection.Database().Client().StartSession(options.Session().SetCausalConsistency(true))
+	if err != nil {
+		return ctx, end, errors.WithStack(err)
+	}
+	end = func() {
+		session.EndSession(context.Background())
+	}
+	if err := session.AdvanceOperationTime(option.afterClusterTime); err != nil {
+		end()
+		return ctx, func() {}, errors.WithStack(err)
+	}
+	return mongo.NewSessionContext(ctx, session), end, nil
+}
+
+// LastOperationTime the operationTime of the latest write to the collection through the client,
+// pass it to Option().AfterClusterTime of a later read, also in another service, to read the write.
+// It is zero until a write is acknowledged by a replica set or a sharded cluster
+func (th *Collection[MODEL, ID]) LastOperationTime() primitive.Timestamp {
+	if th.client == nil {
+		return primitive.Timestamp{}
+	}
+	return th.client.operationTimes.load(th.collection.Database().Name() + "." + th.collection.Name())
+}
+
+func (th *Collection[MODEL, ID]) FindOneById(ctx context.Context, id ID, opts ...*FindOption) (MODEL, error) {
 	return th.FindOneByFilter(ctx, bson.M{th.schema.IdField.DBName: id}, opts...)
 }
 
+// FindByID find the document by the id field, id is a primitive.ObjectID, a SObjectId or the hex of an ObjectID
+// when the model is keyed by ObjectIDs, otherwise it is compared as is. found is false when no document matches
+func (th *Collection[MODEL, ID]) FindByID(ctx context.Context, id any, opts ...*FindOption) (model MODEL, found bool, err error) {
+	id, err = th.objectIdOf(id)
+	if err != nil {
+		return model, false, err
+	}
+	model, err = th.FindOneByFilter(ctx, bson.M{th.schema.IdField.DBName: id}, opts...)
+	if err != nil {
+		return model, false, err
+	}
+	return model, !reflect.ValueOf(&model).Elem().IsZero(), nil
+}
+
+// objectIdOf parse a string id into a primitive.ObjectID when the id field stores ObjectIDs
+func (th *Collection[MODEL, ID]) objectIdOf(id any) (any, error) {
+	var s string
+	switch v := id.(type) {
+	case string:
+		s = v
+	case SObjectId:
+		s = string(v)
+	case *primitive.ObjectID:
+		if v == nil {
+			return nil, errors.WithStack(errortype.ErrInvalidObjectId)
+		}
+		return *v, nil
+	default:
+		return id, nil
+	}
+
+	idType := th.schema.IdField.FieldType
+	if idType != reflect.TypeOf(primitive.ObjectID{}) && idType != reflect.TypeOf(&primitive.ObjectID{}) && idType != reflect.TypeOf(SObjectId("")) {
+		return id, nil
+	}
+	oid, err := primitive.ObjectIDFromHex(s)
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("%w: %q", errortype.ErrInvalidObjectId, s))
+	}
+	return oid, nil
+}
+
 func (th *Collection[MODEL, ID]) IdExists(ctx context.Context, id ID) (bool, error) {
 	c, err := th.Count(ctx, bson.M{th.schema.IdField.DBName: id})
 	return c > 0, err
@@ -54,7 +302,9 @@ func (th *Collection[MODEL, ID]) IdsExistsNumber(ctx context.Context, ids []ID)
 }
 
 // FindOneByFilter find one by filter
-func (th *Collection[MODEL, ID]) FindOneByFilter(ctx context.Context, filter any, opts ...*options.FindOneOptions) (MODEL, error) {
+func (th *Collection[MODEL, ID]) FindOneByFilter(ctx context.Context, filter any, opts ...*FindOption) (MODEL, error) {
+	ctx, cancel := th.withTimeout(ctx)
+	defer cancel()
 
 	var out MODEL
 
@@ -63,9 +313,32 @@ func (th *Collection[MODEL, ID]) FindOneByFilter(ctx context.Context, filter any
 		return out, err
 	}
 
+	option := Merge(opts)
+	convertedFilter = th.liveFilter(convertedFilter, includeDeleted(option))
+
+	var findOneOpts []*options.FindOneOptions
+	if option != nil {
+		findOneOpts, err = option.makeFindOneOptions(th.schema)
+		if err != nil {
+			return out, err
+		}
+	}
+
+	ctx, end, err := th.withClusterTime(ctx, option)
+	if err != nil {
+		return out, err
+	}
+	defer end()
+
 	// 查找
-	one := th.collection.FindOne(ctx, convertedFilter, opts...)
-	err = one.Err()
+	one, err := retry(ctx, th.retryPolicy(), false, func() (*mongo.SingleResult, error) {
+		opts := findOneOpts
+		if maxTime, ok := deadlineMaxTime(ctx); ok {
+			opts = append([]*options.FindOneOptions{options.FindOne().SetMaxTime(maxTime)}, findOneOpts...)
+		}
+		one := th.reader().FindOne(ctx, convertedFilter, opts...)
+		return one, one.Err()
+	})
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return out, nil
@@ -74,11 +347,20 @@ func (th *Collection[MODEL, ID]) FindOneByFilter(ctx context.Context, filter any
 	}
 
 	// 解析
-	err = one.Decode(&out)
+	return th.decodeOne(one)
+}
+
+// decodeOne decode the document by the registry, models implementing bson.Unmarshaler decode themselves
+func (th *Collection[MODEL, ID]) decodeOne(one *mongo.SingleResult) (MODEL, error) {
+	var out MODEL
+	raw, err := one.DecodeBytes()
+	if err != nil {
+		return out, err
+	}
+	err = th.decodeRaw(raw, th.schema, &out)
 	if err != nil {
 		return out, err
 	}
-
 	return out, nil
 }
 
@@ -90,164 +372,540 @@ type Page interface {
 	GetCountTotal() bool
 }
 
-func (th *Collection[MODEL, ID]) FindPage(ctx context.Context, page Page, filter any, opts ...*options.FindOptions) ([]MODEL, int64, error) {
-	opts = append(opts, options.Find().SetSkip(page.GetLength()).SetSkip(page.GetOffset()))
+func (th *Collection[MODEL, ID]) FindPage(ctx context.Context, page Page, filter any, opts ...*FindOption) ([]MODEL, int64, error) {
+	opts = append(opts, Option().Offset(int(page.GetOffset())).Limit(int(page.GetLength())))
 	return th.FindWithTotal(ctx, filter, page.GetCountTotal(), opts...)
 }
 
 // FindWithTotal get page
-func (th *Collection[MODEL, ID]) FindWithTotal(ctx context.Context, filter any, countTotal bool, opts ...*options.FindOptions) ([]MODEL, int64, error) {
+func (th *Collection[MODEL, ID]) FindWithTotal(ctx context.Context, filter any, countTotal bool, opts ...*FindOption) ([]MODEL, int64, error) {
+	ctx, cancel := th.withTimeout(ctx)
+	defer cancel()
 
 	convertedFilter, _, err := th.convertFilter(filter)
 	if err != nil {
 		return nil, 0, err
 	}
 
+	// count in the same session as the find
+	option := Merge(opts)
+	ctx, end, err := th.withClusterTime(ctx, option)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer end()
+
 	var total int64
 	if countTotal {
-		count, err := th.count(ctx, convertedFilter)
+		count, err := th.count(ctx, th.liveFilter(convertedFilter, includeDeleted(option)))
 		if err != nil {
 			return nil, 0, err
 		}
 		total = count
 	}
 
-	// 查询
-	cursor, err := th.collection.Find(ctx, convertedFilter, opts...)
-
-	if err != nil {
-		return nil, 0, err
-	}
-
-	defer func() {
-		_ = cursor.Close(ctx)
-	}()
+	// 查询, the documents are kept on error by KeepPartialOnCancel
 	var out []MODEL
-	err = cursor.All(ctx, &out)
+	err = th.findInto(ctx, convertedFilter, func(ctx context.Context, cursor *mongo.Cursor) error {
+		out, err = th.decodeAll(ctx, cursor, keepPartial(option))
+		return err
+	}, option)
 	if err != nil {
-		return nil, 0, err
+		return out, total, err
 	}
 
 	return out, total, nil
 }
 
 // Find filter type is any,you can use bson.M,bson.D...
-func (th *Collection[MODEL, ID]) Find(ctx context.Context, filter any, opts ...*options.FindOptions) ([]MODEL, error) {
+func (th *Collection[MODEL, ID]) Find(ctx context.Context, filter any, opts ...*FindOption) ([]MODEL, error) {
+	var start time.Time
+	option := Merge(opts)
+	stats := queryStats(option)
+	if stats != nil {
+		start = time.Now()
+	}
 
-	convertedFilter, _, err := th.convertFilter(filter)
+	// 查询, the documents are kept on error by KeepPartialOnCancel
+	var out []MODEL
+	err := th.findInto(ctx, filter, func(ctx context.Context, cursor *mongo.Cursor) (err error) {
+		out, err = th.decodeAll(ctx, cursor, keepPartial(option))
+		return err
+	}, option)
+
+	if stats != nil {
+		stats.Duration = time.Since(start)
+		stats.Returned = len(out)
+	}
 	if err != nil {
-		return nil, err
+		return out, err
 	}
+	return out, nil
+}
 
-	// 查询
-	cursor, err := th.collection.Find(ctx, convertedFilter, opts...)
-
+// FindProjected find documents projected to the fields of DTO and decode them into DTO,
+// fields of DTO are matched with the model by db name, so the go names can differ
+func FindProjected[DTO any, MODEL any, ID any](ctx context.Context, col *Collection[MODEL, ID], filter any, opts ...*FindOption) ([]DTO, error) {
+	var dto DTO
+	projection, err := col.projectionOf(dto)
 	if err != nil {
 		return nil, err
 	}
 
-	defer func() {
-		_ = cursor.Close(ctx)
-	}()
-	var out []MODEL
-	err = cursor.All(ctx, &out)
+	schema, err := entity.GetOrParseDocument(dto)
 	if err != nil {
 		return nil, err
 	}
 
-	return out, nil
+	option := Merge(append(append([]*FindOption{}, opts...), Option().FindOptions(options.Find().SetProjection(projection))))
+	var results []DTO
+	err = col.findInto(ctx, filter, func(ctx context.Context, cursor *mongo.Cursor) error {
+		results, err = decodeCursor[DTO](ctx, col, cursor, schema, keepPartial(option))
+		return err
+	}, option)
+	if err != nil {
+		return results, err
+	}
+	return results, nil
 }
 
-func (th *Collection[MODEL, ID]) mustConvertFilter(filter any) (any, error) {
-	query, count, err := th.convertFilter(filter)
-
+// projectionOf select the db names of the fields of dto, every one must be a field of the model
+func (th *Collection[MODEL, ID]) projectionOf(dto any) (bson.D, error) {
+	schema, err := entity.GetOrParseDocument(dto)
 	if err != nil {
 		return nil, err
 	}
 
-	if count == 0 {
-		return nil, errortype.ErrFilterNotContainAnyCondition
+	projection := bson.D{}
+	if schema.IdField == nil {
+		projection = append(projection, bson.E{Key: th.schema.IdDBName(), Value: 0})
+	}
+	for _, field := range schema.Fields {
+		if _, ok := th.schema.FieldsByDBName[field.DBName]; !ok {
+			return nil, errors.WithStack(fmt.Errorf("field %s of %s can not be found in %s by db name %s", field.Name, schema.Name, th.schema.Name, field.DBName))
+		}
+		projection = append(projection, bson.E{Key: field.DBName, Value: 1})
 	}
+	return projection, nil
+}
 
-	return query, nil
+// findInto find by filter and decode the documents by decode, with the ctx bounded by the timeout of the collection
+func (th *Collection[MODEL, ID]) findInto(ctx context.Context, filter any, decode func(ctx context.Context, cursor *mongo.Cursor) error, opts ...*FindOption) error {
+	cursor, ctx, end, err := th.openCursor(ctx, filter, false, opts...)
+	if err != nil {
+		return err
+	}
+	defer end()
+
+	defer func() {
+		// close the cursor on the server even when ctx is done
+		closeCtx := ctx
+		if ctx.Err() != nil {
+			closeCtx = context.Background()
+		}
+		_ = cursor.Close(closeCtx)
+	}()
+
+	return decode(ctx, cursor)
 }
 
-func (th *Collection[MODEL, ID]) convertFilter(filter any) (any, int, error) {
+// openCursor run the find of filter and opts, the returned ctx is bounded by the timeout of the collection,
+// end cancels it and ends the session of the cursor after closing it.
+// A stream is not capped by MaxUnboundedLimit, and the server stops it only by the deadline of the ctx of the caller
+func (th *Collection[MODEL, ID]) openCursor(ctx context.Context, filter any, stream bool, opts ...*FindOption) (*mongo.Cursor, context.Context, func(), error) {
+	deadlineCtx := ctx
+	ctx, cancel := th.withTimeout(ctx)
+	if !stream {
+		deadlineCtx = ctx
+	}
 
-	switch v := filter.(type) {
-	// 原生M,直接返回
-	case bson.M:
-		return v, len(v), nil
-		// 原生D,直接返回
-	case bson.D:
-		return v, len(v), nil
+	option := Merge(opts)
+	convertedFilter, findOpts, err := th.findQuery(filter, option, !stream)
+	if err != nil {
+		cancel()
+		return nil, ctx, nil, err
 	}
 
-	kind := reflect.Indirect(reflect.ValueOf(filter)).Kind()
+	ctx, endSession, err := th.withClusterTime(ctx, option)
+	if err != nil {
+		cancel()
+		return nil, ctx, nil, err
+	}
+	end := func() {
+		endSession()
+		cancel()
+	}
 
-	// regard as id if kind is not struct
-	if kind != reflect.Struct {
-		if kind == reflect.Slice || kind == reflect.Array {
-			return bson.M{th.schema.IdDBName(): bson.M{"$in": utils.TryMapToObjectId(filter)}}, 0, nil
-		} else {
-			return bson.M{th.schema.IdDBName(): utils.TryMapToObjectId(filter)}, 0, nil
+	cursor, err := retry(ctx, th.retryPolicy(), false, func() (*mongo.Cursor, error) {
+		if maxTime, ok := deadlineMaxTime(deadlineCtx); ok {
+			return th.reader().Find(ctx, convertedFilter, append([]*options.FindOptions{options.Find().SetMaxTime(maxTime)}, findOpts...)...)
 		}
+		return th.reader().Find(ctx, convertedFilter, findOpts...)
+	})
+	if err != nil {
+		end()
+		return nil, ctx, nil, err
 	}
+	return cursor, ctx, end, nil
+}
 
-	filterSchema, err := filterPkg.GetOrParse(filter)
+// findQuery the converted filter and the options of the find of option, with the soft deleted documents excluded
+// and the limit capped by MaxUnboundedLimit when capped, shared by the find and its explain
+func (th *Collection[MODEL, ID]) findQuery(filter any, option *FindOption, capped bool) (any, []*options.FindOptions, error) {
+	convertedFilter, _, err := th.convertFilter(filter)
 	if err != nil {
-		return nil, 0, err
+		return nil, nil, err
 	}
+	convertedFilter = th.liveFilter(convertedFilter, includeDeleted(option))
 
-	query := bson.M{}
-	value := reflect.ValueOf(filter)
-	err = th.fillToQuery(value, filterSchema, query)
+	var findOpts []*options.FindOptions
+	if option != nil {
+		findOpts, err = option.makeFindOption(th.schema)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if capped {
+		findOpts = th.capLimit(option, findOpts)
+	}
+	return convertedFilter, findOpts, nil
+}
+
+// decode all documents of the cursor into models
+func (th *Collection[MODEL, ID]) decodeAll(ctx context.Context, cursor *mongo.Cursor, keepPartial bool) ([]MODEL, error) {
+	return decodeCursor[MODEL](ctx, th, cursor, th.schema, keepPartial)
+}
+
+// byIdOptions opts of a lookup by ids or keys, which is bounded by them, so MaxUnboundedLimit does not cap it
+func byIdOptions(opts []*FindOption) []*FindOption {
+	return append([]*FindOption{Option().NoLimit()}, opts...)
+}
+
+// IdChunkSize set the ids of a query of FindByIDs and FindByIDsOrdered, call it when setting up the collection
+func (th *Collection[MODEL, ID]) IdChunkSize(size int) *Collection[MODEL, ID] {
+	th.idChunkSize = size
+	return th
+}
+
+// FindInto find the documents into dest, a pointer to a slice of the models or of the structs they point to,
+// e.g. *[]*Test or *[]Test for the model *Test
+func (th *Collection[MODEL, ID]) FindInto(ctx context.Context, filter any, dest any, opts ...*FindOption) error {
+	slice := reflect.ValueOf(dest)
+	modelType := reflect.TypeOf((*MODEL)(nil)).Elem()
+	if slice.Kind() != reflect.Ptr || slice.IsNil() || slice.Elem().Kind() != reflect.Slice {
+		return errors.WithStack(fmt.Errorf("%w: dest must be a pointer to a slice of %s, got %T", errortype.ErrModelTypeNotMatchInCollection, modelType, dest))
+	}
+	elemType := slice.Elem().Type().Elem()
+	byValue := modelType.Kind() == reflect.Ptr && elemType == modelType.Elem()
+	if elemType != modelType && !byValue {
+		return errors.WithStack(fmt.Errorf("%w: dest must be a pointer to a slice of %s, got %T", errortype.ErrModelTypeNotMatchInCollection, modelType, dest))
+	}
+
+	models, err := th.Find(ctx, filter, opts...)
+
+	out := reflect.MakeSlice(slice.Elem().Type(), 0, len(models))
+	for _, model := range models {
+		value := reflect.ValueOf(model)
+		if byValue {
+			value = value.Elem()
+		}
+		out = reflect.Append(out, value)
+	}
+	slice.Elem().Set(out)
+	return err
+}
+
+// FindByIDs find the documents of ids, the ids are queried by chunks of IdChunkSize one after another,
+// so a large number of ids does not exceed the size limit of the $in. opts apply to each chunk,
+// e.g. a projection, the documents are in the order of the chunks and duplicated ids are queried once
+func (th *Collection[MODEL, ID]) FindByIDs(ctx context.Context, ids []ID, opts ...*FindOption) ([]MODEL, error) {
+	chunks, err := th.idChunks(ids)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 
-	return query, len(query), err
+	opts = byIdOptions(opts)
+	var docs []MODEL
+	for _, chunk := range chunks {
+		found, err := th.Find(ctx, chunk, opts...)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, found...)
+	}
+	return docs, nil
 }
 
-// begin iter all fields in filter
-func (th *Collection[MODEL, ID]) fillToQuery(value reflect.Value, filterSchema *filterPkg.Filter, query bson.M) error {
-	for _, filterField := range filterSchema.Fields {
-		fieldValue := filterField.ReflectValueOf(value)
-		// continue if field value is zero
-		if fieldValue.IsZero() {
+// idChunks split the distinct ids into chunks of IdChunkSize
+func (th *Collection[MODEL, ID]) idChunks(ids []ID) ([][]ID, error) {
+	size := th.idChunkSize
+	if size <= 0 {
+		size = defaultIdChunkSize
+	}
+
+	seen := make(map[string]bool, len(ids))
+	var chunks [][]ID
+	var chunk []ID
+	for _, id := range ids {
+		key, err := valueKey(id)
+		if err != nil {
+			return nil, err
+		}
+		if seen[key] {
 			continue
 		}
+		seen[key] = true
 
-		entityField, err := th.mustSchemaField(filterField.RelativeFieldName)
+		chunk = append(chunk, id)
+		if len(chunk) == size {
+			chunks = append(chunks, chunk)
+			chunk = nil
+		}
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
+
+// FindByIDsOrdered find the documents of ids in the order of ids, ids which are not found are dropped.
+// The ids are queried by chunks the same way as FindByIDs
+func (th *Collection[MODEL, ID]) FindByIDsOrdered(ctx context.Context, ids []ID, opts ...*FindOption) ([]MODEL, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	docs, err := th.FindByIDs(ctx, ids, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return th.orderByIDs(docs, ids)
+}
+
+// orderByIDs reorder docs to the order of ids
+func (th *Collection[MODEL, ID]) orderByIDs(docs []MODEL, ids []ID) ([]MODEL, error) {
+	if th.schema.IdField == nil {
+		return nil, errors.WithStack(errortype.ErrIdFieldDoesNotExists)
+	}
+
+	byKey := make(map[string]MODEL, len(docs))
+	for _, doc := range docs {
+		id, _ := th.schema.IdField.ValueOf(reflect.ValueOf(doc))
+		key, err := valueKey(id)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		object := fieldValue.Interface()
-		// handle by the field itself
-		if o, ok := object.(FilterOperator); ok {
-			err := o.handle(entityField, filterField, query)
-			if err != nil {
-				return err
-			}
-		} else { // default handle
-			fieldType := filterField.FieldType
+		byKey[key] = doc
+	}
 
-			if fieldType.Kind() == reflect.Slice || fieldType.Kind() == reflect.Array {
-				query[entityField.DBName] = bson.M{"$in": object}
-			} else {
-				query[entityField.DBName] = object
-			}
+	ordered := make([]MODEL, 0, len(docs))
+	for _, id := range ids {
+		key, err := valueKey(id)
+		if err != nil {
+			return nil, err
+		}
+		if doc, ok := byKey[key]; ok {
+			ordered = append(ordered, doc)
 		}
 	}
+	return ordered, nil
+}
 
-	return nil
+// ChangedSince find the documents whose time field sinceField is after since, ascending by it, for polling changes
+// by a watermark: pass the time of the last document as since of the next poll. sinceField is the go name or db name
+func (th *Collection[MODEL, ID]) ChangedSince(ctx context.Context, sinceField string, since time.Time, opts ...*FindOption) ([]MODEL, error) {
+	field, err := th.schema.MustLookUpField(sinceField)
+	if err != nil {
+		return nil, err
+	}
+	if field.FieldType != timeType && field.FieldType != timePtrType {
+		return nil, errors.Errorf("field %s of %s is not a time.Time", field.Name, th.schema.Name)
+	}
+
+	// the watermark comes first so it stays the primary order
+	opts = append([]*FindOption{Option().AddOrder(field.DBName, true)}, opts...)
+	return th.Find(ctx, bson.M{field.DBName: bson.M{"$gt": since}}, opts...)
 }
 
-func (th *Collection[MODEL, ID]) BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+// FindIDs find only the ids of the documents matched by filter, ids are decoded into ID
+func (th *Collection[MODEL, ID]) FindIDs(ctx context.Context, filter any, opts ...*FindOption) ([]ID, error) {
+	opts = append(opts, Option().FindOptions(options.Find().SetProjection(bson.M{th.schema.IdDBName(): 1})))
 
-	// handle
-	var updateModels []any
-	for _, model := range models {
+	var ids []ID
+	err := th.findInto(ctx, filter, func(ctx context.Context, cursor *mongo.Cursor) (err error) {
+		ids, err = th.decodeIDs(ctx, cursor)
+		return err
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (th *Collection[MODEL, ID]) decodeIDs(ctx context.Context, cursor *mongo.Cursor) ([]ID, error) {
+	var ids []ID
+	for cursor.Next(ctx) {
+		var id ID
+		err := cursor.Current.Lookup(th.schema.IdDBName()).UnmarshalWithRegistry(DefaultRegistry, &id)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		ids = append(ids, id)
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (th *Collection[MODEL, ID]) mustConvertFilter(filter any) (any, error) {
+	query, count, err := th.convertFilter(filter)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if count == 0 {
+		return nil, errortype.ErrFilterNotContainAnyCondition
+	}
+
+	return query, nil
+}
+
+// liveFilter add the condition excluding the soft deleted documents to the converted query, the query is kept
+// when includeDeleted, the model has no soft delete field, or the query has its own condition on the field,
+// e.g. {deletedAt: {$ne: null}} finding the deleted documents.
+// Reads, counts, Distinct, the Match stages of Aggregation, the updates and FindOneAndDelete, which returns the document,
+// apply it. Deletes do not, purging the deleted documents is a delete too, nor do the pipelines given to Aggregate,
+// which are used as they are
+func (th *Collection[MODEL, ID]) liveFilter(query any, includeDeleted bool) any {
+	field := th.schema.SoftDeleteField
+	if field == nil || includeDeleted || hasKey(query, field.DBName) {
+		return query
+	}
+
+	live := bson.M{field.DBName: nil}
+	if isEmptyQuery(query) {
+		return live
+	}
+	return bson.M{"$and": bson.A{query, live}}
+}
+
+// hasKey whether the top level of the query has key
+func hasKey(query any, key string) bool {
+	switch v := query.(type) {
+	case bson.M:
+		_, ok := v[key]
+		return ok
+	case bson.D:
+		for _, e := range v {
+			if e.Key == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (th *Collection[MODEL, ID]) convertFilter(filter any) (any, int, error) {
+
+	switch v := filter.(type) {
+	// 没有条件
+	case nil:
+		return bson.M{}, 0, nil
+	// 原生M,直接返回
+	case bson.M:
+		return v, len(v), nil
+		// 原生D,直接返回
+	case bson.D:
+		return v, len(v), nil
+	case *Condition:
+		query, err := v.toQuery(th.schema)
+		return query, len(query), err
+	// an array itself, not a list of ids, the id is a condition
+	case primitive.ObjectID:
+		return bson.M{th.schema.IdDBName(): v}, 1, nil
+	}
+
+	kind := reflect.Indirect(reflect.ValueOf(filter)).Kind()
+
+	// regard as id if kind is not struct
+	if kind != reflect.Struct {
+		if kind == reflect.Slice || kind == reflect.Array {
+			return bson.M{th.schema.IdDBName(): bson.M{"$in": utils.TryMapToObjectId(filter)}}, 0, nil
+		} else {
+			return bson.M{th.schema.IdDBName(): utils.TryMapToObjectId(filter)}, 0, nil
+		}
+	}
+
+	filterSchema, err := filterPkg.GetOrParse(filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := bson.M{}
+	value := reflect.ValueOf(filter)
+	err = th.fillToQuery(value, filterSchema, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return query, len(query), err
+}
+
+// begin iter all fields in filter
+func (th *Collection[MODEL, ID]) fillToQuery(value reflect.Value, filterSchema *filterPkg.Filter, query bson.M) error {
+	for _, filterField := range filterSchema.Fields {
+		fieldValue := filterField.ReflectValueOf(value)
+		// continue if field value is zero, an empty slice is omitted too as $in [] matches nothing
+		if fieldValue.IsZero() || (fieldValue.Kind() == reflect.Slice && fieldValue.Len() == 0) {
+			continue
+		}
+
+		entityField, err := th.mustSchemaField(filterField.RelativeFieldName)
+		if err != nil {
+			return err
+		}
+		object := fieldValue.Interface()
+		// the sentinel of null, which can not be expressed by the zero value
+		if _, ok := object.(extype.Null); ok {
+			object = nil
+		}
+		// handle by the field itself
+		if o, ok := object.(FilterOperator); ok {
+			err := o.handle(entityField, filterField, query)
+			if err != nil {
+				return err
+			}
+		} else if filterField.StructTags.Operator != "" { // operator from tag
+			putOperator(query, entityField.DBName, "$"+filterField.StructTags.Operator, object)
+		} else { // default handle
+			fieldType := filterField.FieldType
+
+			// slice matches any of the values, unless exact is set to match the whole array
+			if (fieldType.Kind() == reflect.Slice || fieldType.Kind() == reflect.Array) && !filterField.StructTags.Exact {
+				putOperator(query, entityField.DBName, "$in", object)
+			} else {
+				putOperator(query, entityField.DBName, "", object)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (th *Collection[MODEL, ID]) BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+	ctx, cancel := th.withTimeout(ctx)
+	defer cancel()
+
+	// handle
+	var updateModels []any
+	// the models of the insertions, the written documents may be the encrypted ones
+	insertedModels := map[int]any{}
+	insertedIDs := map[int]any{}
+	// the insertions are written as copies, the InsertOneModel of the caller keeps its document
+	writes := append([]mongo.WriteModel{}, models...)
+	for i, model := range models {
 		switch v := model.(type) {
 		case *mongo.UpdateOneModel:
 			updateModels = append(updateModels, v.Update)
@@ -307,20 +965,34 @@ func (th *Collection[MODEL, ID]) BulkWrite(ctx context.Context, models []mongo.W
 			if err != nil {
 				return nil, err
 			}
+
+			document, err := th.encryptDocument(v.Document)
+			if err != nil {
+				return nil, err
+			}
+			written, id, err := withInsertedID(document)
+			if err != nil {
+				return nil, err
+			}
+			insertedModels[i] = v.Document
+			insertedIDs[i] = id
+			writes[i] = mongo.NewInsertOneModel().SetDocument(written)
 		}
 	}
 
 	// write models to mongodb
-	result, err := th.collection.BulkWrite(ctx, models, opts...)
+	result, err := retry(ctx, th.retryPolicy(), true, func() (*mongo.BulkWriteResult, error) {
+		return th.collection.BulkWrite(ctx, writes, opts...)
+	})
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	th.markWrite()
 
 	// call hook for insert one and update one
-	for i, model := range models {
-		if insertion, ok := model.(*mongo.InsertOneModel); ok {
-			th.tryCallAfterSaveHook(insertion.Document, result.UpsertedIDs[int64(i)])
-		}
+	for i, model := range insertedModels {
+		th.fillInsertedID(model, insertedIDs[i])
+		th.tryCallAfterSaveHook(model, insertedIDs[i])
 	}
 	for _, model := range updateModels {
 		th.tryCallAfterUpdateHook(model)
@@ -328,6 +1000,89 @@ func (th *Collection[MODEL, ID]) BulkWrite(ctx context.Context, models []mongo.W
 	return result, nil
 }
 
+// UpsertMany upsert every doc by the natural key made of keyFields, the rest non zero fields are $set.
+// keyFields can be model field names or db names, the writes are executed as an unordered bulk write
+func (th *Collection[MODEL, ID]) UpsertMany(ctx context.Context, docs []MODEL, keyFields ...string) (*mongo.BulkWriteResult, error) {
+	ctx, cancel := th.withTimeout(ctx)
+	defer cancel()
+
+	models, err := th.makeUpsertModels(docs, keyFields)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := retry(ctx, th.retryPolicy(), true, func() (*mongo.BulkWriteResult, error) {
+		return th.collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	th.markWrite()
+
+	for _, doc := range docs {
+		th.tryCallAfterUpdateHook(doc)
+	}
+	return result, nil
+}
+
+func (th *Collection[MODEL, ID]) makeUpsertModels(docs []MODEL, keyFields []string) ([]mongo.WriteModel, error) {
+
+	if len(keyFields) == 0 {
+		return nil, errors.WithStack(errortype.ErrFilterNotContainAnyCondition)
+	}
+
+	keys := make([]*entity.EntityField, 0, len(keyFields))
+	for _, keyField := range keyFields {
+		field, err := th.mustSchemaField(keyField)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, field)
+	}
+
+	models := make([]mongo.WriteModel, 0, len(docs))
+	for _, doc := range docs {
+		err := th.tryCallBeforeUpdateHook(doc)
+		if err != nil {
+			return nil, err
+		}
+
+		value := reflect.ValueOf(doc)
+		filter := bson.M{}
+		for _, key := range keys {
+			object, _ := key.ValueOf(value)
+			filter[key.DBName] = object
+		}
+
+		// the id and the create time are written only when inserting, the id of a matched document can not change
+		update, err := th.mapToUpsert(doc)
+		if err != nil {
+			return nil, err
+		}
+
+		// key fields are already written by the filter when inserting
+		set, _ := update["$set"].(bson.M)
+		onInsert, _ := update["$setOnInsert"].(bson.M)
+		for _, key := range keys {
+			delete(set, key.DBName)
+			delete(onInsert, key.DBName)
+		}
+		if len(set) == 0 {
+			delete(update, "$set")
+		}
+		if len(onInsert) == 0 {
+			delete(update, "$setOnInsert")
+		}
+		if len(update) == 0 {
+			update = bson.M{"$setOnInsert": filter}
+		}
+
+		models = append(models, mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(true))
+	}
+
+	return models, nil
+}
+
 func (th *Collection[MODEL, ID]) NewUpdateOneModel(filter any, model MODEL) *mongo.UpdateOneModel {
 	return mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(model)
 }
@@ -336,149 +1091,651 @@ func (th *Collection[MODEL, ID]) NewUpdateManyModel(filter any, model MODEL) *mo
 	return mongo.NewUpdateManyModel().SetFilter(filter).SetUpdate(model)
 }
 
-func (th *Collection[MODEL, ID]) NewInsertOneModel(model MODEL) *mongo.InsertOneModel {
-	return mongo.NewInsertOneModel().SetDocument(model)
-}
+func (th *Collection[MODEL, ID]) NewInsertOneModel(model MODEL) *mongo.InsertOneModel {
+	return mongo.NewInsertOneModel().SetDocument(model)
+}
+
+func (th *Collection[MODEL, ID]) NewDeleteOneModel(filter any) *mongo.DeleteOneModel {
+	return mongo.NewDeleteOneModel().SetFilter(filter)
+}
+
+func (th *Collection[MODEL, ID]) NewDeleteManyModel(filter any) *mongo.DeleteManyModel {
+	return mongo.NewDeleteManyModel().SetFilter(filter)
+}
+
+// Aggregate run pipeline and decode every result into results, a pointer to a slice.
+// A slice of the model is decoded as Find does, e.g. with its encrypted and unix time fields, any other by the registry
+func (th *Collection[MODEL, ID]) Aggregate(ctx context.Context, pipeline any, results any, opts ...*options.AggregateOptions) error {
+	ctx, cancel := th.withTimeout(ctx)
+	defer cancel()
+	cursor, err := retry(ctx, th.retryPolicy(), false, func() (*mongo.Cursor, error) {
+		return th.reader().Aggregate(ctx, pipeline, opts...)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = cursor.Close(ctx)
+	}()
+
+	return th.decodeAggregated(ctx, cursor, results)
+}
+
+// decodeAggregated decode every document of cursor into results, the models through decodeAll,
+// any other by aggregateRegistry
+func (th *Collection[MODEL, ID]) decodeAggregated(ctx context.Context, cursor *mongo.Cursor, results any) error {
+	models, ok := results.(*[]MODEL)
+	if !ok {
+		return allWithRegistry(ctx, cursor, aggregateRegistry, results)
+	}
+
+	decoded, err := th.decodeAll(ctx, cursor, false)
+	if err != nil {
+		return err
+	}
+	*models = decoded
+	return nil
+}
+
+// Sample return n random documents matched by filter, using a $match + $sample pipeline
+func (th *Collection[MODEL, ID]) Sample(ctx context.Context, n int, filter any, opts ...*options.AggregateOptions) ([]MODEL, error) {
+	pipeline, err := th.samplePipeline(n, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []MODEL
+	err = th.Aggregate(ctx, pipeline, &results, opts...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return results, nil
+}
+
+func (th *Collection[MODEL, ID]) samplePipeline(n int, filter any) (mongo.Pipeline, error) {
+	if n <= 0 {
+		return nil, errors.Errorf("sample size must be positive, got %d", n)
+	}
+	return th.Aggregation().Match(filter).Sample(n).Build()
+}
+
+// ExactCount make Count use CountDocuments even without a filter, call it when setting up the collection
+func (th *Collection[MODEL, ID]) ExactCount() *Collection[MODEL, ID] {
+	th.exactCount = true
+	return th
+}
+
+// Count count the documents matched by filter.
+// Without a filter it uses EstimatedDocumentCount, which reads the metadata of the collection and is much faster on
+// large collections, but may be off after an unclean shutdown and counts orphaned documents of sharded clusters,
+// call ExactCount when the number must be accurate. Soft deleted documents are not counted unless filter has a condition on the field
+// or Option().IncludeDeleted. Offset and Limit of opts count within a window, e.g. Option().Limit(100) stops counting at 100
+func (th *Collection[MODEL, ID]) Count(ctx context.Context, filter any, opts ...*FindOption) (int64, error) {
+	query, _, err := th.convertFilter(filter)
+	if err != nil {
+		return 0, err
+	}
+	option := Merge(opts)
+	query = th.liveFilter(query, includeDeleted(option))
+	countOpts := countOptions(option)
+	if th.useEstimatedCount(ctx, query, countOpts) {
+		return th.EstimatedCount(ctx)
+	}
+	return th.count(ctx, query, countOpts...)
+}
+
+// useEstimatedCount whether the count without conditions can be estimated,
+// skip and limit need the documents, and transactions do not support the estimate
+func (th *Collection[MODEL, ID]) useEstimatedCount(ctx context.Context, query any, opts []*options.CountOptions) bool {
+	if th.exactCount || !isEmptyQuery(query) {
+		return false
+	}
+	merged := options.MergeCountOptions(opts...)
+	if merged.Skip != nil || merged.Limit != nil {
+		return false
+	}
+	return mongo.SessionFromContext(ctx) == nil
+}
+
+// the query has no conditions, the count returned by convertFilter is 0 for ids, so the query itself is checked
+func isEmptyQuery(query any) bool {
+	switch v := query.(type) {
+	case nil:
+		return true
+	case bson.M:
+		return len(v) == 0
+	case bson.D:
+		return len(v) == 0
+	}
+	return false
+}
+
+// EstimatedCount the number of documents of the collection from its metadata, fast on any size of collection,
+// soft deleted documents are counted too. Use Count for a filter, or Option().Offset and Limit to count within a window
+func (th *Collection[MODEL, ID]) EstimatedCount(ctx context.Context) (int64, error) {
+	ctx, cancel := th.withTimeout(ctx)
+	defer cancel()
+
+	count, err := retry(ctx, th.retryPolicy(), false, func() (int64, error) {
+		return th.reader().EstimatedDocumentCount(ctx)
+	})
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return count, nil
+}
+
+func (th *Collection[MODEL, ID]) Exists(ctx context.Context, filter any, opts ...*FindOption) (bool, error) {
+	query, _, err := th.convertFilter(filter)
+	if err != nil {
+		return false, err
+	}
+	option := Merge(opts)
+	count, err := th.count(ctx, th.liveFilter(query, includeDeleted(option)), countOptions(option)...)
+	return count > 0, err
+}
+
+// CheckUnique whether no other document has the same values of fields as doc, fields can be model field names or db names,
+// doc itself is excluded by its id when it is set, e.g. checking before an update.
+// It's only a friendly pre-check, another insert can happen between the check and the write,
+// the unique index is the real guard
+func (th *Collection[MODEL, ID]) CheckUnique(ctx context.Context, doc MODEL, fields ...string) (bool, error) {
+	filter, err := th.uniqueFilter(doc, fields)
+	if err != nil {
+		return false, err
+	}
+	exists, err := th.Exists(ctx, filter)
+	return !exists, err
+}
+
+func (th *Collection[MODEL, ID]) uniqueFilter(doc MODEL, fields []string) (bson.M, error) {
+	if len(fields) == 0 {
+		return nil, errors.New("fields to check unique are empty")
+	}
+
+	value := reflect.ValueOf(doc)
+	filter := bson.M{}
+	for _, name := range fields {
+		field, err := th.mustSchemaField(name)
+		if err != nil {
+			return nil, err
+		}
+		filter[field.DBName], _ = field.ValueOf(value)
+	}
+
+	if id, zero := th.schema.IdField.ValueOf(value); !zero {
+		putOperator(filter, th.schema.IdDBName(), "$ne", id)
+	}
+	return filter, nil
+}
+
+// Distinct distinct values of field in documents matched by filter, field can be model field name or db name,
+// soft deleted documents are excluded unless filter has a condition on the field
+func (th *Collection[MODEL, ID]) Distinct(ctx context.Context, field string, filter any, opts ...*options.DistinctOptions) ([]any, error) {
+	ctx, cancel := th.withTimeout(ctx)
+	defer cancel()
+	schemaField, err := th.mustSchemaField(field)
+	if err != nil {
+		return nil, err
+	}
+
+	query, _, err := th.convertFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	query = th.liveFilter(query, false)
+	values, err := retry(ctx, th.retryPolicy(), false, func() ([]any, error) {
+		return th.reader().Distinct(ctx, schemaField.DBName, query, opts...)
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return values, nil
+}
+
+// DistinctTyped same as Collection.Distinct, but the values are decoded into []T
+func DistinctTyped[T any, MODEL any, ID any](ctx context.Context, col *Collection[MODEL, ID], field string, filter any, opts ...*options.DistinctOptions) ([]T, error) {
+	values, err := col.Distinct(ctx, field, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return decodeValues[T](values)
+}
+
+// decode values into []T by a bson round trip, so the registry coerces the types
+func decodeValues[T any](values []any) ([]T, error) {
+	type wrapper struct {
+		Values []T `bson:"values"`
+	}
+
+	data, err := bson.MarshalWithRegistry(DefaultRegistry, bson.M{"values": values})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var out wrapper
+	err = bson.UnmarshalWithRegistry(DefaultRegistry, data, &out)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return out.Values, nil
+}
+
+func (th *Collection[MODEL, ID]) count(ctx context.Context, filter any, opts ...*options.CountOptions) (int64, error) {
+	ctx, cancel := th.withTimeout(ctx)
+	defer cancel()
+	//type Count struct {
+	//	Count int64 `bson:"count"`
+	//}
+
+	//filter = bson.A{
+	//	bson.M{
+	//		"$match": filter,
+	//	},
+	//	bson.M{
+	//		"$count": "count",
+	//	},
+	//}
+	count, err := retry(ctx, th.retryPolicy(), false, func() (int64, error) {
+		if maxTime, ok := deadlineMaxTime(ctx); ok {
+			return th.reader().CountDocuments(ctx, filter, append([]*options.CountOptions{options.Count().SetMaxTime(maxTime)}, opts...)...)
+		}
+		return th.reader().CountDocuments(ctx, filter, opts...)
+	})
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return count, nil
+}
+
+// 获取属性对应的schemaField
+func (th *Collection[MODEL, ID]) mustSchemaField(fieldName string) (*entity.EntityField, error) {
+	return th.schema.MustLookUpField(fieldName)
+}
+
+// InsertOne inert one
+func (th *Collection[MODEL, ID]) InsertOne(ctx context.Context, model MODEL, opts ...*options.InsertOneOptions) error {
+	ctx, cancel := th.withTimeout(ctx)
+	defer cancel()
+
+	if err := th.tryCallBeforeSaveHook(model); err != nil {
+		return err
+	}
+
+	document, err := th.encryptDocument(model)
+	if err != nil {
+		return err
+	}
+
+	result, err := retry(ctx, th.retryPolicy(), true, func() (*mongo.InsertOneResult, error) {
+		return th.collection.InsertOne(ctx, document, opts...)
+	})
+	if err != nil {
+		return err
+	}
+	th.markWrite()
+
+	th.fillInsertedID(model, result.InsertedID)
+	th.tryCallAfterSaveHook(model, result.InsertedID)
+
+	return nil
+}
+
+// InsertOrGet insert model, when it conflicts with an existing document on a unique key,
+// the document matched by filter is returned instead with created false
+func (th *Collection[MODEL, ID]) InsertOrGet(ctx context.Context, model MODEL, filter any) (MODEL, bool, error) {
+	err := th.InsertOne(ctx, model)
+	if err == nil {
+		return model, true, nil
+	}
+
+	var existing MODEL
+	if !mongo.IsDuplicateKeyError(err) {
+		return existing, false, err
+	}
+
+	existing, findErr := th.FindOneByFilter(ctx, filter)
+	if findErr != nil {
+		return existing, false, findErr
+	}
+	// the filter does not match the document which conflicts
+	if reflect.ValueOf(&existing).Elem().IsZero() {
+		return existing, false, err
+	}
+	return existing, false, nil
+}
+
+// InsertMany 创建一组内容, return the ids of the inserted documents,
+// when only some of them failed, the ids of the others are returned with *errortype.InsertManyError
+func (th *Collection[MODEL, ID]) InsertMany(ctx context.Context, models []MODEL, opts ...*options.InsertManyOptions) ([]ID, error) {
+	ctx, cancel := th.withTimeout(ctx)
+	defer cancel()
+
+	var ms = make([]any, 0, len(models))
+	for _, model := range models {
+		err := th.tryCallBeforeSaveHook(model)
+		if err != nil {
+			return nil, err
+		}
+		document, err := th.encryptDocument(model)
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, document)
+	}
+
+	result, err := retry(ctx, th.retryPolicy(), true, func() (*mongo.InsertManyResult, error) {
+		return th.collection.InsertMany(ctx, ms, opts...)
+	})
+	if err != nil {
+		return th.partialInsert(models, result, err, options.MergeInsertManyOptions(opts...))
+	}
+	th.markWrite()
+
+	for i, model := range models {
+		th.fillInsertedID(model, result.InsertedIDs[i])
+		th.tryCallAfterSaveHook(model, result.InsertedIDs[i])
+	}
+
+	return decodeValues[ID](result.InsertedIDs)
+}
+
+// partialInsert report the documents inserted before err, an ordered insert stops at the first failure
+func (th *Collection[MODEL, ID]) partialInsert(models []MODEL, result *mongo.InsertManyResult, err error, opts *options.InsertManyOptions) ([]ID, error) {
+	var exception mongo.BulkWriteException
+	if result == nil || !errors.As(err, &exception) || len(exception.WriteErrors) == 0 {
+		return nil, err
+	}
+
+	failed := map[int]error{}
+	first := len(models)
+	for _, writeError := range exception.WriteErrors {
+		failed[writeError.Index] = writeError
+		if writeError.Index < first {
+			first = writeError.Index
+		}
+	}
+	ordered := opts.Ordered == nil || *opts.Ordered
+
+	insertManyError := &errortype.InsertManyError{Err: err}
+	var inserted []any
+	for i, model := range models {
+		if failure, ok := failed[i]; ok {
+			insertManyError.Failures = append(insertManyError.Failures, errortype.InsertFailure{Index: i, Id: result.InsertedIDs[i], Err: failure})
+			continue
+		}
+		if ordered && i > first {
+			continue
+		}
+		inserted = append(inserted, result.InsertedIDs[i])
+		th.fillInsertedID(model, result.InsertedIDs[i])
+		th.tryCallAfterSaveHook(model, result.InsertedIDs[i])
+	}
+
+	if len(inserted) > 0 {
+		th.markWrite()
+	}
 
-func (th *Collection[MODEL, ID]) NewDeleteOneModel(filter any) *mongo.DeleteOneModel {
-	return mongo.NewDeleteOneModel().SetFilter(filter)
+	ids, decodeErr := decodeValues[ID](inserted)
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+	return ids, insertManyError
 }
 
-func (th *Collection[MODEL, ID]) NewDeleteManyModel(filter any) *mongo.DeleteManyModel {
-	return mongo.NewDeleteManyModel().SetFilter(filter)
+func (th *Collection[MODEL, ID]) UpdateOneById(ctx context.Context, id ID, model MODEL, opts ...*options.UpdateOptions) (bool, error) {
+	_, modified, err := th.UpdateOne(ctx, bson.M{th.schema.IdDBName(): id}, model, DriverUpdateOptions(opts...))
+	return modified > 0, err
 }
 
-func (th *Collection[MODEL, ID]) Aggregate(ctx context.Context, pipeline any, results any, opts ...*options.AggregateOptions) error {
-	cursor, err := th.collection.Aggregate(ctx, pipeline, opts...)
-
+// UpdateOneByIdIf update the document of id only when it matches precondition, e.g. set status to shipped
+// only if it is currently paid, errortype.ErrPreconditionFailed is returned when the document does not exist or does not match
+func (th *Collection[MODEL, ID]) UpdateOneByIdIf(ctx context.Context, id ID, precondition any, model MODEL, opts ...*options.UpdateOptions) error {
+	filter, err := th.preconditionFilter(id, precondition)
 	if err != nil {
 		return err
 	}
 
-	defer func() {
-		_ = cursor.Close(ctx)
-	}()
-
-	err = cursor.All(ctx, results)
-
-	return err
+	result, err := th.doUpdate(ctx, filter, model, false, th.mapToUpdate, opts)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.WithStack(errortype.ErrPreconditionFailed)
+	}
+	return nil
 }
 
-func (th *Collection[MODEL, ID]) Count(ctx context.Context, filter any, opts ...*options.CountOptions) (int64, error) {
-	query, _, err := th.convertFilter(filter)
+// preconditionFilter match the document of id and precondition
+func (th *Collection[MODEL, ID]) preconditionFilter(id ID, precondition any) (bson.M, error) {
+	idFilter := bson.M{th.schema.IdDBName(): id}
+	if precondition == nil {
+		return idFilter, nil
+	}
+	query, _, err := th.convertFilter(precondition)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return th.count(ctx, query, opts...)
+	return bson.M{"$and": bson.A{idFilter, query}}, nil
 }
 
-func (th *Collection[MODEL, ID]) Exists(ctx context.Context, filter any, opts ...*options.CountOptions) (bool, error) {
-	query, _, err := th.convertFilter(filter)
+// UpdateOne $set the non zero fields of model to one document matched by filter, return the matched and modified counts,
+// e.g. to tell a missing document from one already holding the values. Zero fields are $set too with SetZeroFields.
+// With Option().Upsert(true) a document made of the equality conditions of filter and the fields of model is inserted
+// when nothing matches, its id is written back to model when the id of model is zero. The create time and the id
+// of model are written only into an inserted document
+func (th *Collection[MODEL, ID]) UpdateOne(ctx context.Context, filter any, model MODEL, opts ...*UpdateOption) (matched int64, modified int64, err error) {
+	makeUpdate, updateOpts := th.updateOf(opts)
+	result, err := th.doUpdate(ctx, filter, model, false, makeUpdate, updateOpts)
 	if err != nil {
-		return false, err
+		return 0, 0, err
 	}
-	count, err := th.count(ctx, query, opts...)
-	return count > 0, err
+	if result.UpsertedID != nil {
+		th.fillInsertedID(model, result.UpsertedID)
+	}
+	return result.MatchedCount, result.ModifiedCount, nil
 }
 
-func (th *Collection[MODEL, ID]) count(ctx context.Context, filter any, opts ...*options.CountOptions) (int64, error) {
-	//type Count struct {
-	//	Count int64 `bson:"count"`
-	//}
+func (th *Collection[MODEL, ID]) UpdateMany(ctx context.Context, filter any, model MODEL, opts ...*options.UpdateOptions) (int64, error) {
 
-	//filter = bson.A{
-	//	bson.M{
-	//		"$match": filter,
-	//	},
-	//	bson.M{
-	//		"$count": "count",
-	//	},
-	//}
-	count, err := th.collection.CountDocuments(ctx, filter, opts...)
+	result, err := th.doUpdate(ctx, filter, model, true, th.mapToUpdate, opts)
 	if err != nil {
-		return 0, errors.WithStack(err)
+		return 0, err
 	}
-	return count, nil
-}
 
-// 获取属性对应的schemaField
-func (th *Collection[MODEL, ID]) mustSchemaField(fieldName string) (*entity.EntityField, error) {
+	return result.ModifiedCount, err
+}
 
-	schemaField := th.schema.LookUpField(fieldName)
+// mapToUpsert $set the non zero fields of model as mapToUpdate, the create time, now when it is zero,
+// and the id are $setOnInsert, an update keeps them
+func (th *Collection[MODEL, ID]) mapToUpsert(model any) (bson.M, error) {
+	return th.upsertOf(th.mapToUpdate)(model)
+}
 
-	if schemaField == nil {
-		return nil, errors.WithStack(fmt.Errorf("fieldName name %s can not be found in %s", fieldName, th.schema.ModelType.Name()))
+// upsertOf the update of makeUpdate with the create time and the id moved to $setOnInsert, see mapToUpsert
+func (th *Collection[MODEL, ID]) upsertOf(makeUpdate func(model any) (bson.M, error)) func(model any) (bson.M, error) {
+	return func(model any) (bson.M, error) {
+		update, err := makeUpdate(model)
+		if err != nil {
+			return nil, err
+		}
+		return th.moveToSetOnInsert(model, update), nil
 	}
-
-	return schemaField, nil
 }
 
-// InsertOne inert one
-func (th *Collection[MODEL, ID]) InsertOne(ctx context.Context, model MODEL, opts ...*options.InsertOneOptions) error {
+// moveToSetOnInsert move the id and the create time of the $set of update to $setOnInsert, an empty $set is dropped
+func (th *Collection[MODEL, ID]) moveToSetOnInsert(model any, update bson.M) bson.M {
+	set, _ := update["$set"].(bson.M)
 
-	if err := th.tryCallBeforeSaveHook(model); err != nil {
-		return err
+	onInsert := bson.M{}
+	if field := th.schema.IdField; field != nil {
+		if id, ok := set[field.DBName]; ok {
+			onInsert[field.DBName] = id
+			delete(set, field.DBName)
+		}
+	}
+	if field := th.schema.CreateTimeField; field != nil {
+		createdAt, zero := field.ValueOf(reflect.ValueOf(model))
+		if zero {
+			createdAt = th.now()
+		}
+		onInsert[field.DBName] = createdAt
+		delete(set, field.DBName)
+	}
+	if len(onInsert) > 0 {
+		update["$setOnInsert"] = onInsert
+	}
+	if len(set) == 0 {
+		delete(update, "$set")
 	}
+	return update
+}
+
+// UpdateSetNonZero same as UpdateOne, zero fields of doc are ignored
+func (th *Collection[MODEL, ID]) UpdateSetNonZero(ctx context.Context, filter any, doc MODEL, opts ...*options.UpdateOptions) (bool, error) {
+	_, modified, err := th.UpdateOne(ctx, filter, doc, DriverUpdateOptions(opts...))
+	return modified > 0, err
+}
+
+// UpdateSetAll update one document matched by filter, every field of doc except the id is set, zero values included
+func (th *Collection[MODEL, ID]) UpdateSetAll(ctx context.Context, filter any, doc MODEL, opts ...*options.UpdateOptions) (bool, error) {
 
-	result, err := th.collection.InsertOne(ctx, model, opts...)
+	result, err := th.doUpdate(ctx, filter, doc, false, th.mapAllToUpdate, opts)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	th.tryCallAfterSaveHook(model, result.InsertedID)
+	return result.ModifiedCount > 0, err
+}
 
-	return nil
+// UpdateOption options of UpdateOne, built by Option() as for the reads: Upsert, SetZeroFields and UpdateOptions apply
+type UpdateOption = FindOption
+
+// SetZeroFields let UpdateOne $set the zero fields of model too, same as Option().SetZeroFields()
+func SetZeroFields() *UpdateOption {
+	return Option().SetZeroFields()
 }
 
-// InsertMany 创建一组内容
-func (th *Collection[MODEL, ID]) InsertMany(ctx context.Context, models []MODEL, opts ...*options.InsertManyOptions) error {
+// DriverUpdateOptions pass the driver options of the update, e.g. a hint, to UpdateOne, same as Option().UpdateOptions(opts...)
+func DriverUpdateOptions(opts ...*options.UpdateOptions) *UpdateOption {
+	return Option().UpdateOptions(opts...)
+}
 
-	var ms = make([]any, 0, len(models))
-	for _, model := range models {
-		err := th.tryCallBeforeSaveHook(model)
-		if err != nil {
-			return err
+// updateOf the maker of the update document and the driver options of opts, with Upsert the fields of model
+// only written by an insertion are $setOnInsert
+func (th *Collection[MODEL, ID]) updateOf(opts []*UpdateOption) (func(model any) (bson.M, error), []*options.UpdateOptions) {
+	var present []*UpdateOption
+	for _, opt := range opts {
+		if opt != nil {
+			present = append(present, opt)
 		}
-		ms = append(ms, model)
 	}
-
-	result, err := th.collection.InsertMany(ctx, ms, opts...)
-	if err != nil {
-		return err
+	option := Merge(present)
+	if option == nil {
+		return th.mapToUpdate, nil
 	}
 
-	for i, model := range models {
-		th.tryCallAfterSaveHook(model, result.InsertedIDs[i])
+	makeUpdate := th.mapToUpdate
+	if option.setZeroFields {
+		makeUpdate = th.mapAllToUpdate
 	}
+	updateOpts := append([]*options.UpdateOptions{}, option.updateOpts...)
+	if option.upsert {
+		makeUpdate = th.upsertOf(makeUpdate)
+		updateOpts = append(updateOpts, options.Update().SetUpsert(true))
+	}
+	return makeUpdate, updateOpts
+}
 
-	return nil
+// RenameField rename the field from to the key to in the documents matched by filter, return the number of modified documents
+func (th *Collection[MODEL, ID]) RenameField(ctx context.Context, filter any, from string, to string, opts ...*options.UpdateOptions) (int64, error) {
+	return th.UpdateManyWith(ctx, filter, Update().Rename(from, to), opts...)
 }
 
-func (th *Collection[MODEL, ID]) UpdateOneById(ctx context.Context, id ID, model MODEL, opts ...*options.UpdateOptions) (bool, error) {
-	return th.UpdateOne(ctx, bson.M{th.schema.IdDBName(): id}, model, opts...)
+// UpdateManyWith update the documents matched by filter with the operators of update, return the number of modified documents,
+// and the array filters of its identifiers
+func (th *Collection[MODEL, ID]) UpdateManyWith(ctx context.Context, filter any, update *UpdateBuilder, opts ...*options.UpdateOptions) (int64, error) {
+	_, modified, err := th.updateWith(ctx, filter, update, true, opts)
+	return modified, err
+}
+
+// UpdateManyWithCounts same as UpdateManyWith, and return the matched count too
+func (th *Collection[MODEL, ID]) UpdateManyWithCounts(ctx context.Context, filter any, update *UpdateBuilder, opts ...*options.UpdateOptions) (matched int64, modified int64, err error) {
+	return th.updateWith(ctx, filter, update, true, opts)
 }
 
-func (th *Collection[MODEL, ID]) UpdateOne(ctx context.Context, filter any, model MODEL, opts ...*options.UpdateOptions) (bool, error) {
+// UpdateOneWith update one document matched by filter with the operators of update, e.g.
+// Update().Inc("Views", 1).Push("Tags", "go"), return the matched and modified counts
+func (th *Collection[MODEL, ID]) UpdateOneWith(ctx context.Context, filter any, update *UpdateBuilder, opts ...*options.UpdateOptions) (matched int64, modified int64, err error) {
+	return th.updateWith(ctx, filter, update, false, opts)
+}
 
-	result, err := th.doUpdate(ctx, filter, model, false, opts)
+func (th *Collection[MODEL, ID]) updateWith(ctx context.Context, filter any, update *UpdateBuilder, multi bool, opts []*options.UpdateOptions) (int64, int64, error) {
+	arrayFilters, err := update.toArrayFilters(th.schema)
 	if err != nil {
-		return false, err
+		return 0, 0, err
+	}
+	if len(arrayFilters) > 0 {
+		opts = append(opts, options.Update().SetArrayFilters(options.ArrayFilters{Filters: arrayFilters}))
 	}
 
-	return result.ModifiedCount > 0, err
+	result, err := th.doUpdate(ctx, filter, nil, multi, func(any) (bson.M, error) {
+		return th.builderUpdate(update)
+	}, opts)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return result.MatchedCount, result.ModifiedCount, nil
 }
 
-func (th *Collection[MODEL, ID]) UpdateMany(ctx context.Context, filter any, model MODEL, opts ...*options.UpdateOptions) (int64, error) {
+// builderUpdate the update document of update, the values $set to encrypted fields are replaced by their ciphertext
+func (th *Collection[MODEL, ID]) builderUpdate(update *UpdateBuilder) (bson.M, error) {
+	document, err := update.toUpdate(th.schema)
+	if err != nil {
+		return nil, err
+	}
+	if set, ok := document["$set"].(bson.M); ok {
+		if err := th.encryptUpdate(set); err != nil {
+			return nil, err
+		}
+	}
+	return document, nil
+}
+
+// UpdateArrayElement $set the fields of value in the elements of arrayPath matching elemCond,
+// in the documents matched by filter, return the number of modified documents, see UpdateBuilder.SetArrayElement
+func (th *Collection[MODEL, ID]) UpdateArrayElement(ctx context.Context, filter any, arrayPath string, elemCond bson.M, value bson.M, opts ...*options.UpdateOptions) (int64, error) {
+	return th.UpdateManyWith(ctx, filter, Update().SetArrayElement(arrayPath, elemCond, value), opts...)
+}
 
-	result, err := th.doUpdate(ctx, filter, model, true, opts)
+// Touch set the update time field of the documents matched by filter to now without changing other fields,
+// e.g. to track last seen, return the number of modified documents
+func (th *Collection[MODEL, ID]) Touch(ctx context.Context, filter any, opts ...*options.UpdateOptions) (int64, error) {
+	result, err := th.doUpdate(ctx, filter, nil, true, func(any) (bson.M, error) {
+		return th.touchUpdate()
+	}, opts)
 	if err != nil {
 		return 0, err
 	}
 
-	return result.ModifiedCount, err
+	return result.ModifiedCount, nil
+}
+
+func (th *Collection[MODEL, ID]) touchUpdate() (bson.M, error) {
+	field := th.schema.UpdateTimeField
+	if field == nil {
+		return nil, errors.Errorf("%s has no update time field to touch, tag a time.Time field by jmongo:\"autoUpdateTime\"", th.schema.Name)
+	}
+	return bson.M{"$set": bson.M{field.DBName: th.now()}}, nil
 }
 
-func (th *Collection[MODEL, ID]) doUpdate(ctx context.Context, filter any, model any, multi bool, opts []*options.UpdateOptions) (*mongo.UpdateResult, error) {
+func (th *Collection[MODEL, ID]) doUpdate(ctx context.Context, filter any, model any, multi bool, makeUpdate func(model any) (bson.M, error), opts []*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	ctx, cancel := th.withTimeout(ctx)
+	defer cancel()
 
 	err := th.tryCallBeforeUpdateHook(model)
 	if err != nil {
@@ -493,43 +1750,78 @@ func (th *Collection[MODEL, ID]) doUpdate(ctx context.Context, filter any, model
 	if count == 0 {
 		return nil, errors.WithStack(errortype.ErrFilterNotContainAnyCondition)
 	}
+	// restoring a soft deleted document needs a condition on the field, e.g. {deletedAt: {$ne: null}}
+	query = th.liveFilter(query, false)
 
-	update, err := th.mapToUpdate(model)
+	update, err := makeUpdate(model)
 	if err != nil {
 		return nil, err
 	}
 
 	var result *mongo.UpdateResult
 
-	if multi {
-		result, err = th.collection.UpdateMany(ctx, query, update, opts...)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		result, err = th.collection.UpdateOne(ctx, query, update, opts...)
-		if err != nil {
-			return nil, err
+	result, err = retry(ctx, th.retryPolicy(), true, func() (*mongo.UpdateResult, error) {
+		if multi {
+			return th.collection.UpdateMany(ctx, query, update, opts...)
 		}
+		return th.collection.UpdateOne(ctx, query, update, opts...)
+	})
+	if err != nil {
+		return nil, err
 	}
 
+	th.markWrite()
 	th.tryCallAfterUpdateHook(model)
 
 	return result, nil
 }
 
+// mapToUpdate $set the non-zero fields
 func (th *Collection[MODEL, ID]) mapToUpdate(model any) (bson.M, error) {
-	value := reflect.ValueOf(model)
+	return th.setUpdate(model, true, false)
+}
 
-	update := bson.M{}
-	for _, field := range th.schema.Fields {
-		object, zero := field.ValueOf(value)
-		// continue if field value is zero
-		if zero {
-			continue
-		}
-		// handle by the field itself
-		update[field.DBName] = object
+// mapAllToUpdate $set every field except the id and the create time, which an update keeps, zero values included
+func (th *Collection[MODEL, ID]) mapAllToUpdate(model any) (bson.M, error) {
+	update, err := th.setUpdate(model, false, true)
+	if err != nil {
+		return nil, err
+	}
+	if field := th.schema.CreateTimeField; field != nil {
+		delete(update["$set"].(bson.M), field.DBName)
+	}
+	return update, nil
+}
+
+// documentBuffers the scratch documents of setUpdate
+var documentBuffers = sync.Pool{
+	New: func() any {
+		return new(bson.D)
+	},
+}
+
+// setUpdate the $set of the fields of model, built in a pooled document so only the $set itself is allocated
+func (th *Collection[MODEL, ID]) setUpdate(model any, omitZero bool, skipId bool) (bson.M, error) {
+	buffer := documentBuffers.Get().(*bson.D)
+	doc := th.schema.AppendDocument((*buffer)[:0], reflect.ValueOf(model), omitZero)
+
+	update := make(bson.M, len(doc))
+	for _, e := range doc {
+		update[e.Key] = e.Value
+	}
+	if skipId && th.schema.IdField != nil {
+		delete(update, th.schema.IdField.DBName)
+	}
+
+	// drop the references to the values before the buffer is reused
+	for i := range doc {
+		doc[i] = bson.E{}
+	}
+	*buffer = doc[:0]
+	documentBuffers.Put(buffer)
+
+	if err := th.encryptUpdate(update); err != nil {
+		return nil, err
 	}
 
 	return bson.M{
@@ -538,60 +1830,198 @@ func (th *Collection[MODEL, ID]) mapToUpdate(model any) (bson.M, error) {
 }
 
 func (th *Collection[MODEL, ID]) FindAndModify(ctx context.Context, filter any, document any, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult {
-	return th.collection.FindOneAndUpdate(ctx, filter, document, opts...)
+	ctx, cancel := th.withTimeout(ctx)
+	defer cancel()
+	result := th.collection.FindOneAndUpdate(ctx, filter, document, opts...)
+	if result.Err() == nil {
+		th.markWrite()
+	}
+	return result
 }
 
-func (th *Collection[MODEL, ID]) DeleteOneById(ctx context.Context, id ID) (bool, error) {
-	return th.DeleteOne(ctx, bson.M{th.schema.IdDBName(): id})
-}
-func (th *Collection[MODEL, ID]) DeleteOne(ctx context.Context, filter any) (bool, error) {
+// FindOneAndUpdate atomically update one document matched by filter with the operators of update and return it,
+// the original unless Option().ReturnAfter(true), the projection and the sort of opts apply to it.
+// ok is false when nothing matched, it is true for an upserted document, which has no original to return.
+// It is retried only when the driver knows it did not run, an $inc is never applied twice
+func (th *Collection[MODEL, ID]) FindOneAndUpdate(ctx context.Context, filter any, update *UpdateBuilder, opts ...*FindOption) (MODEL, bool, error) {
+	ctx, cancel := th.withTimeout(ctx)
+	defer cancel()
+
+	var out MODEL
 
 	query, count, err := th.convertFilter(filter)
 	if err != nil {
-		return false, err
+		return out, false, err
 	}
 
 	if count == 0 {
-		return false, errors.WithStack(errortype.ErrModelTypeNotMatchInCollection)
+		return out, false, errors.WithStack(errortype.ErrFilterNotContainAnyCondition)
 	}
 
-	result, err := th.collection.DeleteOne(ctx, query)
+	document, err := th.builderUpdate(update)
 	if err != nil {
-		return false, err
+		return out, false, err
 	}
-	return result.DeletedCount > 0, nil
-}
 
-func (th *Collection[MODEL, ID]) Delete(ctx context.Context, filter any) (bool, error) {
-	count, err := th.doDelete(ctx, filter, true)
-	return count > 0, err
+	option := Merge(opts)
+	if option == nil {
+		option = Option()
+	}
+	query = th.liveFilter(query, option.includeDeleted)
+	findOpts, err := option.makeFindOneAndUpdateOptions(th.schema)
+	if err != nil {
+		return out, false, err
+	}
+	arrayFilters, err := update.toArrayFilters(th.schema)
+	if err != nil {
+		return out, false, err
+	}
+	if len(arrayFilters) > 0 {
+		findOpts.SetArrayFilters(options.ArrayFilters{Filters: arrayFilters})
+	}
+
+	one, err := retry(ctx, th.retryPolicy().safeWrites(), true, func() (*mongo.SingleResult, error) {
+		one := th.collection.FindOneAndUpdate(ctx, query, document, findOpts)
+		return one, one.Err()
+	})
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			// with upsert nothing is returned only for the original of the inserted document
+			if option.upsert {
+				th.markWrite()
+				return out, true, nil
+			}
+			return out, false, nil
+		}
+		return out, false, errors.WithStack(err)
+	}
+	th.markWrite()
+
+	out, err = th.decodeOne(one)
+	if err != nil {
+		return out, false, err
+	}
+	return out, true, nil
 }
 
-func (th *Collection[MODEL, ID]) doDelete(ctx context.Context, filter any, multi bool) (int64, error) {
+// FindOneAndDelete atomically delete one document matched by filter and return it, ok is false when nothing matched,
+// set the sort of opts to pick the document, e.g. the oldest of a queue. Soft deleted documents are not matched
+// unless filter has a condition on the field. It is retried only when the driver knows it did not run
+func (th *Collection[MODEL, ID]) FindOneAndDelete(ctx context.Context, filter any, opts ...*options.FindOneAndDeleteOptions) (MODEL, bool, error) {
+	ctx, cancel := th.withTimeout(ctx)
+	defer cancel()
+
+	var out MODEL
 
 	query, count, err := th.convertFilter(filter)
 	if err != nil {
-		return 0, err
+		return out, false, err
 	}
 
 	if count == 0 {
-		return 0, errors.WithStack(errortype.ErrModelTypeNotMatchInCollection)
+		return out, false, errors.WithStack(errortype.ErrFilterNotContainAnyCondition)
+	}
+
+	query = th.liveFilter(query, false)
+
+	one, err := retry(ctx, th.retryPolicy().safeWrites(), true, func() (*mongo.SingleResult, error) {
+		deleteOpts := opts
+		if maxTime, ok := deadlineMaxTime(ctx); ok {
+			deleteOpts = append([]*options.FindOneAndDeleteOptions{options.FindOneAndDelete().SetMaxTime(maxTime)}, opts...)
+		}
+		one := th.collection.FindOneAndDelete(ctx, query, deleteOpts...)
+		return one, one.Err()
+	})
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return out, false, nil
+		}
+		return out, false, errors.WithStack(err)
+	}
+	th.markWrite()
+
+	out, err = th.decodeOne(one)
+	if err != nil {
+		return out, false, errors.WithStack(err)
+	}
+	return out, true, nil
+}
+
+func (th *Collection[MODEL, ID]) DeleteOneById(ctx context.Context, id ID) (bool, error) {
+	return th.DeleteOne(ctx, bson.M{th.schema.IdDBName(): id})
+}
+
+// DeleteOne delete one document matched by filter, filter can also be an id.
+// errortype.ErrEmptyFilter is returned when filter has no condition
+func (th *Collection[MODEL, ID]) DeleteOne(ctx context.Context, filter any) (bool, error) {
+	count, err := th.doDelete(ctx, filter, false, false)
+	return count > 0, err
+}
+
+func (th *Collection[MODEL, ID]) Delete(ctx context.Context, filter any) (bool, error) {
+	count, err := th.doDelete(ctx, filter, true, false)
+	return count > 0, err
+}
+
+// DeleteOption options of DeleteMany
+type DeleteOption struct {
+	allowEmptyFilter bool
+}
+
+// AllowEmptyFilter let DeleteMany delete every document when the filter has no condition
+func AllowEmptyFilter() *DeleteOption {
+	return &DeleteOption{allowEmptyFilter: true}
+}
+
+// DeleteMany delete the documents matched by filter, filter can also be ids, return the number of deleted documents.
+// errortype.ErrEmptyFilter is returned when filter has no condition, e.g. a zero value filter struct,
+// unless AllowEmptyFilter is passed to empty the collection on purpose
+func (th *Collection[MODEL, ID]) DeleteMany(ctx context.Context, filter any, opts ...*DeleteOption) (int64, error) {
+	allowEmpty := false
+	for _, opt := range opts {
+		if opt != nil && opt.allowEmptyFilter {
+			allowEmpty = true
+		}
 	}
+	return th.doDelete(ctx, filter, true, allowEmpty)
+}
 
-	var result *mongo.DeleteResult
-	if multi {
-		result, err = th.collection.DeleteMany(ctx, query)
-	} else {
-		result, err = th.collection.DeleteOne(ctx, query)
+func (th *Collection[MODEL, ID]) doDelete(ctx context.Context, filter any, multi bool, allowEmpty bool) (int64, error) {
+	ctx, cancel := th.withTimeout(ctx)
+	defer cancel()
+
+	query, err := th.deleteQuery(filter, allowEmpty)
+	if err != nil {
+		return 0, err
 	}
 
+	result, err := retry(ctx, th.retryPolicy(), true, func() (*mongo.DeleteResult, error) {
+		if multi {
+			return th.collection.DeleteMany(ctx, query)
+		}
+		return th.collection.DeleteOne(ctx, query)
+	})
+
 	if err != nil {
 		return 0, err
 	}
+	th.markWrite()
 
 	return result.DeletedCount, nil
 }
 
+// deleteQuery the converted filter of a delete, the query itself is checked as ids are conditions too
+func (th *Collection[MODEL, ID]) deleteQuery(filter any, allowEmpty bool) (any, error) {
+	query, _, err := th.convertFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	if isEmptyQuery(query) && !allowEmpty {
+		return nil, errors.WithStack(errortype.ErrEmptyFilter)
+	}
+	return query, nil
+}
+
 func (th *Collection[MODEL, ID]) EnsureIndex(model *mongo.IndexModel) (string, error) {
 	return th.collection.Indexes().CreateOne(context.Background(), *model)
 }
@@ -645,7 +2075,27 @@ func (th *Collection[MODEL, ID]) Watch(opts *options.ChangeStreamOptions, matchS
 	}
 }
 
+// fillTimestamps set the update time of a model, and the create time when the model is inserted and it is not set,
+// model which is not a pointer to the model type is ignored
+func (th *Collection[MODEL, ID]) fillTimestamps(model any, create bool) {
+	value := reflect.ValueOf(model)
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Type() != th.schema.ModelType {
+		return
+	}
+
+	now := reflect.ValueOf(th.now())
+	if field := th.schema.CreateTimeField; field != nil && create {
+		if _, zero := field.ValueOf(value); zero {
+			field.ReflectValueOf(value).Set(now)
+		}
+	}
+	if field := th.schema.UpdateTimeField; field != nil {
+		field.ReflectValueOf(value).Set(now)
+	}
+}
+
 func (th *Collection[MODEL, ID]) tryCallBeforeSaveHook(model any) error {
+	th.fillTimestamps(model, true)
 	if d, ok := model.(BeforeSave); ok {
 		err := d.BeforeSave()
 		if err != nil {
@@ -656,16 +2106,107 @@ func (th *Collection[MODEL, ID]) tryCallBeforeSaveHook(model any) error {
 			return errors.WithStack(err)
 		}
 	}
+	// after BeforeSave, which may normalize the key
+	return th.fillComputedID(model)
+}
+
+// fillComputedID set the id by ComputeID when it is zero
+func (th *Collection[MODEL, ID]) fillComputedID(model any) error {
+	d, ok := model.(ComputeID)
+	if !ok {
+		return nil
+	}
+	value := reflect.ValueOf(model)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return errors.WithStack(fmt.Errorf("model of ComputeID must be a pointer, got %T", model))
+	}
+
+	field := th.schema.IdField
+	if _, zero := field.ValueOf(value); !zero {
+		return nil
+	}
+
+	id := reflect.ValueOf(d.ComputeID())
+	switch {
+	case !id.IsValid():
+		return errors.WithStack(fmt.Errorf("ComputeID of %T returns nil", model))
+	case id.Type().AssignableTo(field.FieldType):
+		field.ReflectValueOf(value).Set(id)
+	// named types of the same kind, e.g. string to SObjectId, not int to string
+	case id.Kind() == field.FieldType.Kind() && id.Type().ConvertibleTo(field.FieldType):
+		field.ReflectValueOf(value).Set(id.Convert(field.FieldType))
+	default:
+		return errors.WithStack(fmt.Errorf("ComputeID of %T returns %s which can not be the id %s", model, id.Type(), field.FieldType))
+	}
 	return nil
 }
 
-func (th *Collection[MODEL, ID]) tryCallAfterSaveHook(model any, id ID) {
+// fillInsertedID write the id generated by mongo back to model when its id is zero,
+// an ObjectID into a primitive.ObjectID, a *primitive.ObjectID or a SObjectId
+func (th *Collection[MODEL, ID]) fillInsertedID(model any, insertedID any) {
+	field := th.schema.IdField
+	value := reflect.ValueOf(model)
+	if field == nil || value.Kind() != reflect.Ptr || value.IsNil() {
+		return
+	}
+	if _, zero := field.ValueOf(value); !zero {
+		return
+	}
+
+	id := reflect.ValueOf(insertedID)
+	if oid, ok := insertedID.(primitive.ObjectID); ok {
+		switch {
+		case field.FieldType == reflect.TypeOf(&primitive.ObjectID{}):
+			id = reflect.ValueOf(&oid)
+		case field.FieldType.Kind() == reflect.String:
+			id = reflect.ValueOf(oid.Hex())
+		}
+	}
+	switch {
+	case !id.IsValid():
+	case id.Type().AssignableTo(field.FieldType):
+		field.ReflectValueOf(value).Set(id)
+	case id.Kind() == field.FieldType.Kind() && id.Type().ConvertibleTo(field.FieldType):
+		field.ReflectValueOf(value).Set(id.Convert(field.FieldType))
+	}
+}
+
+// withInsertedID marshal the document to insert with an _id, an ObjectID is added first when it has none as the driver does.
+// BulkWrite does not report the ids of the insertions, so they are generated before writing
+func withInsertedID(document any) (bson.Raw, any, error) {
+	doc, err := bson.MarshalWithRegistry(DefaultRegistry, document)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	value, err := bson.Raw(doc).LookupErr("_id")
+	if err != nil {
+		oid := primitive.NewObjectID()
+		index, withID := bsoncore.AppendDocumentStart(nil)
+		withID = bsoncore.AppendObjectIDElement(withID, "_id", oid)
+		withID = append(withID, doc[4:len(doc)-1]...)
+		withID, err = bsoncore.AppendDocumentEnd(withID, index)
+		if err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+		return withID, oid, nil
+	}
+
+	var id any
+	if err := value.UnmarshalWithRegistry(DefaultRegistry, &id); err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	return doc, id, nil
+}
+
+func (th *Collection[MODEL, ID]) tryCallAfterSaveHook(model any, id any) {
 	if d, ok := model.(AfterSave); ok {
 		d.AfterSave(id)
 	}
 }
 
 func (th *Collection[MODEL, ID]) tryCallBeforeUpdateHook(model any) error {
+	th.fillTimestamps(model, false)
 	if d, ok := model.(BeforeUpdate); ok {
 		err := d.BeforeUpdate()
 		if err != nil {
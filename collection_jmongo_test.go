@@ -0,0 +1,80 @@
+// Code generated by jmongogen. DO NOT EDIT.
+
+package jmongo
+
+import (
+	"github.com/JackWSK/jmongo/entity"
+	"reflect"
+)
+
+// registerTestAccessors use the generated accessors for Test until entity.UnregisterAccessors
+func registerTestAccessors() {
+	entity.RegisterAccessors(&Test{}, map[string]entity.FieldAccessor{
+		"Id": {
+			ValueOf: func(value reflect.Value) (any, bool) {
+				m := jmongogenTest(value)
+				return m.Id, entity.IsZero(m.Id)
+			},
+			ReflectValueOf: func(value reflect.Value) reflect.Value {
+				return reflect.ValueOf(&jmongogenTest(value).Id).Elem()
+			},
+		},
+		"Name": {
+			ValueOf: func(value reflect.Value) (any, bool) {
+				m := jmongogenTest(value)
+				return m.Name, m.Name == ""
+			},
+			ReflectValueOf: func(value reflect.Value) reflect.Value {
+				return reflect.ValueOf(&jmongogenTest(value).Name).Elem()
+			},
+		},
+		"Age": {
+			ValueOf: func(value reflect.Value) (any, bool) {
+				m := jmongogenTest(value)
+				return m.Age, m.Age == 0
+			},
+			ReflectValueOf: func(value reflect.Value) reflect.Value {
+				return reflect.ValueOf(&jmongogenTest(value).Age).Elem()
+			},
+		},
+		"HelloWorld": {
+			ValueOf: func(value reflect.Value) (any, bool) {
+				m := jmongogenTest(value)
+				return m.HelloWorld, m.HelloWorld == 0
+			},
+			ReflectValueOf: func(value reflect.Value) reflect.Value {
+				return reflect.ValueOf(&jmongogenTest(value).HelloWorld).Elem()
+			},
+		},
+		"UserPassword": {
+			ValueOf: func(value reflect.Value) (any, bool) {
+				m := jmongogenTest(value)
+				return m.UserPassword, m.UserPassword == 0
+			},
+			ReflectValueOf: func(value reflect.Value) reflect.Value {
+				return reflect.ValueOf(&jmongogenTest(value).UserPassword).Elem()
+			},
+		},
+		"OrderId": {
+			ValueOf: func(value reflect.Value) (any, bool) {
+				m := jmongogenTest(value)
+				return m.OrderId, entity.IsZero(m.OrderId)
+			},
+			ReflectValueOf: func(value reflect.Value) reflect.Value {
+				return reflect.ValueOf(&jmongogenTest(value).OrderId).Elem()
+			},
+		},
+	})
+}
+
+// jmongogenTest the model of value, a *Test or an addressable Test
+func jmongogenTest(value reflect.Value) *Test {
+	if value.Kind() == reflect.Ptr {
+		return value.Interface().(*Test)
+	}
+	if value.CanAddr() {
+		return value.Addr().Interface().(*Test)
+	}
+	model := value.Interface().(Test)
+	return &model
+}
@@ -1,12 +1,21 @@
 package jmongo
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
+	"github.com/JackWSK/jmongo/entity"
+	"github.com/JackWSK/jmongo/errortype"
+	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 )
@@ -50,9 +59,9 @@ type TestFilter struct {
 }
 
 func Test_Raw_Insert(t *testing.T) {
-	c := setupMongoClient(MongoUrl)
+	c := setupMongoClient(t, MongoUrl)
 	db := c.Database("test")
-	col := NewCollection[*Test](&Test{}, db)
+	col := NewCollection[*Test, SObjectId](&Test{}, db)
 
 	err := col.InsertOne(context.Background(), &Test{
 		Name:         "abc",
@@ -62,7 +71,7 @@ func Test_Raw_Insert(t *testing.T) {
 		OrderId:      NewSObjectId(),
 	})
 
-	_, err = col.UpdateOne(context.Background(), bson.M{"_id": SObjectId("6425087c44ad0aff2c691cea")}, &Test{
+	_, _, err = col.UpdateOne(context.Background(), bson.M{"_id": SObjectId("6425087c44ad0aff2c691cea")}, &Test{
 		Name:         "abc",
 		Age:          8,
 		HelloWorld:   123,
@@ -77,9 +86,9 @@ func Test_Raw_Insert(t *testing.T) {
 }
 
 func Test_Bulk(t *testing.T) {
-	c := setupMongoClient(MongoUrl)
+	c := setupMongoClient(t, MongoUrl)
 	db := c.Database("test")
-	col := NewCollection[*Test](&Test{}, db)
+	col := NewCollection[*Test, SObjectId](&Test{}, db)
 
 	r, err := col.BulkWrite(context.Background(), []mongo.WriteModel{
 		col.NewUpdateManyModel(TestFilter{Id: "6425087c44ad0aff2c691cea"}, &Test{
@@ -106,6 +115,1883 @@ func Test_Bulk(t *testing.T) {
 	fmt.Println(r.MatchedCount)
 }
 
+func Test_CollectionNamed(t *testing.T) {
+	col := NewCollectionNamed[*Test, SObjectId]("events_2024_06", &Test{}, newOfflineDatabase(t))
+
+	if name := col.collection.Name(); name != "events_2024_06" {
+		t.Errorf("expect collection events_2024_06, got %s", name)
+	}
+	if col.schema != newOfflineCollection(t).schema {
+		t.Error("entity should be shared by model type")
+	}
+}
+
+func Test_CollectionNamed_Read(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	db := c.Database("test")
+	col := NewCollectionNamed[*Test, SObjectId]("test_named", &Test{}, db)
+	ctx := context.Background()
+
+	model := &Test{Id: NewSObjectId(), Name: "named"}
+	if err := col.InsertOne(ctx, model); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	found, err := col.FindOneById(ctx, model.Id)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if found == nil || found.Name != "named" {
+		t.Errorf("expect to read the model back from test_named, got %+v", found)
+	}
+}
+
+type Product struct {
+	Id    SObjectId `bson:"_id,omitempty"`
+	Code  string    `bson:"code"`
+	Name  string    `bson:"name"`
+	Stock int       `bson:"stock"`
+}
+
+func Test_UpsertMany_Models(t *testing.T) {
+	col := NewCollection[*Product, SObjectId](&Product{}, newOfflineDatabase(t))
+
+	models, err := col.makeUpsertModels([]*Product{
+		{Code: "a", Name: "apple", Stock: 1},
+		{Code: "b"},
+	}, []string{"Code"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	first := models[0].(*mongo.UpdateOneModel)
+	if !reflect.DeepEqual(first.Filter, bson.M{"code": "a"}) {
+		t.Errorf("unexpected filter %v", first.Filter)
+	}
+	if !reflect.DeepEqual(first.Update, bson.M{"$set": bson.M{"name": "apple", "stock": 1}}) {
+		t.Errorf("unexpected update %v", first.Update)
+	}
+	if first.Upsert == nil || !*first.Upsert {
+		t.Error("expect upsert")
+	}
+
+	second := models[1].(*mongo.UpdateOneModel)
+	if !reflect.DeepEqual(second.Update, bson.M{"$setOnInsert": bson.M{"code": "b"}}) {
+		t.Errorf("unexpected update %v", second.Update)
+	}
+
+	// the id of a matched document can not be $set
+	models, err = col.makeUpsertModels([]*Product{{Id: NewSObjectId(), Code: "c", Name: "cherry"}}, []string{"Code"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	update := models[0].(*mongo.UpdateOneModel).Update.(bson.M)
+	if _, ok := update["$set"].(bson.M)["_id"]; ok {
+		t.Errorf("expect no id in $set, got %v", update)
+	}
+	if _, ok := update["$setOnInsert"].(bson.M)["_id"]; !ok {
+		t.Errorf("expect the id in $setOnInsert, got %v", update)
+	}
+
+	if _, err := col.makeUpsertModels(nil, nil); err == nil {
+		t.Error("expect error without key fields")
+	}
+	if _, err := col.makeUpsertModels(nil, []string{"Unknown"}); err == nil {
+		t.Error("expect error for unknown key field")
+	}
+}
+
+func Test_UpsertMany(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Product, SObjectId](&Product{}, c.Database("test"))
+	ctx := context.Background()
+
+	_, err := col.Delete(ctx, bson.M{"code": bson.M{"$in": []string{"u1", "u2", "u3"}}})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := col.InsertOne(ctx, &Product{Code: "u1", Name: "old", Stock: 1}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	result, err := col.UpsertMany(ctx, []*Product{
+		{Code: "u1", Name: "new", Stock: 2},
+		{Code: "u2", Name: "second", Stock: 3},
+		{Code: "u3", Name: "third", Stock: 4},
+	}, "Code")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if result.MatchedCount != 1 || result.UpsertedCount != 2 {
+		t.Errorf("expect 1 matched and 2 upserted, got %d and %d", result.MatchedCount, result.UpsertedCount)
+	}
+}
+
+func Test_DistinctTyped_Decode(t *testing.T) {
+	statuses, err := decodeValues[string]([]any{"paid", "shipped"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(statuses, []string{"paid", "shipped"}) {
+		t.Errorf("unexpected statuses %v", statuses)
+	}
+
+	numbers, err := decodeValues[int64]([]any{int32(1), 2.0})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(numbers, []int64{1, 2}) {
+		t.Errorf("unexpected numbers %v", numbers)
+	}
+}
+
+func Test_DistinctTyped(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Test, SObjectId](&Test{}, c.Database("test"))
+
+	ctx := context.Background()
+
+	prefix := string(NewSObjectId())
+	expect := []string{prefix + "-a", prefix + "-b"}
+	for _, name := range append(expect, prefix+"-a") {
+		if err := col.InsertOne(ctx, &Test{Id: NewSObjectId(), Name: name}); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+
+	names, err := DistinctTyped[string](ctx, col, "Name", bson.M{"name": bson.M{"$regex": "^" + prefix}})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, expect) {
+		t.Errorf("expect %v, got %v", expect, names)
+	}
+}
+
+func Test_PrimaryAfterWrite(t *testing.T) {
+	col := newOfflineCollection(t).PrimaryAfterWrite(time.Second)
+
+	now := time.Now()
+	col.now = func() time.Time {
+		return now
+	}
+
+	if col.reader() != col.collection {
+		t.Error("expect the default read preference before any write")
+	}
+
+	col.markWrite()
+	now = now.Add(500 * time.Millisecond)
+	if col.reader() != col.primary {
+		t.Error("expect primary within the window")
+	}
+
+	now = now.Add(time.Second)
+	if col.reader() != col.collection {
+		t.Error("expect the default read preference after the window")
+	}
+}
+
+func Test_FindIDs_Decode(t *testing.T) {
+	col := newOfflineCollection(t)
+
+	first, second := primitive.NewObjectID(), primitive.NewObjectID()
+	cursor, err := mongo.NewCursorFromDocuments([]any{
+		bson.M{"_id": first},
+		bson.M{"_id": second},
+	}, nil, DefaultRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := col.decodeIDs(context.Background(), cursor)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	expect := []SObjectId{SObjectId(first.Hex()), SObjectId(second.Hex())}
+	if !reflect.DeepEqual(ids, expect) {
+		t.Errorf("expect %v, got %v", expect, ids)
+	}
+}
+
+func Test_FindIDs(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Test, SObjectId](&Test{}, c.Database("test"))
+	ctx := context.Background()
+
+	model := &Test{Id: NewSObjectId(), Name: "ids"}
+	if err := col.InsertOne(ctx, model); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	ids, err := col.FindIDs(ctx, bson.M{"name": "ids"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	found := false
+	for _, id := range ids {
+		found = found || id == model.Id
+	}
+	if !found {
+		t.Errorf("expect %s in %v", model.Id, ids)
+	}
+}
+
+type Address struct {
+	City   string `bson:"city"`
+	Street string `bson:"street"`
+}
+
+type Customer struct {
+	Id      SObjectId `bson:"_id,omitempty"`
+	Name    string    `bson:"name"`
+	Address *Address  `bson:"address"`
+}
+
+func Test_Decode_NilNestedPointer(t *testing.T) {
+	col := NewCollection[*Customer, SObjectId](&Customer{}, newOfflineDatabase(t))
+
+	cursor, err := mongo.NewCursorFromDocuments([]any{
+		bson.M{"_id": primitive.NewObjectID(), "name": "a", "address": bson.M{"city": "x", "street": "y"}},
+		bson.M{"_id": primitive.NewObjectID(), "name": "b"},
+	}, nil, DefaultRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	customers, err := col.decodeAll(context.Background(), cursor, false)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if customers[0].Address == nil || *customers[0].Address != (Address{City: "x", Street: "y"}) {
+		t.Errorf("expect address to be allocated and decoded, got %+v", customers[0].Address)
+	}
+	if customers[1].Address != nil {
+		t.Errorf("expect address to stay nil, got %+v", customers[1].Address)
+	}
+
+	// nil pointer is not written by updates
+	update, err := col.mapToUpdate(customers[1])
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, ok := update["$set"].(bson.M)["address"]; ok {
+		t.Error("expect nil address to be skipped")
+	}
+}
+
+func Test_InsertMany_PartialFailure(t *testing.T) {
+	col := newOfflineCollection(t)
+
+	ids := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()}
+	models := []*Test{{Id: SObjectId(ids[0].Hex())}, {Id: SObjectId(ids[1].Hex())}, {Id: SObjectId(ids[2].Hex())}}
+	result := &mongo.InsertManyResult{InsertedIDs: []any{ids[0], ids[1], ids[2]}}
+	exception := mongo.BulkWriteException{
+		WriteErrors: []mongo.BulkWriteError{{WriteError: mongo.WriteError{Index: 1, Code: 11000, Message: "duplicate key"}}},
+	}
+
+	inserted, err := col.partialInsert(models, result, exception, options.InsertMany().SetOrdered(false))
+	var insertManyError *errortype.InsertManyError
+	if !errors.As(err, &insertManyError) {
+		t.Fatalf("expect InsertManyError, got %v", err)
+	}
+	if len(insertManyError.Failures) != 1 || insertManyError.Failures[0].Index != 1 || insertManyError.Failures[0].Id != ids[1] {
+		t.Errorf("unexpected failures %+v", insertManyError.Failures)
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		t.Error("expect the driver error to be unwrapped")
+	}
+	expect := []SObjectId{SObjectId(ids[0].Hex()), SObjectId(ids[2].Hex())}
+	if !reflect.DeepEqual(inserted, expect) {
+		t.Errorf("expect %v, got %v", expect, inserted)
+	}
+
+	// an ordered insert stops at the failure
+	inserted, _ = col.partialInsert(models, result, exception, options.InsertMany())
+	if !reflect.DeepEqual(inserted, expect[:1]) {
+		t.Errorf("expect %v, got %v", expect[:1], inserted)
+	}
+}
+
+func Test_Collection_Timeout(t *testing.T) {
+	col := newOfflineCollection(t)
+	col.Client().SetDefaultTimeout(5 * time.Second)
+
+	// no deadline, the default of the client is used and cancelled after the operation
+	ctx, cancel := col.withTimeout(context.Background())
+	deadline, ok := ctx.Deadline()
+	if !ok || time.Until(deadline) > 5*time.Second || time.Until(deadline) < 4*time.Second {
+		t.Errorf("expect deadline in 5s, got %v", deadline)
+	}
+	cancel()
+	if ctx.Err() == nil {
+		t.Error("expect ctx to be cancelled")
+	}
+
+	// an explicit deadline wins
+	explicit, explicitCancel := context.WithTimeout(context.Background(), time.Minute)
+	defer explicitCancel()
+	ctx, cancel = col.withTimeout(explicit)
+	defer cancel()
+	if ctx != explicit {
+		t.Error("expect the explicit ctx to be used")
+	}
+
+	// the collection timeout replaces the client default
+	col.Timeout(time.Second)
+	ctx, cancel = col.withTimeout(context.Background())
+	defer cancel()
+	if deadline, _ := ctx.Deadline(); time.Until(deadline) > time.Second {
+		t.Errorf("expect deadline in 1s, got %v", deadline)
+	}
+}
+
+type Event struct {
+	Id        SObjectId `bson:"_id,omitempty"`
+	Code      string    `bson:"code"`
+	Name      string    `bson:"name"`
+	CreatedAt time.Time `bson:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt"`
+}
+
+func Test_FillTimestamps(t *testing.T) {
+	col := NewCollection[*Event, SObjectId](&Event{}, newOfflineDatabase(t))
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	col.now = func() time.Time {
+		return now
+	}
+
+	event := &Event{Name: "created"}
+	if err := col.tryCallBeforeSaveHook(event); err != nil {
+		t.Fatal(err)
+	}
+	if !event.CreatedAt.Equal(now) || !event.UpdatedAt.Equal(now) {
+		t.Errorf("expect timestamps on insert, got %+v", event)
+	}
+
+	// an update only moves the update time
+	created := now
+	now = now.Add(time.Hour)
+	if err := col.tryCallBeforeUpdateHook(event); err != nil {
+		t.Fatal(err)
+	}
+	if !event.CreatedAt.Equal(created) || !event.UpdatedAt.Equal(now) {
+		t.Errorf("expect only update time to change, got %+v", event)
+	}
+
+	// an upsert writes the create time only when inserting
+	models, err := col.makeUpsertModels([]*Event{{Code: "a", Name: "upsert"}}, []string{"Code"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := bson.M{
+		"$set":         bson.M{"name": "upsert", "updatedAt": now},
+		"$setOnInsert": bson.M{"createdAt": now},
+	}
+	if update := models[0].(*mongo.UpdateOneModel).Update; !reflect.DeepEqual(update, expect) {
+		t.Errorf("unexpected update %v", update)
+	}
+}
+
+// Wire stores its name reversed in the field "n"
+type Wire struct {
+	Id   SObjectId `bson:"_id"`
+	Name string    `bson:"-"`
+}
+
+func (w *Wire) UnmarshalBSON(data []byte) error {
+	var doc struct {
+		Id SObjectId `bson:"_id"`
+		N  string    `bson:"n"`
+	}
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	runes := []rune(doc.N)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	w.Id, w.Name = doc.Id, string(runes)
+	return nil
+}
+
+func Test_Decode_Unmarshaler(t *testing.T) {
+	col := NewCollection[*Wire, SObjectId](&Wire{}, newOfflineDatabase(t))
+	id := primitive.NewObjectID()
+	doc := bson.M{"_id": id, "n": "kcaj"}
+
+	one, err := col.decodeOne(mongo.NewSingleResultFromDocument(doc, nil, DefaultRegistry))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if one == nil || one.Name != "jack" || one.Id != SObjectId(id.Hex()) {
+		t.Errorf("expect UnmarshalBSON to be used, got %+v", one)
+	}
+
+	cursor, err := mongo.NewCursorFromDocuments([]any{doc}, nil, DefaultRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	models, err := col.decodeAll(context.Background(), cursor, false)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(models) != 1 || models[0].Name != "jack" {
+		t.Errorf("expect UnmarshalBSON to be used, got %+v", models)
+	}
+}
+
+func Test_ListNames(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	db := c.Database("test")
+	ctx := context.Background()
+
+	if err := NewCollection[*Test, SObjectId](&Test{}, db).InsertOne(ctx, &Test{Name: "names"}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	databases, err := c.DatabaseNames(ctx)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	collections, err := db.CollectionNames(ctx)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	contains := func(names []string, name string) bool {
+		for _, n := range names {
+			if n == name {
+				return true
+			}
+		}
+		return false
+	}
+	if !contains(databases, "test") || !contains(collections, "test") {
+		t.Errorf("expect database and collection test, got %v and %v", databases, collections)
+	}
+}
+
+func Test_Update_SetAllAndNonZero(t *testing.T) {
+	col := NewCollection[*Product, SObjectId](&Product{}, newOfflineDatabase(t))
+	doc := &Product{Id: NewSObjectId(), Code: "a", Name: "apple", Stock: 0}
+
+	nonZero, err := col.mapToUpdate(doc)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(nonZero, bson.M{"$set": bson.M{"_id": doc.Id, "code": "a", "name": "apple"}}) {
+		t.Errorf("expect zero stock to be ignored, got %v", nonZero)
+	}
+
+	all, err := col.mapAllToUpdate(doc)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(all, bson.M{"$set": bson.M{"code": "a", "name": "apple", "stock": 0}}) {
+		t.Errorf("expect zero stock to be set, got %v", all)
+	}
+
+	// the zero create time is never set, it would reset the stored one
+	tickets := NewCollection[*Ticket, primitive.ObjectID](&Ticket{}, newOfflineDatabase(t))
+	all, err = tickets.mapAllToUpdate(&Ticket{Title: "a"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(all, bson.M{"$set": bson.M{"title": "a"}}) {
+		t.Errorf("expect the create time to be kept, got %v", all)
+	}
+}
+
+type TestYears struct {
+	Name  string `bson:"name"`
+	Years int    `bson:"happy"`
+}
+
+func Test_FindProjected_DBName(t *testing.T) {
+	col := newOfflineCollection(t)
+
+	projection, err := col.projectionOf(TestYears{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := bson.D{{Key: "_id", Value: 0}, {Key: "name", Value: 1}, {Key: "happy", Value: 1}}
+	if !reflect.DeepEqual(projection, expect) {
+		t.Errorf("expect %v, got %v", expect, projection)
+	}
+
+	cursor, err := mongo.NewCursorFromDocuments([]any{bson.M{"name": "jack", "happy": 18}}, nil, DefaultRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []TestYears
+	if err := cursor.All(context.Background(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Years != 18 {
+		t.Errorf("expect happy decoded into Years, got %+v", results)
+	}
+
+	type Unknown struct {
+		Years int `bson:"years"`
+	}
+	if _, err := col.projectionOf(Unknown{}); err == nil {
+		t.Error("expect error for a db name missing in the model")
+	}
+}
+
+func Test_FindProjected(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Test, SObjectId](&Test{}, c.Database("test"))
+	ctx := context.Background()
+
+	if err := col.InsertOne(ctx, &Test{Name: "projected", Age: 18}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	results, err := FindProjected[TestYears](ctx, col, bson.M{"name": "projected"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(results) == 0 || results[0].Years != 18 {
+		t.Errorf("expect Years 18, got %+v", results)
+	}
+}
+
+type Job struct {
+	Id        SObjectId `bson:"_id,omitempty"`
+	Status    string    `bson:"status"`
+	CreatedAt time.Time `bson:"createdAt"`
+}
+
+func Test_FindOneAndDelete(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Job, SObjectId](&Job{}, c.Database("test"))
+	ctx := context.Background()
+
+	if _, err := col.Delete(ctx, bson.M{"status": "pending"}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	oldest := &Job{Status: "pending", CreatedAt: time.Now().Add(-time.Hour)}
+	for _, job := range []*Job{{Status: "pending"}, oldest} {
+		if err := col.InsertOne(ctx, job); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+
+	job, ok, err := col.FindOneAndDelete(ctx, bson.M{"status": "pending"}, options.FindOneAndDelete().SetSort(bson.M{"createdAt": 1}))
+	if err != nil || !ok {
+		t.Fatalf("expect a job, got %v %+v", ok, err)
+	}
+	if job.Id != oldest.Id {
+		t.Errorf("expect the oldest job %s, got %s", oldest.Id, job.Id)
+	}
+	if exists, _ := col.IdExists(ctx, oldest.Id); exists {
+		t.Error("expect the job to be deleted")
+	}
+
+	_, ok, err = col.FindOneAndDelete(ctx, bson.M{"status": "missing"})
+	if err != nil || ok {
+		t.Errorf("expect nothing matched, got %v %+v", ok, err)
+	}
+}
+
+type LegacyEvent struct {
+	Name      string    `bson:"name"`
+	CreatedAt time.Time `bson:"created" jmongo:"unixTime"`
+}
+
+func Test_Decode_UnixTime(t *testing.T) {
+	col := newOfflineCollection(t)
+	schema, err := entity.GetOrParseDocument(LegacyEvent{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	cursor, err := mongo.NewCursorFromDocuments([]any{
+		bson.M{"name": "seconds", "created": int64(1700000000)},
+		bson.M{"name": "datetime", "created": time.Unix(1600000000, 0)},
+	}, nil, DefaultRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := decodeCursor[LegacyEvent](context.Background(), col, cursor, schema, false)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(events) != 2 || events[0].Name != "seconds" {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	if !events[0].CreatedAt.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("expect time from unix seconds, got %v", events[0].CreatedAt)
+	}
+	if !events[1].CreatedAt.Equal(time.Unix(1600000000, 0)) {
+		t.Errorf("expect datetime to be kept, got %v", events[1].CreatedAt)
+	}
+}
+
+type Labels []string
+
+type Photo struct {
+	Labels `bson:"labels"`
+	Id     SObjectId `bson:"_id,omitempty"`
+	Name   string    `bson:"name"`
+	Tags   []string  `bson:"tags"`
+}
+
+func Test_SliceField_RoundTrip(t *testing.T) {
+	col := NewCollection[*Photo, SObjectId](&Photo{}, newOfflineDatabase(t))
+
+	tags, _ := col.mustSchemaField("Tags")
+	if value, zero := tags.ValueOf(reflect.ValueOf(&Photo{})); !zero || value.([]string) != nil {
+		t.Errorf("expect a nil slice to be zero, got %v %v", value, zero)
+	}
+	// an empty slice is kept, so it clears the array in updates
+	if _, zero := tags.ValueOf(reflect.ValueOf(&Photo{Tags: []string{}})); zero {
+		t.Error("expect an empty slice not to be zero")
+	}
+
+	update, err := col.mapToUpdate(&Photo{Labels: Labels{"a"}, Tags: []string{"x", "y"}})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := bson.M{"$set": bson.M{"labels": Labels{"a"}, "tags": []string{"x", "y"}}}
+	if !reflect.DeepEqual(update, expect) {
+		t.Errorf("unexpected update %v", update)
+	}
+
+	cursor, err := mongo.NewCursorFromDocuments([]any{
+		bson.M{"_id": primitive.NewObjectID(), "name": "a", "labels": bson.A{"l"}, "tags": bson.A{"x", "y"}},
+	}, nil, DefaultRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	photos, err := col.decodeAll(context.Background(), cursor, false)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(photos) != 1 || !reflect.DeepEqual(photos[0].Tags, []string{"x", "y"}) || !reflect.DeepEqual(photos[0].Labels, Labels{"l"}) {
+		t.Fatalf("unexpected photos %+v", photos)
+	}
+
+	query, _, err := col.convertFilter(Cond().Contains("Tags", "x").Contains("Labels", "l"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(query, bson.M{"tags": "x", "labels": "l"}) {
+		t.Errorf("unexpected query %v", query)
+	}
+
+	// an empty slice in a struct filter is omitted
+	type PhotoFilter struct {
+		Name string
+		Tags []string
+	}
+	query, _, err = col.convertFilter(PhotoFilter{Name: "a", Tags: []string{}})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(query, bson.M{"name": "a"}) {
+		t.Errorf("unexpected query %v", query)
+	}
+}
+
+func Test_SliceField_Insert(t *testing.T) {
+	client := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Photo, SObjectId](&Photo{}, client.Database("test"))
+
+	ctx := context.Background()
+	photo := &Photo{Id: NewSObjectId(), Name: "beach", Tags: []string{"x", "sea"}}
+	if err := col.InsertOne(ctx, photo); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	found, err := col.Find(ctx, Cond().Contains("Tags", "x").Eq("Id", photo.Id))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(found) != 1 || !reflect.DeepEqual(found[0].Tags, photo.Tags) {
+		t.Errorf("unexpected photos %+v", found)
+	}
+}
+
+type Audit struct {
+	CreatedBy string    `bson:"createdBy"`
+	CreatedAt time.Time `bson:"createdAt" jmongo:"unixTime"`
+}
+
+type Revision struct {
+	Number   int       `bson:"number"`
+	SignedAt time.Time `bson:"signedAt" jmongo:"unixTime"`
+}
+
+type Contract struct {
+	Audit     `bson:",inline"`
+	*Revision `bson:",inline"`
+	Id        SObjectId `bson:"_id,omitempty"`
+	Title     string    `bson:"title"`
+}
+
+func Test_Decode_Inline(t *testing.T) {
+	col := NewCollection[*Contract, SObjectId](&Contract{}, newOfflineDatabase(t))
+
+	cursor, err := mongo.NewCursorFromDocuments([]any{
+		bson.M{"_id": primitive.NewObjectID(), "title": "a", "createdBy": "bob", "createdAt": int64(1700000000), "number": 3, "signedAt": int64(1600000000)},
+		bson.M{"_id": primitive.NewObjectID(), "title": "b", "createdBy": "amy", "createdAt": int64(1700000000)},
+	}, nil, DefaultRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contracts, err := col.decodeAll(context.Background(), cursor, false)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(contracts) != 2 {
+		t.Fatalf("unexpected contracts %+v", contracts)
+	}
+
+	first := contracts[0]
+	if first.CreatedBy != "bob" || !first.CreatedAt.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("unexpected audit %+v", first.Audit)
+	}
+	// the inline pointer is allocated to set the fields inside
+	if first.Revision == nil || first.Number != 3 || !first.SignedAt.Equal(time.Unix(1600000000, 0)) {
+		t.Errorf("unexpected revision %+v", first.Revision)
+	}
+
+	second := contracts[1]
+	if second.CreatedBy != "amy" || second.Revision != nil {
+		t.Errorf("expect the audit only, got %+v %+v", second.Audit, second.Revision)
+	}
+
+	// fields of a nil inline pointer are zero
+	update, err := col.mapToUpdate(second)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, ok := update["$set"].(bson.M)["number"]; ok {
+		t.Errorf("unexpected update %v", update)
+	}
+}
+
+func Test_Count_Estimated(t *testing.T) {
+	col := newOfflineCollection(t)
+	ctx := context.Background()
+
+	dispatch := func(filter any, opts ...*options.CountOptions) bool {
+		query, _, err := col.convertFilter(filter)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		return col.useEstimatedCount(ctx, query, opts)
+	}
+
+	if !dispatch(nil) || !dispatch(bson.M{}) || !dispatch(TestFilter{}) {
+		t.Error("expect the estimate without a filter")
+	}
+	if dispatch(bson.M{"name": "abc"}) || dispatch(SObjectId("6425087c44ad0aff2c691cea")) || dispatch(Cond().Eq("Name", "abc")) {
+		t.Error("expect CountDocuments with a filter")
+	}
+	if dispatch(nil, options.Count().SetLimit(10)) {
+		t.Error("expect CountDocuments with a limit")
+	}
+
+	col.ExactCount()
+	if dispatch(nil) {
+		t.Error("expect CountDocuments when exact")
+	}
+}
+
+type Subscriber struct {
+	Id    SObjectId `bson:"_id,omitempty"`
+	Email string    `bson:"email"`
+}
+
+func (s *Subscriber) ComputeID() any {
+	sum := sha1.Sum([]byte(strings.ToLower(s.Email)))
+	return hex.EncodeToString(sum[:])
+}
+
+func Test_ComputeID(t *testing.T) {
+	col := NewCollection[*Subscriber, SObjectId](&Subscriber{}, newOfflineDatabase(t))
+
+	a, b := &Subscriber{Email: "Bob@example.com"}, &Subscriber{Email: "bob@example.com"}
+	if err := col.tryCallBeforeSaveHook(a); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := col.tryCallBeforeSaveHook(b); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if a.Id == "" || a.Id != b.Id {
+		t.Errorf("expect the same id derived from the email, got %s %s", a.Id, b.Id)
+	}
+
+	// an id already set is kept
+	c := &Subscriber{Id: "abc", Email: "bob@example.com"}
+	if err := col.tryCallBeforeSaveHook(c); err != nil || c.Id != "abc" {
+		t.Errorf("expect the id kept, got %s %v", c.Id, err)
+	}
+}
+
+func Test_ComputeID_Duplicate(t *testing.T) {
+	client := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Subscriber, SObjectId](&Subscriber{}, client.Database("test"))
+
+	ctx := context.Background()
+	email := NewSObjectId().ToString() + "@example.com"
+	if err := col.InsertOne(ctx, &Subscriber{Email: email}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	err := col.InsertOne(ctx, &Subscriber{Email: email})
+	if !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("expect duplicate key error, got %v", err)
+	}
+}
+
+// Tick cancels the context of the test when it is decoded
+type Tick struct {
+	N int `bson:"n"`
+}
+
+var onTick func()
+
+func (th *Tick) UnmarshalBSON(data []byte) error {
+	onTick()
+	th.N = int(bson.Raw(data).Lookup("n").Int32())
+	return nil
+}
+
+func Test_Decode_KeepPartialOnCancel(t *testing.T) {
+	col := newOfflineCollection(t)
+	schema, err := entity.GetOrParseDocument(&Tick{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	documents := []any{bson.M{"n": 1}, bson.M{"n": 2}, bson.M{"n": 3}}
+	for _, keep := range []bool{true, false} {
+		cursor, err := mongo.NewCursorFromDocuments(documents, nil, DefaultRegistry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		onTick = cancel
+
+		ticks, err := decodeCursor[*Tick](ctx, col, cursor, schema, keep)
+		if keep && (len(ticks) != 1 || ticks[0].N != 1 || !errors.Is(err, context.Canceled)) {
+			t.Errorf("expect one tick and the cancellation, got %+v %v", ticks, err)
+		}
+		// the documents of the batch are all decoded without the option
+		if !keep && len(ticks) != 3 {
+			t.Errorf("expect all ticks, got %+v %v", ticks, err)
+		}
+		cancel()
+	}
+
+	// the timeout of the collection bounds the decoding of Find, it keeps the documents too
+	col.Timeout(20 * time.Millisecond)
+	cursor, err := mongo.NewCursorFromDocuments(documents, nil, DefaultRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := col.withTimeout(context.Background())
+	defer cancel()
+	onTick = func() {
+		<-ctx.Done()
+	}
+	ticks, err := decodeCursor[*Tick](ctx, col, cursor, schema, true)
+	if len(ticks) != 1 || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expect one tick and the timeout, got %+v %v", ticks, err)
+	}
+	onTick = func() {}
+
+	if !keepPartial(Merge([]*FindOption{Option().Limit(1), Option().KeepPartialOnCancel()})) || keepPartial(nil) {
+		t.Error("unexpected keepPartial")
+	}
+}
+
+func Test_UniqueFilter(t *testing.T) {
+	col := newOfflineCollection(t)
+
+	filter, err := col.uniqueFilter(&Test{Name: "abc", Age: 3}, []string{"Name", "happy"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(filter, bson.M{"name": "abc", "happy": 3}) {
+		t.Errorf("unexpected filter %v", filter)
+	}
+
+	// the document itself is excluded
+	id := NewSObjectId()
+	filter, err = col.uniqueFilter(&Test{Id: id, Name: "abc"}, []string{"Name"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(filter, bson.M{"name": "abc", "_id": bson.M{"$ne": id}}) {
+		t.Errorf("unexpected filter %v", filter)
+	}
+
+	if _, err := col.uniqueFilter(&Test{}, nil); err == nil {
+		t.Error("expect error without fields")
+	}
+}
+
+func Test_CheckUnique(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Test, SObjectId](&Test{}, c.Database("test"))
+	ctx := context.Background()
+
+	existing := &Test{Id: NewSObjectId(), Name: NewSObjectId().ToString(), Age: 1}
+	if err := col.InsertOne(ctx, existing); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	unique, err := col.CheckUnique(ctx, &Test{Name: existing.Name}, "Name")
+	if err != nil || unique {
+		t.Errorf("expect a conflict with the existing document, got %v %v", unique, err)
+	}
+	unique, err = col.CheckUnique(ctx, existing, "Name")
+	if err != nil || !unique {
+		t.Errorf("expect the document itself not to conflict, got %v %v", unique, err)
+	}
+}
+
+type RawTest struct {
+	Test `bson:",inline"`
+	Raw  bson.Raw `bson:"-" jmongo:"raw"`
+}
+
+func Test_Decode_RawField(t *testing.T) {
+	col := NewCollection[*RawTest, SObjectId](&RawTest{}, newOfflineDatabase(t))
+
+	id := primitive.NewObjectID()
+	cursor, err := mongo.NewCursorFromDocuments([]any{
+		bson.D{{Key: "_id", Value: id}, {Key: "name", Value: "abc"}, {Key: "extra", Value: int32(1)}},
+	}, nil, DefaultRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests, err := col.decodeAll(context.Background(), cursor, false)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if tests[0].Name != "abc" || tests[0].Id != SObjectId(id.Hex()) {
+		t.Errorf("expect the fields to be decoded, got %+v", tests[0].Test)
+	}
+	expect, _ := bson.Marshal(bson.D{{Key: "_id", Value: id}, {Key: "name", Value: "abc"}, {Key: "extra", Value: int32(1)}})
+	if !bytes.Equal(tests[0].Raw, expect) {
+		t.Errorf("expect the raw field to hold the document, got %s", tests[0].Raw)
+	}
+	if col.schema.LookUpField("Raw") != nil {
+		t.Error("expect the raw field not to be one of the stored fields")
+	}
+}
+
+func Test_InsertOrGet(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Test, SObjectId](&Test{}, c.Database("test"))
+	ctx := context.Background()
+
+	id := NewSObjectId()
+	doc, created, err := col.InsertOrGet(ctx, &Test{Id: id, Name: "first"}, bson.M{"_id": id})
+	if err != nil || !created || doc.Name != "first" {
+		t.Fatalf("expect the document to be created, got %v %v %+v", created, err, doc)
+	}
+
+	doc, created, err = col.InsertOrGet(ctx, &Test{Id: id, Name: "second"}, bson.M{"_id": id})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if created || doc.Name != "first" {
+		t.Errorf("expect the existing document, got %v %+v", created, doc)
+	}
+}
+
+type Appointment struct {
+	Id          SObjectId  `bson:"_id,omitempty"`
+	At          time.Time  `bson:"at"`
+	CancelledAt *time.Time `bson:"cancelledAt"`
+}
+
+func Test_Decode_InLocation(t *testing.T) {
+	shanghai := time.FixedZone("CST", 8*3600)
+	col := NewCollection[*Appointment, SObjectId](&Appointment{}, newOfflineDatabase(t)).InLocation(shanghai)
+
+	at := time.Date(2023, 4, 1, 2, 0, 0, 0, time.UTC)
+	cursor, err := mongo.NewCursorFromDocuments([]any{
+		bson.M{"_id": primitive.NewObjectID(), "at": at, "cancelledAt": at},
+		bson.M{"_id": primitive.NewObjectID(), "at": at, "cancelledAt": nil},
+	}, nil, DefaultRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	appointments, err := col.decodeAll(context.Background(), cursor, false)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	first := appointments[0]
+	if first.At.Location() != shanghai || first.At.Hour() != 10 || !first.At.Equal(at) {
+		t.Errorf("expect 10:00 in shanghai, got %v", first.At)
+	}
+	if first.CancelledAt == nil || first.CancelledAt.Location() != shanghai {
+		t.Errorf("expect the pointer to be converted, got %v", first.CancelledAt)
+	}
+	if appointments[1].CancelledAt != nil {
+		t.Errorf("expect nil to be kept, got %v", appointments[1].CancelledAt)
+	}
+}
+
+func Test_OrderByIDs(t *testing.T) {
+	col := newOfflineCollection(t)
+
+	a, b, c := NewSObjectId(), NewSObjectId(), NewSObjectId()
+	// storage order
+	docs := []*Test{{Id: a, Name: "a"}, {Id: b, Name: "b"}, {Id: c, Name: "c"}}
+
+	ordered, err := col.orderByIDs(docs, []SObjectId{c, NewSObjectId(), a, b})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var names []string
+	for _, doc := range ordered {
+		names = append(names, doc.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"c", "a", "b"}) {
+		t.Errorf("expect the order of the ids without the missing one, got %v", names)
+	}
+}
+
+func Test_FindByIDsOrdered(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Test, SObjectId](&Test{}, c.Database("test"))
+	ctx := context.Background()
+
+	var ids []SObjectId
+	for _, name := range []string{"a", "b", "c"} {
+		doc := &Test{Id: NewSObjectId(), Name: name}
+		if err := col.InsertOne(ctx, doc); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		ids = append(ids, doc.Id)
+	}
+
+	found, err := col.FindByIDsOrdered(ctx, []SObjectId{ids[2], ids[0], ids[1]})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(found) != 3 || found[0].Name != "c" || found[1].Name != "a" || found[2].Name != "b" {
+		t.Errorf("expect c, a, b, got %+v", found)
+	}
+}
+
+func Test_TouchUpdate(t *testing.T) {
+	col := NewCollection[*Event, SObjectId](&Event{}, newOfflineDatabase(t))
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	col.now = func() time.Time {
+		return now
+	}
+
+	update, err := col.touchUpdate()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := bson.M{"$set": bson.M{"updatedAt": now}}
+	if !reflect.DeepEqual(update, expect) {
+		t.Errorf("expect %v, got %v", expect, update)
+	}
+
+	if _, err := newOfflineCollection(t).touchUpdate(); err == nil {
+		t.Error("expect error for a model without update time")
+	}
+}
+
+type Parcel struct {
+	Id     SObjectId `bson:"_id,omitempty"`
+	Status string    `bson:"status"`
+}
+
+func Test_PreconditionFilter(t *testing.T) {
+	col := NewCollection[*Parcel, SObjectId](&Parcel{}, newOfflineDatabase(t))
+
+	id := NewSObjectId()
+	filter, err := col.preconditionFilter(id, Cond().Eq("Status", "paid"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := bson.M{"$and": bson.A{bson.M{"_id": id}, bson.M{"status": "paid"}}}
+	if !reflect.DeepEqual(filter, expect) {
+		t.Errorf("expect %v, got %v", expect, filter)
+	}
+}
+
+func Test_UpdateOneByIdIf(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Parcel, SObjectId](&Parcel{}, c.Database("test"))
+	ctx := context.Background()
+
+	parcel := &Parcel{Id: NewSObjectId(), Status: "created"}
+	if err := col.InsertOne(ctx, parcel); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	// not paid yet, the update is skipped
+	err := col.UpdateOneByIdIf(ctx, parcel.Id, Cond().Eq("Status", "paid"), &Parcel{Status: "shipped"})
+	if !errors.Is(err, errortype.ErrPreconditionFailed) {
+		t.Fatalf("expect precondition failed, got %v", err)
+	}
+	found, err := col.FindOneById(ctx, parcel.Id)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if found.Status != "created" {
+		t.Errorf("expect the status to be kept, got %s", found.Status)
+	}
+
+	err = col.UpdateOneByIdIf(ctx, parcel.Id, Cond().Eq("Status", "created"), &Parcel{Status: "paid"})
+	if err != nil {
+		t.Errorf("expect the update to apply, got %+v", err)
+	}
+}
+
+// naiveSetUpdate the $set built field by field into a map, as before AppendDocument
+func naiveSetUpdate(schema *entity.Entity, model any) bson.M {
+	value := reflect.ValueOf(model)
+	update := bson.M{}
+	for _, field := range schema.Fields {
+		object, zero := field.ValueOf(value)
+		if zero {
+			continue
+		}
+		update[field.DBName] = object
+	}
+	return update
+}
+
+func Test_AppendDocument(t *testing.T) {
+	col := newOfflineCollection(t)
+	doc := &Test{Id: NewSObjectId(), Name: "tom", Age: 12, UserPassword: 3}
+
+	update, err := col.mapToUpdate(doc)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if want := naiveSetUpdate(col.schema, doc); !reflect.DeepEqual(update["$set"], want) {
+		t.Errorf("expect %v, got %v", want, update["$set"])
+	}
+
+	appended := col.schema.AppendDocument(nil, reflect.ValueOf(doc), false)
+	if len(appended) != len(col.schema.Fields) || appended[0].Key != col.schema.Fields[0].DBName {
+		t.Errorf("expect every field in order, got %v", appended)
+	}
+	if reused := col.schema.AppendDocument(appended[:0], reflect.ValueOf(doc), true); &reused[0] != &appended[0] {
+		t.Error("expect the document to be reused")
+	}
+}
+
+// updateSink keeps the updates of the benchmarks on the heap as the ones sent to the driver
+var updateSink bson.M
+
+func Benchmark_SetUpdate(b *testing.B) {
+	col := newOfflineCollection(b)
+	doc := &Test{Id: NewSObjectId(), Name: "tom", Age: 12, HelloWorld: 1, UserPassword: 3, OrderId: NewSObjectId()}
+
+	// the update as mapToUpdate built it before AppendDocument
+	b.Run("naive", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			updateSink = bson.M{"$set": naiveSetUpdate(col.schema, doc)}
+		}
+	})
+	b.Run("mapToUpdate", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			update, err := col.mapToUpdate(doc)
+			if err != nil {
+				b.Fatal(err)
+			}
+			updateSink = update
+		}
+	})
+	// the maps are allocated by both, the generated accessors box the small ints without allocating
+	b.Run("mapToUpdate/generated", func(b *testing.B) {
+		useGeneratedAccessors(b)
+		col := newOfflineCollection(b)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			update, err := col.mapToUpdate(doc)
+			if err != nil {
+				b.Fatal(err)
+			}
+			updateSink = update
+		}
+	})
+}
+
+func Test_StrictTags(t *testing.T) {
+	entity.StrictTags(true)
+	defer entity.StrictTags(false)
+
+	_, err := entity.GetOrParse(&Test{})
+	if err == nil || !strings.Contains(err.Error(), "field UserPassword on Test has no bson tag") {
+		t.Fatalf("expect the untagged field to be rejected, got %v", err)
+	}
+
+	entity.StrictTags(false)
+	if _, err := entity.GetOrParse(&Test{}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+}
+
+func Test_ChangedSince(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Event, SObjectId](&Event{}, c.Database("test"))
+	ctx := context.Background()
+
+	code := string(NewSObjectId())
+	watermark := time.Now().UTC().Truncate(time.Millisecond)
+	for i, offset := range []time.Duration{2 * time.Second, -time.Second, time.Second} {
+		at := watermark.Add(offset)
+		col.now = func() time.Time {
+			return at
+		}
+		if err := col.InsertOne(ctx, &Event{Id: NewSObjectId(), Code: code, Name: fmt.Sprint(i)}); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+
+	changed, err := col.ChangedSince(ctx, "UpdatedAt", watermark)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var names []string
+	for _, event := range changed {
+		if event.Code == code {
+			names = append(names, event.Name)
+		}
+	}
+	if !reflect.DeepEqual(names, []string{"2", "0"}) {
+		t.Errorf("expect the events after the watermark in ascending order, got %v", names)
+	}
+}
+
+func Test_ChangedSince_NotTime(t *testing.T) {
+	col := NewCollection[*Event, SObjectId](&Event{}, newOfflineDatabase(t))
+	if _, err := col.ChangedSince(context.Background(), "Name", time.Now()); err == nil {
+		t.Error("expect error for a field which is not a time")
+	}
+}
+
+func Test_LiveFilter(t *testing.T) {
+	col := NewCollection[*Member, SObjectId](&Member{}, newOfflineDatabase(t))
+
+	if filter := col.liveFilter(bson.M{}, false); !reflect.DeepEqual(filter, bson.M{"deletedAt": nil}) {
+		t.Errorf("expect only the live condition, got %v", filter)
+	}
+	expect := bson.M{"$and": bson.A{bson.M{"email": "a"}, bson.M{"deletedAt": nil}}}
+	if filter := col.liveFilter(bson.M{"email": "a"}, false); !reflect.DeepEqual(filter, expect) {
+		t.Errorf("expect %v, got %v", expect, filter)
+	}
+	if filter := col.liveFilter(bson.M{"email": "a"}, includeDeleted(Merge([]*FindOption{Option().Limit(1), Option().IncludeDeleted()}))); !reflect.DeepEqual(filter, bson.M{"email": "a"}) {
+		t.Errorf("expect IncludeDeleted to keep the filter, got %v", filter)
+	}
+	deleted := bson.D{{Key: "deletedAt", Value: bson.M{"$ne": nil}}}
+	if filter := col.liveFilter(deleted, false); !reflect.DeepEqual(filter, deleted) {
+		t.Errorf("expect the condition on the field to be kept, got %v", filter)
+	}
+
+	if filter := newOfflineCollection(t).liveFilter(bson.M{}, false); !reflect.DeepEqual(filter, bson.M{}) {
+		t.Errorf("expect no condition without soft delete, got %v", filter)
+	}
+}
+
+func Test_Find_IncludeDeleted(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Member, SObjectId](&Member{}, c.Database("test"))
+	ctx := context.Background()
+
+	phone := string(NewSObjectId())
+	deletedAt := time.Now()
+	for _, member := range []*Member{
+		{Id: NewSObjectId(), Email: phone + "-live", Phone: phone},
+		{Id: NewSObjectId(), Email: phone + "-deleted", Phone: phone + "-deleted", DeletedAt: &deletedAt},
+	} {
+		if err := col.InsertOne(ctx, member); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+
+	filter := bson.M{"email": bson.M{"$regex": "^" + phone}}
+	live, err := col.Find(ctx, filter)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(live) != 1 || live[0].DeletedAt != nil {
+		t.Errorf("expect only the live member, got %+v", live)
+	}
+
+	all, err := col.Find(ctx, filter, Option().IncludeDeleted())
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(all) != 2 {
+		t.Errorf("expect the deleted member too, got %+v", all)
+	}
+
+	if count, err := col.Count(ctx, filter); err != nil || count != 1 {
+		t.Errorf("expect 1 live member counted, got %d, %v", count, err)
+	}
+	if count, err := col.Count(ctx, filter, Option().IncludeDeleted()); err != nil || count != 2 {
+		t.Errorf("expect 2 members counted with IncludeDeleted, got %d, %v", count, err)
+	}
+	if phones, err := col.Distinct(ctx, "Phone", filter); err != nil || len(phones) != 1 {
+		t.Errorf("expect the phone of the live member only, got %v, %v", phones, err)
+	}
+
+	matched, _, err := col.UpdateOneWith(ctx, bson.M{"email": phone + "-deleted"}, Update().Set("Phone", phone))
+	if err != nil || matched != 0 {
+		t.Errorf("expect the deleted member not updated, got %d, %v", matched, err)
+	}
+	restore := bson.M{"email": phone + "-deleted", "deletedAt": bson.M{"$ne": nil}}
+	matched, _, err = col.UpdateOneWith(ctx, restore, Update().Set("DeletedAt", nil))
+	if err != nil || matched != 1 {
+		t.Errorf("expect the deleted member restored by a condition on the field, got %d, %v", matched, err)
+	}
+
+	if _, _, err := col.UpdateOneWith(ctx, bson.M{"email": phone + "-deleted"}, Update().Set("DeletedAt", deletedAt)); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, ok, err := col.FindOneAndDelete(ctx, bson.M{"email": phone + "-deleted"}); err != nil || ok {
+		t.Errorf("expect the deleted member not returned by FindOneAndDelete, got %v, %v", ok, err)
+	}
+}
+
+func Test_IdChunks(t *testing.T) {
+	col := newOfflineCollection(t).IdChunkSize(2)
+
+	var ids []SObjectId
+	for i := 0; i < 5; i++ {
+		ids = append(ids, NewSObjectId())
+	}
+	chunks, err := col.idChunks(append(ids, ids[0]))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := [][]SObjectId{ids[0:2], ids[2:4], ids[4:5]}
+	if !reflect.DeepEqual(chunks, expect) {
+		t.Errorf("expect %v, got %v", expect, chunks)
+	}
+}
+
+func Test_FindByIDs_Chunks(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Test, SObjectId](&Test{}, c.Database("test")).IdChunkSize(2)
+	ctx := context.Background()
+
+	var ids []SObjectId
+	for i := 0; i < 5; i++ {
+		doc := &Test{Id: NewSObjectId(), Name: fmt.Sprint(i)}
+		if err := col.InsertOne(ctx, doc); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		ids = append(ids, doc.Id)
+	}
+
+	found, err := col.FindByIDs(ctx, ids)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	seen := map[SObjectId]int{}
+	for _, doc := range found {
+		seen[doc.Id]++
+	}
+	for _, id := range ids {
+		if seen[id] != 1 {
+			t.Errorf("expect %s once, got %d", id, seen[id])
+		}
+	}
+
+	ordered, err := col.FindByIDsOrdered(ctx, []SObjectId{ids[4], ids[0], ids[3], ids[1], ids[2]})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var names []string
+	for _, doc := range ordered {
+		names = append(names, doc.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"4", "0", "3", "1", "2"}) {
+		t.Errorf("expect the order of the ids across chunks, got %v", names)
+	}
+}
+
+func Test_UpdateOne_Counts(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Test, SObjectId](&Test{}, c.Database("test"))
+	ctx := context.Background()
+
+	doc := &Test{Id: NewSObjectId(), Name: "counts", Age: 3}
+	if err := col.InsertOne(ctx, doc); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	matched, modified, err := col.UpdateOne(ctx, bson.M{"_id": doc.Id}, &Test{Age: 4})
+	if err != nil || matched != 1 || modified != 1 {
+		t.Errorf("expect 1 matched and modified, got %d, %d, %v", matched, modified, err)
+	}
+	matched, modified, err = col.UpdateOne(ctx, bson.M{"_id": doc.Id}, &Test{Age: 4})
+	if err != nil || matched != 1 || modified != 0 {
+		t.Errorf("expect 1 matched and none modified, got %d, %d, %v", matched, modified, err)
+	}
+	matched, _, err = col.UpdateOne(ctx, bson.M{"_id": NewSObjectId()}, &Test{Age: 4})
+	if err != nil || matched != 0 {
+		t.Errorf("expect nothing matched, got %d, %v", matched, err)
+	}
+
+	_, modified, err = col.UpdateOne(ctx, bson.M{"_id": doc.Id}, &Test{Name: "counts"}, SetZeroFields())
+	if err != nil || modified != 1 {
+		t.Fatalf("expect the zero age set, got %d, %v", modified, err)
+	}
+	found, err := col.FindOneById(ctx, doc.Id)
+	if err != nil || found.Age != 0 || found.Name != "counts" {
+		t.Errorf("expect the age set to 0, got %+v, %v", found, err)
+	}
+}
+
+func Test_UpdateOf(t *testing.T) {
+	col := newOfflineCollection(t)
+	doc := &Test{Id: NewSObjectId(), Name: "abc"}
+
+	for _, c := range []struct {
+		opts   []*UpdateOption
+		hasAge bool
+	}{
+		{nil, false},
+		{[]*UpdateOption{DriverUpdateOptions(options.Update().SetUpsert(true))}, false},
+		{[]*UpdateOption{SetZeroFields(), nil}, true},
+	} {
+		makeUpdate, _ := col.updateOf(c.opts)
+		update, err := makeUpdate(doc)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		set := update["$set"].(bson.M)
+		if _, ok := set["happy"]; ok != c.hasAge {
+			t.Errorf("expect the zero age set %v, got %v", c.hasAge, set)
+		}
+	}
+
+	_, updateOpts := col.updateOf([]*UpdateOption{SetZeroFields(), DriverUpdateOptions(options.Update().SetUpsert(true))})
+	if merged := options.MergeUpdateOptions(updateOpts...); merged.Upsert == nil || !*merged.Upsert {
+		t.Errorf("expect the driver options kept, got %+v", merged)
+	}
+
+	// the id is written only by an insertion
+	makeUpdate, updateOpts := col.updateOf([]*UpdateOption{Option().Upsert(true)})
+	update, err := makeUpdate(doc)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := bson.M{"$set": bson.M{"name": "abc"}, "$setOnInsert": bson.M{"_id": doc.Id}}
+	if !reflect.DeepEqual(update, expect) {
+		t.Errorf("expect %v, got %v", expect, update)
+	}
+	if merged := options.MergeUpdateOptions(updateOpts...); merged.Upsert == nil || !*merged.Upsert {
+		t.Errorf("expect the upsert option, got %+v", merged)
+	}
+}
+
+func Test_DeleteQuery(t *testing.T) {
+	col := newOfflineCollection(t)
+
+	for _, filter := range []any{nil, TestFilter{}, bson.M{}} {
+		if _, err := col.deleteQuery(filter, false); !errors.Is(err, errortype.ErrEmptyFilter) {
+			t.Errorf("expect ErrEmptyFilter for %v, got %v", filter, err)
+		}
+	}
+	if query, err := col.deleteQuery(TestFilter{}, true); err != nil || !reflect.DeepEqual(query, bson.M{}) {
+		t.Errorf("expect the empty filter to be allowed, got %v, %v", query, err)
+	}
+
+	id := primitive.NewObjectID()
+	if query, err := col.deleteQuery(id, false); err != nil || !reflect.DeepEqual(query, bson.M{"_id": id}) {
+		t.Errorf("expect the id as a condition, got %v, %v", query, err)
+	}
+	if _, count, err := col.convertFilter(id); err != nil || count != 1 {
+		t.Errorf("expect the id counted as a condition, got %d, %v", count, err)
+	}
+}
+
+func Test_DeleteMany(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Test, SObjectId](&Test{}, c.Database("test"))
+	ctx := context.Background()
+
+	name := string(NewSObjectId())
+	var ids []SObjectId
+	for i := 0; i < 3; i++ {
+		doc := &Test{Id: NewSObjectId(), Name: name}
+		if err := col.InsertOne(ctx, doc); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		ids = append(ids, doc.Id)
+	}
+
+	if deleted, err := col.DeleteOne(ctx, ids[0]); err != nil || !deleted {
+		t.Errorf("expect the document of the id deleted, got %v, %v", deleted, err)
+	}
+	if count, err := col.DeleteMany(ctx, bson.M{"name": name}); err != nil || count != 2 {
+		t.Errorf("expect 2 deleted, got %d, %v", count, err)
+	}
+	if _, err := col.DeleteMany(ctx, TestFilter{}); !errors.Is(err, errortype.ErrEmptyFilter) {
+		t.Errorf("expect ErrEmptyFilter, got %v", err)
+	}
+}
+
+func Test_EstimatedCount(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Test, SObjectId](&Test{}, c.Database("test"))
+	ctx := context.Background()
+
+	if err := col.InsertOne(ctx, &Test{Id: NewSObjectId(), Name: "estimated"}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	estimated, err := col.EstimatedCount(ctx)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	all, err := col.ExactCount().Count(ctx, TestFilter{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if estimated < 1 || all < 1 {
+		t.Errorf("expect the documents counted, got %d and %d", estimated, all)
+	}
+
+	window, err := col.Count(ctx, TestFilter{}, Option().Offset(1).Limit(1))
+	if err != nil || window > 1 {
+		t.Errorf("expect at most 1 document in the window, got %d, %v", window, err)
+	}
+}
+
+type Ticket struct {
+	Id        primitive.ObjectID `bson:"_id,omitempty"`
+	Title     string             `bson:"title"`
+	CreatedAt time.Time          `bson:"createdAt"`
+}
+
+type Voucher struct {
+	Id   *primitive.ObjectID `bson:"_id,omitempty"`
+	Code string              `bson:"code"`
+}
+
+func Test_FillInsertedID(t *testing.T) {
+	oid := primitive.NewObjectID()
+
+	test := &Test{}
+	newOfflineCollection(t).fillInsertedID(test, oid)
+	if test.Id != SObjectId(oid.Hex()) {
+		t.Errorf("expect the SObjectId %s, got %s", oid.Hex(), test.Id)
+	}
+
+	ticket := &Ticket{}
+	NewCollection[*Ticket, primitive.ObjectID](&Ticket{}, newOfflineDatabase(t)).fillInsertedID(ticket, oid)
+	if ticket.Id != oid {
+		t.Errorf("expect the ObjectID %s, got %s", oid.Hex(), ticket.Id.Hex())
+	}
+
+	voucher := &Voucher{}
+	NewCollection[*Voucher, primitive.ObjectID](&Voucher{}, newOfflineDatabase(t)).fillInsertedID(voucher, oid)
+	if voucher.Id == nil || *voucher.Id != oid {
+		t.Errorf("expect a pointer to %s, got %v", oid.Hex(), voucher.Id)
+	}
+
+	provided := NewSObjectId()
+	test = &Test{Id: provided}
+	newOfflineCollection(t).fillInsertedID(test, oid)
+	if test.Id != provided {
+		t.Errorf("expect the provided id %s to be kept, got %s", provided, test.Id)
+	}
+}
+
+func Test_InsertOne_GeneratedID(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Ticket, primitive.ObjectID](&Ticket{}, c.Database("test"))
+	ctx := context.Background()
+
+	ticket := &Ticket{Title: "generated"}
+	if err := col.InsertOne(ctx, ticket); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if ticket.Id.IsZero() {
+		t.Fatal("expect the generated id to be written back")
+	}
+
+	found, err := col.FindOneById(ctx, ticket.Id)
+	if err != nil || found.Title != "generated" {
+		t.Errorf("expect the document by the written id, got %+v %v", found, err)
+	}
+}
+
+// Receipt records the id given to its AfterSave hook
+type Receipt struct {
+	Id      primitive.ObjectID `bson:"_id,omitempty"`
+	Title   string             `bson:"title"`
+	savedID any
+}
+
+func (r *Receipt) AfterSave(id any) {
+	r.savedID = id
+}
+
+func Test_WithInsertedID(t *testing.T) {
+	raw, id, err := withInsertedID(&Receipt{Title: "generated"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	oid, ok := id.(primitive.ObjectID)
+	if !ok || oid.IsZero() {
+		t.Fatalf("expect a generated ObjectID, got %v", id)
+	}
+	elements, err := raw.Elements()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(elements) != 2 || elements[0].Key() != "_id" || elements[0].Value().ObjectID() != oid || raw.Lookup("title").StringValue() != "generated" {
+		t.Errorf("expect the generated id first, got %s", raw)
+	}
+
+	provided := primitive.NewObjectID()
+	raw, id, err = withInsertedID(&Receipt{Id: provided})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if id != provided || raw.Lookup("_id").ObjectID() != provided {
+		t.Errorf("expect the provided id %s, got %v in %s", provided.Hex(), id, raw)
+	}
+}
+
+func Test_BulkWrite_GeneratedID(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Receipt, primitive.ObjectID](&Receipt{}, c.Database("test"))
+	ctx := context.Background()
+
+	receipt := &Receipt{Title: "bulk"}
+	if _, err := col.BulkWrite(ctx, []mongo.WriteModel{mongo.NewInsertOneModel().SetDocument(receipt)}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if receipt.Id.IsZero() || receipt.savedID != receipt.Id {
+		t.Fatalf("expect the generated id written back and given to AfterSave, got %s %v", receipt.Id.Hex(), receipt.savedID)
+	}
+
+	found, err := col.FindOneById(ctx, receipt.Id)
+	if err != nil || found.Title != "bulk" {
+		t.Errorf("expect the document by the written id, got %+v %v", found, err)
+	}
+}
+
+type Coupon struct {
+	Code  string `bson:"_id"`
+	Value int    `bson:"value"`
+}
+
+func Test_ObjectIdOf(t *testing.T) {
+	col := newOfflineCollection(t)
+	oid := primitive.NewObjectID()
+
+	for _, id := range []any{oid.Hex(), SObjectId(oid.Hex()), oid, &oid} {
+		parsed, err := col.objectIdOf(id)
+		if err != nil || parsed != oid {
+			t.Errorf("expect %T to be the ObjectID %s, got %v %v", id, oid.Hex(), parsed, err)
+		}
+	}
+
+	if _, err := col.objectIdOf("not-hex"); !errors.Is(err, errortype.ErrInvalidObjectId) {
+		t.Errorf("expect ErrInvalidObjectId, got %v", err)
+	}
+
+	coupons := NewCollection[*Coupon, string](&Coupon{}, newOfflineDatabase(t))
+	if parsed, err := coupons.objectIdOf("SPRING"); err != nil || parsed != "SPRING" {
+		t.Errorf("expect a string id to be kept, got %v %v", parsed, err)
+	}
+}
+
+func Test_FindByID(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Test, SObjectId](&Test{}, c.Database("test"))
+	ctx := context.Background()
+
+	test := &Test{Name: "by id"}
+	if err := col.InsertOne(ctx, test); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	for _, id := range []any{string(test.Id), test.Id} {
+		found, ok, err := col.FindByID(ctx, id)
+		if err != nil || !ok || found.Name != "by id" {
+			t.Errorf("expect the document by %T, got %+v %v %v", id, found, ok, err)
+		}
+	}
+
+	_, ok, err := col.FindByID(ctx, primitive.NewObjectID())
+	if err != nil || ok {
+		t.Errorf("expect no document, got %v %v", ok, err)
+	}
+
+	if _, _, err := col.FindByID(ctx, "not-hex"); !errors.Is(err, errortype.ErrInvalidObjectId) {
+		t.Errorf("expect ErrInvalidObjectId, got %v", err)
+	}
+}
+
+type Meta struct {
+	Tag string `bson:"tag"`
+}
+
+type Note struct {
+	Meta `bson:",inline"`
+	Id   SObjectId `bson:"_id,omitempty"`
+	Text string    `bson:"text"`
+}
+
+func Test_Inline_Query(t *testing.T) {
+	col := NewCollection[*Note, SObjectId](&Note{}, newOfflineDatabase(t))
+
+	query, _, err := col.convertFilter(Cond().Eq("Tag", "go"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(query, bson.M{"tag": "go"}) {
+		t.Errorf("expect tag at the top level, got %v", query)
+	}
+
+	// the inlined fields are stored at the top level like the driver does
+	document := col.schema.AppendDocument(nil, reflect.ValueOf(&Note{Meta: Meta{Tag: "go"}, Text: "x"}), true)
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect, _ := bson.Marshal(&Note{Meta: Meta{Tag: "go"}, Text: "x"})
+	if !bytes.Equal(raw, expect) {
+		t.Errorf("expect %v, got %v", bson.Raw(expect), bson.Raw(raw))
+	}
+}
+
+func Test_Inline_Find(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Note, SObjectId](&Note{}, c.Database("test"))
+	ctx := context.Background()
+
+	note := &Note{Meta: Meta{Tag: NewSObjectId().ToString()}, Text: "inline"}
+	if err := col.InsertOne(ctx, note); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	found, err := col.Find(ctx, Cond().Eq("Tag", note.Tag))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(found) != 1 || found[0].Text != "inline" || found[0].Tag != note.Tag {
+		t.Errorf("expect the note by the inlined tag, got %+v", found)
+	}
+}
+
+func Test_FindInto_Dest(t *testing.T) {
+	col := newOfflineCollection(t)
+	for _, dest := range []any{nil, []*Test{}, &[]*Coupon{}, new(int)} {
+		if err := col.FindInto(context.Background(), bson.M{}, dest); !errors.Is(err, errortype.ErrModelTypeNotMatchInCollection) {
+			t.Errorf("expect %T to be rejected, got %v", dest, err)
+		}
+	}
+}
+
+func Test_FindInto(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Test, SObjectId](&Test{}, c.Database("test"))
+	ctx := context.Background()
+
+	name := NewSObjectId().ToString()
+	if _, err := col.InsertMany(ctx, []*Test{{Name: name, Age: 3}, {Name: name, Age: 1}, {Name: name, Age: 2}}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	var pointers []*Test
+	if err := col.FindInto(ctx, bson.M{"name": name}, &pointers, Option().Offset(0).Limit(2).AddOrder("Age", true)); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(pointers) != 2 || pointers[0].Age != 1 || pointers[1].Age != 2 {
+		t.Errorf("unexpected documents %+v", pointers)
+	}
+
+	var values []Test
+	if err := col.FindInto(ctx, bson.M{"name": name}, &values, Option().AddOrder("Age", false)); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(values) != 3 || values[0].Age != 3 {
+		t.Errorf("unexpected documents %+v", values)
+	}
+}
+
+func Test_UpdateOne_Upsert(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Ticket, primitive.ObjectID](&Ticket{}, c.Database("test"))
+	ctx := context.Background()
+
+	title := NewSObjectId().ToString()
+	ticket := &Ticket{Title: title}
+	matched, _, err := col.UpdateOne(ctx, bson.M{"title": title}, ticket, Option().Upsert(true))
+	if err != nil || matched != 0 {
+		t.Fatalf("expect an insertion, got %d %+v", matched, err)
+	}
+	if ticket.Id.IsZero() {
+		t.Fatal("expect the upserted id written back")
+	}
+
+	found, err := col.FindOneById(ctx, ticket.Id)
+	if err != nil || found == nil || found.Title != title || found.CreatedAt.IsZero() {
+		t.Fatalf("expect the upserted document with its create time, got %+v %v", found, err)
+	}
+
+	update := &Ticket{Title: title + "!"}
+	matched, modified, err := col.UpdateOne(ctx, bson.M{"_id": found.Id}, update, Option().Upsert(true))
+	if err != nil || matched != 1 || modified != 1 || !update.Id.IsZero() {
+		t.Errorf("expect an update without upserted id, got %d %d %s %v", matched, modified, update.Id.Hex(), err)
+	}
+	updated, err := col.FindOneById(ctx, found.Id)
+	if err != nil || updated.Title != title+"!" || !updated.CreatedAt.Equal(found.CreatedAt) {
+		t.Errorf("expect the create time to be kept by the update, got %+v %v", updated, err)
+	}
+}
+
+func Test_MapToUpsert(t *testing.T) {
+	col := NewCollection[*Ticket, primitive.ObjectID](&Ticket{}, newOfflineDatabase(t))
+	now := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+	col.now = func() time.Time {
+		return now
+	}
+
+	id := primitive.NewObjectID()
+	update, err := col.mapToUpsert(&Ticket{Id: id, Title: "a"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := bson.M{
+		"$set":         bson.M{"title": "a"},
+		"$setOnInsert": bson.M{"_id": id, "createdAt": now},
+	}
+	if !reflect.DeepEqual(update, expect) {
+		t.Errorf("expect %v, got %v", expect, update)
+	}
+}
+
 //	func Test_Raw_InsertTransaction(t *testing.T) {
 //		c := setupMongoClient(MongoUrl)
 //
@@ -137,9 +2023,9 @@ func Test_Bulk(t *testing.T) {
 // }
 func Test_Raw_Read(t *testing.T) {
 
-	c := setupMongoClient(MongoUrl)
+	c := setupMongoClient(t, MongoUrl)
 	db := c.Database("test")
-	col := NewCollection[*Test](&Test{}, db)
+	col := NewCollection[*Test, SObjectId](&Test{}, db)
 	ctx := context.Background()
 
 	models, err := col.FindOneByFilter(ctx, TestFilter{})
@@ -279,15 +2165,16 @@ func Test_Raw_Read(t *testing.T) {
 // //
 // //    fmt.Println(test)
 // //}
-func setupMongoClient(mongoUrl string) *Client {
+// mongo servers which can not be reached, tests using them are skipped
+var unreachableMongo = map[string]error{}
 
-	monitorOptions := options.Client().SetMonitor(&event.CommandMonitor{
-		Started: func(i context.Context, startedEvent *event.CommandStartedEvent) {
-			fmt.Println("mongo command" + startedEvent.Command.String())
-		},
-	})
+func setupMongoClient(t *testing.T, mongoUrl string) *Client {
+
+	if err, ok := unreachableMongo[mongoUrl]; ok {
+		t.Skipf("mongodb is unreachable: %v", err)
+	}
 
-	client, err := NewClient(options.Client().ApplyURI(mongoUrl), monitorOptions)
+	client, err := NewClient(options.Client().ApplyURI(mongoUrl).SetServerSelectionTimeout(3 * time.Second))
 	if err != nil {
 		panic(err)
 	}
@@ -300,14 +2187,29 @@ func setupMongoClient(mongoUrl string) *Client {
 		panic(err)
 	}
 
-	err = client.Ping(context.TODO(), nil)
+	err = client.Ping(ctx, nil)
 	if err != nil {
-		panic(err)
+		unreachableMongo[mongoUrl] = err
+		t.Skipf("mongodb is unreachable: %v", err)
 	}
 
 	return client
 }
 
+// newOfflineCollection create a collection on a client that never connects,
+// used by tests which only check the commands jmongo builds
+func newOfflineCollection(t testing.TB) *Collection[*Test, SObjectId] {
+	return NewCollection[*Test, SObjectId](&Test{}, newOfflineDatabase(t))
+}
+
+func newOfflineDatabase(t testing.TB) *Database {
+	client, err := NewClient(options.Client().ApplyURI("mongodb://localhost:27017"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client.Database("test")
+}
+
 //
 //type User struct {
 //}
@@ -0,0 +1,277 @@
+package jmongo
+
+import (
+	"fmt"
+	"github.com/JackWSK/jmongo/entity"
+	"github.com/JackWSK/jmongo/errortype"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"reflect"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Condition build a filter by fields, field can be model field name or db name,
+// they are resolved by the entity of the collection when the condition is used as a filter
+type Condition struct {
+	items []conditionItem
+}
+
+// operator of items resolved to an aggregation expression instead of a field condition
+const exprOperator = "$expr"
+
+type conditionItem struct {
+	field    string
+	operator string
+	value    any
+	// make operator and value from the resolved field, used instead of operator and value when set
+	resolve func(field *entity.EntityField) (string, any, error)
+}
+
+// Cond create a condition, it can be used as filter of any query
+func Cond() *Condition {
+	return &Condition{}
+}
+
+// Eq field equals value.
+// When value is a slice the array field must be exactly the same array, same elements in the same order,
+// use Contains to match an element of the array
+func (th *Condition) Eq(field string, value any) *Condition {
+	return th.add(field, "", value)
+}
+
+// Contains for an array field, the array contains value, e.g. {tags: "x"} matches documents whose tags have "x";
+// for a string field, the string contains value literally
+func (th *Condition) Contains(field string, value any) *Condition {
+	return th.addResolver(field, func(schemaField *entity.EntityField) (string, any, error) {
+		switch schemaField.FieldType.Kind() {
+		case reflect.Slice, reflect.Array:
+			return "", value, nil
+		case reflect.String:
+			s, ok := value.(string)
+			if !ok {
+				return "", nil, errors.WithStack(fmt.Errorf("field %s contains only accept string", schemaField.Name))
+			}
+			return "", literalRegex("", s, ""), nil
+		}
+		return "", nil, errors.WithStack(fmt.Errorf("field %s is neither an array nor a string", schemaField.Name))
+	})
+}
+
+// StartsWith the string field starts with s, regex metacharacters in s are matched literally
+func (th *Condition) StartsWith(field string, s string) *Condition {
+	return th.add(field, "", literalRegex("^", s, ""))
+}
+
+// EndsWith the string field ends with s, regex metacharacters in s are matched literally
+func (th *Condition) EndsWith(field string, s string) *Condition {
+	return th.add(field, "", literalRegex("", s, "$"))
+}
+
+// In the field equals any of values
+func (th *Condition) In(field string, values any) *Condition {
+	return th.add(field, "$in", values)
+}
+
+// Mod the integer field divided by divisor has the remainder, e.g. {shard: {$mod: [16, 3]}}
+func (th *Condition) Mod(field string, divisor int, remainder int) *Condition {
+	return th.addResolver(field, func(schemaField *entity.EntityField) (string, any, error) {
+		if divisor == 0 {
+			return "", nil, errors.WithStack(fmt.Errorf("divisor of field %s can not be 0", schemaField.Name))
+		}
+		switch schemaField.FieldType.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return "$mod", bson.A{divisor, remainder}, nil
+		}
+		return "", nil, errors.WithStack(fmt.Errorf("field %s is not an integer", schemaField.Name))
+	})
+}
+
+// FromMap add an equality for every entry of m, e.g. the query string of a request.
+// Keys must be fields of schema, model field name or db name, values are converted to the type of the field,
+// so the caller can not inject arbitrary keys or operators
+func (th *Condition) FromMap(schema *entity.Entity, m map[string]string) (*Condition, error) {
+	for key, s := range m {
+		field, err := schema.MustLookUpField(key)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := parseFieldValue(field, s)
+		if err != nil {
+			return nil, err
+		}
+		th.add(field.DBName, "", value)
+	}
+	return th, nil
+}
+
+// parseFieldValue convert s to the type of field
+func parseFieldValue(field *entity.EntityField, s string) (any, error) {
+	fieldType := field.FieldType
+	for fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+	value := reflect.New(fieldType).Elem()
+
+	var err error
+	switch {
+	case fieldType == reflect.TypeOf(SObjectId("")):
+		if _, err = primitive.ObjectIDFromHex(s); err == nil {
+			value.SetString(s)
+		}
+	case fieldType == reflect.TypeOf(primitive.ObjectID{}):
+		var id primitive.ObjectID
+		if id, err = primitive.ObjectIDFromHex(s); err == nil {
+			value.Set(reflect.ValueOf(id))
+		}
+	case fieldType == reflect.TypeOf(time.Time{}):
+		var t time.Time
+		if t, err = time.Parse(time.RFC3339, s); err == nil {
+			value.Set(reflect.ValueOf(t))
+		}
+	default:
+		switch fieldType.Kind() {
+		case reflect.String:
+			value.SetString(s)
+		case reflect.Bool:
+			var b bool
+			if b, err = strconv.ParseBool(s); err == nil {
+				value.SetBool(b)
+			}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			var i int64
+			if i, err = strconv.ParseInt(s, 10, fieldType.Bits()); err == nil {
+				value.SetInt(i)
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			var u uint64
+			if u, err = strconv.ParseUint(s, 10, fieldType.Bits()); err == nil {
+				value.SetUint(u)
+			}
+		case reflect.Float32, reflect.Float64:
+			var f float64
+			if f, err = strconv.ParseFloat(s, fieldType.Bits()); err == nil {
+				value.SetFloat(f)
+			}
+		default:
+			return nil, errors.WithStack(fmt.Errorf("%w: field %s of type %s", errortype.ErrUnsupportedDataType, field.Name, fieldType))
+		}
+	}
+
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("invalid value %q of field %s: %v", s, field.Name, err))
+	}
+	return value.Interface(), nil
+}
+
+// ArraySizeGt the array field has more than n elements
+func (th *Condition) ArraySizeGt(field string, n int) *Condition {
+	return th.arraySize(field, "$gt", n)
+}
+
+// ArraySizeEq the array field has n elements
+func (th *Condition) ArraySizeEq(field string, n int) *Condition {
+	return th.arraySize(field, "$eq", n)
+}
+
+// ArraySizeLt the array field has less than n elements
+func (th *Condition) ArraySizeLt(field string, n int) *Condition {
+	return th.arraySize(field, "$lt", n)
+}
+
+// compare the size of the array field with n by $expr, a missing field counts as an empty array
+func (th *Condition) arraySize(field string, operator string, n int) *Condition {
+	return th.addResolver(field, func(schemaField *entity.EntityField) (string, any, error) {
+		kind := schemaField.FieldType.Kind()
+		if kind != reflect.Slice && kind != reflect.Array {
+			return "", nil, errors.WithStack(fmt.Errorf("field %s is not an array", schemaField.Name))
+		}
+		size := bson.M{"$size": bson.M{"$ifNull": bson.A{"$" + schemaField.DBName, bson.A{}}}}
+		return exprOperator, bson.M{operator: bson.A{size, n}}, nil
+	})
+}
+
+func (th *Condition) add(field string, operator string, value any) *Condition {
+	th.items = append(th.items, conditionItem{field: field, operator: operator, value: value})
+	return th
+}
+
+func (th *Condition) addResolver(field string, resolve func(field *entity.EntityField) (string, any, error)) *Condition {
+	th.items = append(th.items, conditionItem{field: field, resolve: resolve})
+	return th
+}
+
+func (th *Condition) toQuery(schema *entity.Entity) (bson.M, error) {
+	query := bson.M{}
+	// aggregation expressions, they are put into the top level $expr
+	var exprs bson.A
+	for _, item := range th.items {
+		field, err := schema.MustLookUpField(item.field)
+		if err != nil {
+			return nil, err
+		}
+
+		operator, value := item.operator, item.value
+		if item.resolve != nil {
+			operator, value, err = item.resolve(field)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if operator == exprOperator {
+			exprs = append(exprs, value)
+			continue
+		}
+		putOperator(query, field.DBName, operator, value)
+	}
+
+	switch len(exprs) {
+	case 0:
+	case 1:
+		query[exprOperator] = exprs[0]
+	default:
+		query[exprOperator] = bson.M{"$and": exprs}
+	}
+	return query, nil
+}
+
+// put {key: {operator: value}} into query, operators of the same key are merged,
+// an operator already on the key is combined by $and, e.g. two Contains of an array field both match,
+// empty operator means equality
+func putOperator(query bson.M, key string, operator string, value any) {
+	existing, exists := query[key]
+	if !exists {
+		if operator == "" {
+			query[key] = value
+		} else {
+			query[key] = bson.M{operator: value}
+		}
+		return
+	}
+
+	operators, ok := existing.(bson.M)
+	if !ok {
+		// equality is kept as $eq besides the other operators
+		operators = bson.M{"$eq": existing}
+	}
+	if operator == "" {
+		operator = "$eq"
+	}
+	if _, repeated := operators[operator]; repeated {
+		and, _ := query["$and"].(bson.A)
+		query["$and"] = append(and, bson.M{key: bson.M{operator: value}})
+		return
+	}
+	operators[operator] = value
+	query[key] = operators
+}
+
+// regex matching s literally, surrounded by the anchors
+func literalRegex(prefix string, s string, suffix string) primitive.Regex {
+	return primitive.Regex{Pattern: prefix + regexp.QuoteMeta(s) + suffix}
+}
@@ -0,0 +1,365 @@
+package jmongo
+
+import (
+	"github.com/JackWSK/jmongo/extype"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+type Article struct {
+	Id     SObjectId `bson:"_id,omitempty"`
+	Title  string    `bson:"title"`
+	Tags   []string  `bson:"tags"`
+	Status string    `bson:"status"`
+}
+
+func newArticleCollection(t *testing.T) *Collection[*Article, SObjectId] {
+	return NewCollection[*Article, SObjectId](&Article{}, newOfflineDatabase(t))
+}
+
+func Test_Cond_ContainsAndExact(t *testing.T) {
+	col := newArticleCollection(t)
+
+	// an element of the array
+	query, _, err := col.convertFilter(Cond().Contains("Tags", "x"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(query, bson.M{"tags": "x"}) {
+		t.Errorf("unexpected contains query %v", query)
+	}
+
+	// the whole array
+	query, _, err = col.convertFilter(Cond().Eq("tags", []string{"x", "y"}))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(query, bson.M{"tags": []string{"x", "y"}}) {
+		t.Errorf("unexpected exact query %v", query)
+	}
+
+	// both elements, the second equality does not replace the first
+	query, _, err = col.convertFilter(Cond().Contains("Tags", "x").Contains("Tags", "y"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := bson.M{"tags": "x", "$and": bson.A{bson.M{"tags": bson.M{"$eq": "y"}}}}
+	if !reflect.DeepEqual(query, expect) {
+		t.Errorf("expect %v, got %v", expect, query)
+	}
+
+	if _, _, err := col.convertFilter(Cond().Contains("Title", 1)); err == nil {
+		t.Error("expect error for contains a non string on a string field")
+	}
+	if _, _, err := col.convertFilter(Cond().Eq("Unknown", "x")); err == nil {
+		t.Error("expect error for unknown field")
+	}
+}
+
+func Test_Cond_Regex(t *testing.T) {
+	col := newArticleCollection(t)
+
+	cases := []struct {
+		cond   *Condition
+		expect primitive.Regex
+		match  string
+		miss   string
+	}{
+		{Cond().StartsWith("Title", "a.b"), primitive.Regex{Pattern: `^a\.b`}, "a.bc", "axbc"},
+		{Cond().EndsWith("Title", "(x)*"), primitive.Regex{Pattern: `\(x\)\*$`}, "y(x)*", "yxx"},
+		{Cond().Contains("Title", "1+1?"), primitive.Regex{Pattern: `1\+1\?`}, "is 1+1?", "is 11"},
+	}
+
+	for _, c := range cases {
+		query, _, err := col.convertFilter(c.cond)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if !reflect.DeepEqual(query, bson.M{"title": c.expect}) {
+			t.Errorf("expect %v, got %v", c.expect, query)
+			continue
+		}
+
+		// the metacharacters of the built pattern only match themselves
+		pattern := regexp.MustCompile(query.(bson.M)["title"].(primitive.Regex).Pattern)
+		if !pattern.MatchString(c.match) || pattern.MatchString(c.miss) {
+			t.Errorf("expect %s to match %q but not %q", pattern, c.match, c.miss)
+		}
+	}
+}
+
+func Test_Cond_MergeOperators(t *testing.T) {
+	col := newArticleCollection(t)
+
+	query, count, err := col.convertFilter(Cond().Eq("Status", "paid").In("Status", []string{"paid", "shipped"}))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := bson.M{"status": bson.M{"$eq": "paid", "$in": []string{"paid", "shipped"}}}
+	if count != 1 || !reflect.DeepEqual(query, expect) {
+		t.Errorf("unexpected query %v", query)
+	}
+
+	query, _, err = col.convertFilter(Cond().Eq("Status", "paid").In("Status", []string{"paid"}).In("Status", []string{"paid", "new"}))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect = bson.M{
+		"status": bson.M{"$eq": "paid", "$in": []string{"paid"}},
+		"$and":   bson.A{bson.M{"status": bson.M{"$in": []string{"paid", "new"}}}},
+	}
+	if !reflect.DeepEqual(query, expect) {
+		t.Errorf("expect the repeated operator combined by $and, got %v", query)
+	}
+}
+
+func Test_Cond_ArraySize(t *testing.T) {
+	col := newArticleCollection(t)
+	size := bson.M{"$size": bson.M{"$ifNull": bson.A{"$tags", bson.A{}}}}
+
+	query, _, err := col.convertFilter(Cond().ArraySizeGt("Tags", 3))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(query, bson.M{"$expr": bson.M{"$gt": bson.A{size, 3}}}) {
+		t.Errorf("unexpected size query %v", query)
+	}
+
+	// several sizes are combined in one $expr
+	query, _, err = col.convertFilter(Cond().Eq("Status", "open").ArraySizeGt("Tags", 1).ArraySizeLt("Tags", 5))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := bson.M{
+		"status": "open",
+		"$expr":  bson.M{"$and": bson.A{bson.M{"$gt": bson.A{size, 1}}, bson.M{"$lt": bson.A{size, 5}}}},
+	}
+	if !reflect.DeepEqual(query, expect) {
+		t.Errorf("unexpected size query %v", query)
+	}
+
+	if _, _, err := col.convertFilter(Cond().ArraySizeEq("Title", 1)); err == nil {
+		t.Error("expect error for a field which is not an array")
+	}
+}
+
+func Test_Filter_SliceExact(t *testing.T) {
+	col := newArticleCollection(t)
+
+	type AnyTagFilter struct {
+		Tags []string
+	}
+	type ExactTagFilter struct {
+		Tags []string `bson:"tags,exact"`
+	}
+
+	query, _, err := col.convertFilter(AnyTagFilter{Tags: []string{"x", "y"}})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(query, bson.M{"tags": bson.M{"$in": []string{"x", "y"}}}) {
+		t.Errorf("unexpected query %v", query)
+	}
+
+	query, _, err = col.convertFilter(ExactTagFilter{Tags: []string{"x", "y"}})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(query, bson.M{"tags": []string{"x", "y"}}) {
+		t.Errorf("unexpected query %v", query)
+	}
+
+	type JmongoExactTagFilter struct {
+		Tags   []string `jmongo:"exact"`
+		Labels []string `jmongo:"field=tags,exact"`
+	}
+
+	query, _, err = col.convertFilter(JmongoExactTagFilter{Tags: []string{"x", "y"}})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(query, bson.M{"tags": []string{"x", "y"}}) {
+		t.Errorf("unexpected query %v", query)
+	}
+
+	query, _, err = col.convertFilter(JmongoExactTagFilter{Labels: []string{"z"}})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(query, bson.M{"tags": []string{"z"}}) {
+		t.Errorf("unexpected query %v", query)
+	}
+}
+
+func Test_Filter_OperatorTag(t *testing.T) {
+	col := newOfflineCollection(t)
+
+	type AgeFilter struct {
+		Name   string
+		MinAge int `jmongo:"field=happy,op=gte"`
+		MaxAge int `jmongo:"field=Age,op=lt"`
+	}
+
+	query, _, err := col.convertFilter(AgeFilter{Name: "abc", MinAge: 18})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(query, bson.M{"name": "abc", "happy": bson.M{"$gte": 18}}) {
+		t.Errorf("unexpected query %v", query)
+	}
+
+	query, _, err = col.convertFilter(AgeFilter{MinAge: 18, MaxAge: 60})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(query, bson.M{"happy": bson.M{"$gte": 18, "$lt": 60}}) {
+		t.Errorf("unexpected query %v", query)
+	}
+
+	type BadFilter struct {
+		Age int `jmongo:"op=like"`
+	}
+	if _, _, err := col.convertFilter(BadFilter{Age: 1}); err == nil {
+		t.Error("expect error for unsupported operator")
+	}
+}
+
+type Listing struct {
+	Id       SObjectId `bson:"_id,omitempty"`
+	Title    string    `bson:"title"`
+	Price    int       `bson:"price"`
+	Rating   float64   `bson:"rating"`
+	Online   bool      `bson:"online"`
+	SellerId SObjectId `bson:"sellerId"`
+}
+
+func Test_Cond_FromMap(t *testing.T) {
+	col := NewCollection[*Listing, SObjectId](&Listing{}, newOfflineDatabase(t))
+	sellerId := NewSObjectId()
+
+	cond, err := Cond().FromMap(col.Entity(), map[string]string{
+		"title":    "bike",
+		"Price":    "120",
+		"rating":   "4.5",
+		"online":   "true",
+		"sellerId": string(sellerId),
+	})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	query, _, err := col.convertFilter(cond)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := bson.M{"title": "bike", "price": 120, "rating": 4.5, "online": true, "sellerId": sellerId}
+	if !reflect.DeepEqual(query, expect) {
+		t.Errorf("expect %v, got %v", expect, query)
+	}
+
+	for _, m := range []map[string]string{
+		{"$where": "sleep(1000)"},
+		{"price": "cheap"},
+		{"online": "yes please"},
+		{"sellerId": "not an id"},
+	} {
+		if _, err := Cond().FromMap(col.Entity(), m); err == nil {
+			t.Errorf("expect error for %v", m)
+		}
+	}
+}
+
+func Test_Cond_Mod(t *testing.T) {
+	col := NewCollection[*Listing, SObjectId](&Listing{}, newOfflineDatabase(t))
+
+	query, _, err := col.convertFilter(Cond().Mod("Price", 16, 3))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(query, bson.M{"price": bson.M{"$mod": bson.A{16, 3}}}) {
+		t.Errorf("unexpected mod query %v", query)
+	}
+
+	if _, _, err := col.convertFilter(Cond().Mod("Rating", 2, 0)); err == nil {
+		t.Error("expect error for a field which is not an integer")
+	}
+	if _, _, err := col.convertFilter(Cond().Mod("Price", 0, 0)); err == nil {
+		t.Error("expect error for divisor 0")
+	}
+}
+
+func Test_Filter_Null(t *testing.T) {
+	col := newOfflineCollection(t)
+
+	type OrderFilter struct {
+		Name    string
+		OrderId any `bson:"orderId"`
+		Missing any `bson:"orderId" jmongo:"op=ne"`
+	}
+
+	query, _, err := col.convertFilter(OrderFilter{Name: "abc", OrderId: extype.Null{}})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(query, bson.M{"name": "abc", "orderId": nil}) {
+		t.Errorf("unexpected query %v", query)
+	}
+
+	query, _, err = col.convertFilter(OrderFilter{Missing: extype.Null{}})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(query, bson.M{"orderId": bson.M{"$ne": nil}}) {
+		t.Errorf("unexpected query %v", query)
+	}
+
+	// an unset interface field is still omitted
+	query, _, err = col.convertFilter(OrderFilter{Name: "abc"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(query, bson.M{"name": "abc"}) {
+		t.Errorf("unexpected query %v", query)
+	}
+}
+
+func Test_Cond_GoAndDBNames(t *testing.T) {
+	col := newOfflineCollection(t)
+
+	for _, name := range []string{"Age", "happy"} {
+		query, _, err := col.convertFilter(Cond().Eq(name, 1))
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if !reflect.DeepEqual(query, bson.M{"happy": 1}) {
+			t.Errorf("expect %s to resolve to happy, got %v", name, query)
+		}
+
+		option := Option().AddOrder(name, true)
+		sort, err := option.makeSort(col.schema, option.sorts)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if !reflect.DeepEqual(sort, bson.D{{Key: "happy", Value: 1}}) {
+			t.Errorf("expect %s to sort by happy, got %v", name, sort)
+		}
+	}
+
+	// mistyped names get a suggestion
+	_, _, err := col.convertFilter(Cond().Eq("age", 1))
+	if err == nil || !strings.Contains(err.Error(), "did you mean 'Age' or 'happy'?") {
+		t.Errorf("expect a suggestion, got %v", err)
+	}
+	option := Option().AddOrder("hapy", true)
+	_, err = option.makeSort(col.schema, option.sorts)
+	if err == nil || !strings.Contains(err.Error(), "'happy'") {
+		t.Errorf("expect a suggestion, got %v", err)
+	}
+	_, _, err = col.convertFilter(Cond().Eq("unrelated", 1))
+	if err == nil || strings.Contains(err.Error(), "did you mean") {
+		t.Errorf("expect no suggestion, got %v", err)
+	}
+}
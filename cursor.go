@@ -0,0 +1,56 @@
+package jmongo
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Cursor iterate the documents of Iterate one by one, only the current batch is held in memory
+type Cursor[MODEL any, ID any] struct {
+	ctx        context.Context
+	collection *Collection[MODEL, ID]
+	cursor     *mongo.Cursor
+	end        func()
+	err        error
+}
+
+// Iterate find the documents of filter as Find does, without reading them all into memory, e.g. exporting a huge collection.
+// MaxUnboundedLimit does not cap it, the timeout of the collection bounds only the query, ctx bounds the whole iteration.
+// Always close the cursor
+func (th *Collection[MODEL, ID]) Iterate(ctx context.Context, filter any, opts ...*FindOption) (*Cursor[MODEL, ID], error) {
+	cursor, _, end, err := th.openCursor(ctx, filter, true, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Cursor[MODEL, ID]{ctx: ctx, collection: th, cursor: cursor, end: end}, nil
+}
+
+// Next decode the next document into dest, false when the documents are exhausted or it fails, see Err
+func (th *Cursor[MODEL, ID]) Next(dest *MODEL) bool {
+	if th.err != nil || !th.cursor.Next(th.ctx) {
+		return false
+	}
+
+	var model MODEL
+	if err := th.collection.decodeRaw(th.cursor.Current, th.collection.schema, &model); err != nil {
+		th.err = err
+		return false
+	}
+	*dest = model
+	return true
+}
+
+// Err the error stopping Next, nil when the documents are exhausted
+func (th *Cursor[MODEL, ID]) Err() error {
+	if th.err != nil {
+		return th.err
+	}
+	return errors.WithStack(th.cursor.Err())
+}
+
+// Close close the cursor on the server
+func (th *Cursor[MODEL, ID]) Close(ctx context.Context) error {
+	defer th.end()
+	return errors.WithStack(th.cursor.Close(ctx))
+}
@@ -0,0 +1,113 @@
+package jmongo
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"testing"
+	"time"
+)
+
+func Test_Iterate_Option(t *testing.T) {
+	col := newOfflineCollection(t)
+	if _, err := col.Iterate(context.Background(), bson.M{}, Option().AddOrder("Missing", true)); err == nil {
+		t.Error("expect error of the unknown sort field")
+	}
+}
+
+func Test_Cursor_NextDecodesModel(t *testing.T) {
+	database := newOfflineDatabase(t)
+	database.client.SetCipher(reverseCipher{})
+	col := NewCollection[*Patient, SObjectId](&Patient{}, database)
+	ctx := context.Background()
+
+	patient := &Patient{Id: NewSObjectId(), Name: "abc", Phone: "123456", Record: []byte("xyz")}
+	document, err := col.encryptDocument(patient)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	documents, err := mongo.NewCursorFromDocuments([]any{document}, nil, DefaultRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ended := false
+	cursor := &Cursor[*Patient, SObjectId]{ctx: ctx, collection: col, cursor: documents, end: func() { ended = true }}
+
+	// the encrypted fields are decoded by their setters as Find does
+	var decoded *Patient
+	if !cursor.Next(&decoded) {
+		t.Fatalf("expect a document, got %+v", cursor.Err())
+	}
+	if decoded.Id != patient.Id || decoded.Phone != "123456" || string(decoded.Record) != "xyz" {
+		t.Errorf("expect the plaintext, got %+v", decoded)
+	}
+	if cursor.Next(&decoded) || cursor.Err() != nil {
+		t.Errorf("expect the documents exhausted, got %v", cursor.Err())
+	}
+	if err := cursor.Close(ctx); err != nil || !ended {
+		t.Errorf("expect the cursor closed and ended, got %v %v", ended, err)
+	}
+
+	// a document failing to decode stops the iteration with its error
+	documents, err = mongo.NewCursorFromDocuments([]any{bson.M{"_id": NewSObjectId(), "phone": true}}, nil, DefaultRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursor = &Cursor[*Patient, SObjectId]{ctx: ctx, collection: col, cursor: documents, end: func() {}}
+	if cursor.Next(&decoded) || cursor.Err() == nil {
+		t.Error("expect the error of the undecodable phone")
+	}
+}
+
+func Test_Iterate(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Test, SObjectId](&Test{}, c.Database("test"))
+	ctx := context.Background()
+
+	name := NewSObjectId().ToString()
+	tests := make([]*Test, 300)
+	for i := range tests {
+		tests[i] = &Test{Name: name, Age: i}
+	}
+	if _, err := col.InsertMany(ctx, tests); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	cursor, err := col.Iterate(ctx, bson.M{"name": name}, Option().AddOrder("Age", true).FindOptions(options.Find().SetBatchSize(50)))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer cursor.Close(ctx)
+
+	count := 0
+	var test *Test
+	for cursor.Next(&test) {
+		if test.Age != count {
+			t.Fatalf("expect age %d, got %d", count, test.Age)
+		}
+		count++
+	}
+	if err := cursor.Err(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if count != len(tests) {
+		t.Errorf("expect %d documents, got %d", len(tests), count)
+	}
+}
+
+func Test_FindInto_Timeout(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Test, SObjectId](&Test{}, c.Database("test")).Timeout(time.Minute)
+
+	// the timeout of the collection bounds the getMores of decoding too
+	err := col.findInto(context.Background(), bson.M{}, func(ctx context.Context, cursor *mongo.Cursor) error {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("expect the decoding to be bounded by the timeout")
+		}
+		return nil
+	}, Option().Limit(1))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+}
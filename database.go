@@ -3,6 +3,7 @@ package jmongo
 import (
 	"context"
 	"fmt"
+	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -25,6 +26,20 @@ func (th *Database) Database() *mongo.Database {
 	return th.db
 }
 
+// Name name of the database
+func (th *Database) Name() string {
+	return th.db.Name()
+}
+
+// CollectionNames names of all collections in the database
+func (th *Database) CollectionNames(ctx context.Context) ([]string, error) {
+	names, err := th.db.ListCollectionNames(ctx, bson.D{})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return names, nil
+}
+
 // Watch listen: 出错直接使用panic
 func (th *Database) Watch(opts *options.ChangeStreamOptions, matchStage bson.D, listen func(stream *mongo.ChangeStream) error) {
 
@@ -0,0 +1,231 @@
+package jmongo
+
+import (
+	"context"
+	"fmt"
+	"github.com/JackWSK/jmongo/entity"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"reflect"
+	"time"
+)
+
+var (
+	rawType     = reflect.TypeOf(bson.Raw(nil))
+	timeType    = reflect.TypeOf(time.Time{})
+	timePtrType = reflect.TypeOf(&time.Time{})
+)
+
+// decodeCursor decode every document of cursor into T described by schema,
+// with keepPartial the documents decoded before ctx is done are returned with the error of ctx
+func decodeCursor[T any, MODEL any, ID any](ctx context.Context, col *Collection[MODEL, ID], cursor *mongo.Cursor, schema *entity.Entity, keepPartial bool) ([]T, error) {
+	var out []T
+
+	// nothing is decoded by the setters, let the driver decode all
+	if !col.customDecoded(schema) && !keepPartial {
+		err := cursor.All(ctx, &out)
+		if err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+
+	for {
+		// documents of the current batch are returned by Next even when ctx is done
+		if keepPartial && ctx.Err() != nil {
+			return out, errors.WithStack(ctx.Err())
+		}
+		if !cursor.Next(ctx) {
+			break
+		}
+
+		var v T
+		if err := col.decodeRaw(cursor.Current, schema, &v); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	if err := cursor.Err(); err != nil {
+		if keepPartial && ctx.Err() != nil {
+			return out, errors.WithStack(ctx.Err())
+		}
+		return nil, errors.WithStack(err)
+	}
+	return out, nil
+}
+
+// allWithRegistry decode every document of cursor into results, a pointer to a slice, by registry instead of the
+// registry of the cursor
+func allWithRegistry(ctx context.Context, cursor *mongo.Cursor, registry *bsoncodec.Registry, results any) error {
+	slice := reflect.ValueOf(results)
+	if slice.Kind() != reflect.Ptr || slice.IsNil() || slice.Elem().Kind() != reflect.Slice {
+		return errors.WithStack(fmt.Errorf("results must be a pointer to a slice, got %T", results))
+	}
+	values := reflect.MakeSlice(slice.Elem().Type(), 0, 0)
+	for cursor.Next(ctx) {
+		value := reflect.New(values.Type().Elem())
+		if err := bson.UnmarshalWithRegistry(registry, cursor.Current, value.Interface()); err != nil {
+			return errors.WithStack(err)
+		}
+		values = reflect.Append(values, value.Elem())
+	}
+	if err := cursor.Err(); err != nil {
+		return errors.WithStack(err)
+	}
+	slice.Elem().Set(values)
+	return nil
+}
+
+// setterFields fields which are decoded by their setters instead of the registry
+func setterFields(schema *entity.Entity) []*entity.EntityField {
+	var fields []*entity.EntityField
+	for _, field := range schema.Fields {
+		if field.StructTags.UnixTime || field.StructTags.Encrypt {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// customDecoded whether documents of schema need more than the registry to decode
+func (th *Collection[MODEL, ID]) customDecoded(schema *entity.Entity) bool {
+	return len(setterFields(schema)) > 0 || schema.RawField != nil || th.readLocation() != nil
+}
+
+// decodeRaw decode raw into out, a pointer to a struct described by schema or to a pointer of it.
+// The registry decodes the document without the setter fields, then every setter field is set from its raw value,
+// the raw field receives the whole document and times are converted to the location of the collection
+func (th *Collection[MODEL, ID]) decodeRaw(raw bson.Raw, schema *entity.Entity, out any) error {
+	if err := th.decodeFields(raw, schema, out); err != nil {
+		return err
+	}
+	if err := setRawDocument(schema.RawField, raw, out); err != nil {
+		return err
+	}
+	if location := th.readLocation(); location != nil {
+		timesIn(schema, settableValue(out), location)
+	}
+	return nil
+}
+
+// decodeFields decode the fields of raw into out, setter fields by their setters
+func (th *Collection[MODEL, ID]) decodeFields(raw bson.Raw, schema *entity.Entity, out any) error {
+	fields := setterFields(schema)
+	if len(fields) == 0 {
+		return errors.WithStack(bson.UnmarshalWithRegistry(DefaultRegistry, raw, out))
+	}
+
+	bySetter := map[string]bool{}
+	for _, field := range fields {
+		bySetter[field.DBName] = true
+	}
+
+	elements, err := raw.Elements()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	index, doc := bsoncore.AppendDocumentStart(nil)
+	for _, element := range elements {
+		if !bySetter[element.Key()] {
+			doc = append(doc, element...)
+		}
+	}
+	doc, err = bsoncore.AppendDocumentEnd(doc, index)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = bson.UnmarshalWithRegistry(DefaultRegistry, doc, out)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	target := settableValue(out)
+	for _, field := range fields {
+		value, err := raw.LookupErr(field.DBName)
+		if err != nil {
+			continue
+		}
+		if field.StructTags.Encrypt {
+			err = th.setDecrypted(field, value, field.ReflectValueOf(target))
+		} else {
+			err = setUnixTime(field, value, field.ReflectValueOf(target))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// timesIn convert the time.Time and *time.Time fields of target to location
+func timesIn(schema *entity.Entity, target reflect.Value, location *time.Location) {
+	for _, field := range schema.Fields {
+		switch field.FieldType {
+		case timeType:
+			value, zero := field.ValueOf(target)
+			if t, ok := value.(time.Time); ok && !zero {
+				field.ReflectValueOf(target).Set(reflect.ValueOf(t.In(location)))
+			}
+		case timePtrType:
+			value, _ := field.ValueOf(target)
+			if t, ok := value.(*time.Time); ok && t != nil {
+				*t = t.In(location)
+			}
+		}
+	}
+}
+
+// setRawDocument set the bson.Raw or bson.M field of out to the whole document
+func setRawDocument(field *entity.EntityField, raw bson.Raw, out any) error {
+	if field == nil {
+		return nil
+	}
+
+	var value any
+	if field.FieldType == rawType {
+		// raw belongs to the batch of the cursor, keep a copy
+		value = bson.Raw(append([]byte(nil), raw...))
+	} else {
+		var m bson.M
+		if err := bson.UnmarshalWithRegistry(DefaultRegistry, raw, &m); err != nil {
+			return errors.WithStack(err)
+		}
+		value = m
+	}
+	field.ReflectValueOf(settableValue(out)).Set(reflect.ValueOf(value))
+	return nil
+}
+
+// settableValue the value whose fields can be set, out is a pointer to a struct or to a pointer of it
+func settableValue(out any) reflect.Value {
+	value := reflect.ValueOf(out)
+	if value.Elem().Kind() == reflect.Ptr {
+		return value.Elem()
+	}
+	return value
+}
+
+// setUnixTime set a time.Time field from unix seconds, a datetime is kept as it is
+func setUnixTime(field *entity.EntityField, value bson.RawValue, target reflect.Value) error {
+	var t time.Time
+	switch value.Type {
+	case bsontype.Int32:
+		t = time.Unix(int64(value.Int32()), 0).UTC()
+	case bsontype.Int64:
+		t = time.Unix(value.Int64(), 0).UTC()
+	case bsontype.Double:
+		t = time.Unix(int64(value.Double()), 0).UTC()
+	case bsontype.DateTime:
+		t = value.Time().UTC()
+	case bsontype.Null, bsontype.Undefined:
+	default:
+		return errors.WithStack(fmt.Errorf("can not decode %s into unix time field %s", value.Type, field.Name))
+	}
+	target.Set(reflect.ValueOf(t))
+	return nil
+}
@@ -0,0 +1,57 @@
+package entity
+
+import (
+	"reflect"
+	"sync"
+)
+
+// FieldAccessor read and set a field of a model, the accessors generated by cmd/jmongogen
+// use the field directly instead of walking the struct by reflection
+type FieldAccessor struct {
+	ValueOf        ValueOfFunc
+	ReflectValueOf ReflectOfFunc
+}
+
+// accessors registered by model type, map[reflect.Type]map[string]FieldAccessor
+var accessorStore = &sync.Map{}
+
+// RegisterAccessors use accessors, keyed by go field name, for the fields of model,
+// the other fields are accessed by reflection. It is called by the init of the generated code,
+// a model parsed before is parsed again when used, collections keep the entity they hold
+func RegisterAccessors(model any, accessors map[string]FieldAccessor) {
+	accessorStore.Store(GetModelType(model), accessors)
+	Forget(model)
+}
+
+// UnregisterAccessors access the fields of model by reflection again, e.g. after a test of the generated accessors
+func UnregisterAccessors(model any) {
+	accessorStore.Delete(GetModelType(model))
+	Forget(model)
+}
+
+// applyAccessors replace the reflection accessors of fields by the ones registered for modelType
+func applyAccessors(modelType reflect.Type, fields []*EntityField) {
+	v, ok := accessorStore.Load(modelType)
+	if !ok {
+		return
+	}
+	accessors := v.(map[string]FieldAccessor)
+	for _, field := range fields {
+		if accessor, ok := accessors[field.Name]; ok && len(field.inlineIndex) == 1 {
+			field.ValueOf = accessor.ValueOf
+			field.ReflectValueOf = accessor.ReflectValueOf
+		}
+	}
+}
+
+// ReflectAccessor the accessor walking the struct by reflection, which the field uses unless one is generated
+func (th *EntityField) ReflectAccessor() FieldAccessor {
+	valueOf, reflectValueOf := setupValuerAndSetter(th.inlineIndex, th.FieldType)
+	return FieldAccessor{ValueOf: valueOf, ReflectValueOf: reflectValueOf}
+}
+
+// IsZero whether v is the zero value of its type, used by the generated accessors for types they can not compare
+func IsZero(v any) bool {
+	value := reflect.ValueOf(v)
+	return !value.IsValid() || value.IsZero()
+}
@@ -0,0 +1,34 @@
+package entity
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Converter convert values of a go type to the value stored in mongo and back
+type Converter struct {
+	Encode func(any) any
+	Decode func(any) any
+}
+
+var converters = &sync.Map{}
+
+// RegisterConverter store values of goType as encode(value) and read them back by decode,
+// decode receives the value as decoded into an interface, e.g. int64, string, bson.D.
+// It is meant for simple scalars such as time.Duration, types with their own bson codec such as time.Time are not converted
+func RegisterConverter(goType reflect.Type, encode func(any) any, decode func(any) any) {
+	converters.Store(goType, &Converter{Encode: encode, Decode: decode})
+}
+
+// LookUpConverter the converter registered for goType, nil when there is none
+func LookUpConverter(goType reflect.Type) *Converter {
+	if v, ok := converters.Load(goType); ok {
+		return v.(*Converter)
+	}
+	return nil
+}
+
+// UnregisterConverter store values of goType as the driver does again, e.g. after a test of the converter
+func UnregisterConverter(goType reflect.Type) {
+	converters.Delete(goType)
+}
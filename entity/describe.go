@@ -0,0 +1,40 @@
+package entity
+
+// EntityDescriptor the parsed metadata of a model which can be marshaled to json, e.g. for code generators or docs
+type EntityDescriptor struct {
+	Name       string `json:"name"`
+	Collection string `json:"collection"`
+	// db name of the id field, empty for documents without id
+	IdField string            `json:"idField,omitempty"`
+	Fields  []FieldDescriptor `json:"fields"`
+}
+
+// FieldDescriptor a field of EntityDescriptor
+type FieldDescriptor struct {
+	Name   string `json:"name"`
+	DBName string `json:"dbName"`
+	// the go type, e.g. *time.Time
+	Type string     `json:"type"`
+	Tags StructTags `json:"tags"`
+}
+
+// Describe the descriptor of the entity, fields are in the order of Fields
+func (th *Entity) Describe() EntityDescriptor {
+	descriptor := EntityDescriptor{
+		Name:       th.Name,
+		Collection: th.Collection,
+		Fields:     make([]FieldDescriptor, 0, len(th.Fields)),
+	}
+	if th.IdField != nil {
+		descriptor.IdField = th.IdField.DBName
+	}
+	for _, field := range th.Fields {
+		descriptor.Fields = append(descriptor.Fields, FieldDescriptor{
+			Name:   field.Name,
+			DBName: field.DBName,
+			Type:   field.FieldType.String(),
+			Tags:   field.StructTags,
+		})
+	}
+	return descriptor
+}
@@ -5,19 +5,44 @@ import (
 	"github.com/JackWSK/jmongo/errortype"
 	"github.com/JackWSK/jmongo/internal/utils"
 	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var cacheStore = &sync.Map{}
 
+// entities parsed by GetOrParseDocument
+var documentCacheStore = &sync.Map{}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	rawType  = reflect.TypeOf(bson.Raw(nil))
+	mType    = reflect.TypeOf(bson.M(nil))
+)
+
 type Entity struct {
 	Name       string
 	ModelType  reflect.Type
 	Collection string
 	IdField    *EntityField
-	DBNames    []string
-	Fields     []*EntityField
+	// time.Time fields set when the model is inserted / updated
+	CreateTimeField *EntityField
+	UpdateTimeField *EntityField
+	// *time.Time field set when the document is soft deleted
+	SoftDeleteField *EntityField
+	// integer field checked and increased by Collection.Mutate
+	VersionField *EntityField
+	// bson.Raw or bson.M field receiving the whole document when read, it is not one of Fields
+	RawField *EntityField
+	// string or []byte fields stored encrypted
+	EncryptedFields []*EntityField
+	DBNames         []string
+	Fields          []*EntityField
 	//Fields      []*EntityField
 	FieldsByName   map[string]*EntityField
 	FieldsByDBName map[string]*EntityField
@@ -32,10 +57,11 @@ func newEntity(dest any) (*Entity, error) {
 
 	modelType := reflect.ValueOf(dest).Type()
 
-	return newEntityByModelType(modelType, nil)
+	return newEntityByModelType(modelType, nil, true)
 }
 
-func newEntityByModelType(modelType reflect.Type, index []int) (*Entity, error) {
+// requireId: models stored in a collection must have an id, documents such as DTOs may not
+func newEntityByModelType(modelType reflect.Type, index []int, requireId bool) (*Entity, error) {
 
 	for modelType.Kind() == reflect.Slice || modelType.Kind() == reflect.Array || modelType.Kind() == reflect.Ptr {
 		modelType = modelType.Elem()
@@ -60,7 +86,7 @@ func newEntityByModelType(modelType reflect.Type, index []int) (*Entity, error)
 		collectionName = tabler.CollectionName()
 	} else {
 
-		collectionName = utils.LowerFirst(modelType.Name())
+		collectionName = namingStrategy.CollectionName(modelType.Name())
 	}
 
 	entity := &Entity{}
@@ -71,11 +97,51 @@ func newEntityByModelType(modelType reflect.Type, index []int) (*Entity, error)
 		return nil, err
 	}
 
+	// fields of the model hide the fields of the same db name in inlined structs
+	fields, err = dropShadowedFields(modelType, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	// use the generated accessors of the model
+	applyAccessors(modelType, fields)
+
+	// extract the field receiving the raw document, it is never stored
+	fields, rawField := extractRawField(fields)
+
 	// extract id field from fields
 	idField := extractIdField(fields)
-	if idField == nil {
+	if idField == nil && requireId {
 		return nil, errors.WithStack(errortype.ErrIdFieldDoesNotExists)
 	}
+	if requireId && !isIdType(idField.FieldType) {
+		return nil, errors.WithStack(fmt.Errorf("%w: id field %s of %s is %s, use (*)primitive.ObjectID, a string, an integer or register it by RegisterIdType",
+			errortype.ErrUnsupportedIdType, idField.Name, modelType.Name(), idField.FieldType))
+	}
+
+	// extract fields of timestamps
+	createTimeField, updateTimeField, err := extractTimeFields(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	// extract field of soft delete
+	softDeleteField, err := extractSoftDeleteField(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	// extract field of the version
+	versionField, err := extractVersionField(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	// extract encrypted fields
+	encryptedFields, err := extractEncryptedFields(fields)
+	if err != nil {
+		return nil, err
+	}
 
 	// create map for fields by name and by db name
 	fieldsByName, fieldsByDBName := makeFieldsByNameAndByDBName(fields)
@@ -88,6 +154,12 @@ func newEntityByModelType(modelType reflect.Type, index []int) (*Entity, error)
 	entity.FieldsByName = fieldsByName
 	entity.FieldsByDBName = fieldsByDBName
 	entity.IdField = idField
+	entity.CreateTimeField = createTimeField
+	entity.UpdateTimeField = updateTimeField
+	entity.SoftDeleteField = softDeleteField
+	entity.VersionField = versionField
+	entity.RawField = rawField
+	entity.EncryptedFields = encryptedFields
 
 	return entity, nil
 }
@@ -102,7 +174,10 @@ func extractFields(modelType reflect.Type, index []int) (fields []*EntityField,
 		cloneIndex = append(cloneIndex, i)
 
 		structField := modelType.Field(i)
-		tag := structField.Tag.Get("bson")
+		tag, tagged := structField.Tag.Lookup("bson")
+		if atomic.LoadInt32(&strictTags) == 1 && !tagged && structField.IsExported() {
+			return nil, errors.WithStack(fmt.Errorf("field %s on %s has no bson tag", structField.Name, modelType.Name()))
+		}
 
 		// parse to get bson info
 		structTags, err := parseTags(utils.LowerFirst(structField.Name), tag)
@@ -110,17 +185,40 @@ func extractFields(modelType reflect.Type, index []int) (fields []*EntityField,
 			return nil, err
 		}
 
-		// filter skip field
-		if structTags.Skip {
+		structTags, err = parseJmongoTags(structTags, structField.Tag.Get("jmongo"))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if structTags.Raw && (!structTags.Skip || (structField.Type != rawType && structField.Type != mType)) {
+			return nil, errors.WithStack(fmt.Errorf("raw field %s must be bson.Raw or bson.M tagged bson:\"-\"", structField.Name))
+		}
+
+		// filter skip field, the raw field is kept and extracted later
+		if structTags.Skip && !structTags.Raw {
 			continue
 		}
 
-		if structField.Anonymous && !structTags.Inline {
+		// embedded non struct types, e.g. a named slice of tags, are regular fields
+		inlineType := structField.Type
+		if inlineType.Kind() == reflect.Ptr {
+			inlineType = inlineType.Elem()
+		}
+		embedsStruct := inlineType.Kind() == reflect.Struct
+		if structField.Anonymous && embedsStruct && !structTags.Inline {
 			return nil, errors.New("anonymous field must set inline tag")
 		}
 
+		if structTags.Inline && !embedsStruct {
+			return nil, errors.WithStack(fmt.Errorf("inline field %s must be a struct or a struct pointer", structField.Name))
+		}
+
 		if structTags.Inline {
-			inlineFields, err := extractFields(structField.Type, cloneIndex)
+			// a negative index marks a pointer, which is allocated when a field inside is set
+			if structField.Type.Kind() == reflect.Ptr {
+				cloneIndex[len(cloneIndex)-1] = -i - 1
+			}
+			inlineFields, err := extractFields(inlineType, cloneIndex)
 			if err != nil {
 				return nil, err
 			}
@@ -138,6 +236,16 @@ func extractFields(modelType reflect.Type, index []int) (fields []*EntityField,
 	return fields, nil
 }
 
+// extractRawField remove the field tagged by raw from fields
+func extractRawField(fields []*EntityField) ([]*EntityField, *EntityField) {
+	for i, field := range fields {
+		if field.StructTags.Raw {
+			return append(fields[:i:i], fields[i+1:]...), field
+		}
+	}
+	return fields, nil
+}
+
 func extractIdField(fields []*EntityField) *EntityField {
 
 	var idField *EntityField
@@ -151,17 +259,148 @@ func extractIdField(fields []*EntityField) *EntityField {
 	return idField
 }
 
+var objectIdType = reflect.TypeOf(primitive.ObjectID{})
+
+// id types registered by RegisterIdType
+var idTypeStore = &sync.Map{}
+
+// RegisterIdType allow the type of id as the id of models, besides (*)primitive.ObjectID, strings and integers,
+// e.g. a struct of a compound key. Call it before the models are parsed
+func RegisterIdType(id any) {
+	idTypeStore.Store(reflect.TypeOf(id), true)
+}
+
+// isIdType whether idType can be the id of a model, *primitive.ObjectID is left nil until the insert generates it
+func isIdType(idType reflect.Type) bool {
+	if idType == objectIdType || idType == reflect.PtrTo(objectIdType) {
+		return true
+	}
+	if _, ok := idTypeStore.Load(idType); ok {
+		return true
+	}
+	switch idType.Kind() {
+	case reflect.String, reflect.Int, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+// extractTimeFields find the fields tagged by autoCreateTime / autoUpdateTime,
+// fields named CreatedAt / UpdatedAt are used when no field is tagged, they must be time.Time
+func extractTimeFields(fields []*EntityField) (createTimeField, updateTimeField *EntityField, err error) {
+	var createdAt, updatedAt *EntityField
+	for _, field := range fields {
+		if field.StructTags.UnixTime && field.FieldType != timeType {
+			return nil, nil, errors.WithStack(fmt.Errorf("unix time field %s must be time.Time", field.Name))
+		}
+
+		switch {
+		case field.StructTags.AutoCreateTime:
+			createTimeField = field
+		case field.StructTags.AutoUpdateTime:
+			updateTimeField = field
+		case field.Name == "CreatedAt" && field.FieldType == timeType:
+			createdAt = field
+		case field.Name == "UpdatedAt" && field.FieldType == timeType:
+			updatedAt = field
+		}
+	}
+
+	if createTimeField == nil {
+		createTimeField = createdAt
+	}
+	if updateTimeField == nil {
+		updateTimeField = updatedAt
+	}
+
+	for _, field := range []*EntityField{createTimeField, updateTimeField} {
+		if field != nil && field.FieldType != timeType {
+			return nil, nil, errors.WithStack(fmt.Errorf("timestamp field %s must be time.Time", field.Name))
+		}
+	}
+	return createTimeField, updateTimeField, nil
+}
+
+// extractSoftDeleteField find the field tagged by softDelete, it must be a *time.Time without omitempty,
+// so documents which are not deleted store null
+func extractSoftDeleteField(fields []*EntityField) (*EntityField, error) {
+	for _, field := range fields {
+		if !field.StructTags.SoftDelete {
+			continue
+		}
+		if field.FieldType != reflect.PtrTo(timeType) || field.StructTags.OmitEmpty {
+			return nil, errors.WithStack(fmt.Errorf("soft delete field %s must be *time.Time without omitempty", field.Name))
+		}
+		return field, nil
+	}
+	return nil, nil
+}
+
+// extractVersionField find the field tagged by version, it must be an int, int32 or int64
+func extractVersionField(fields []*EntityField) (*EntityField, error) {
+	for _, field := range fields {
+		if !field.StructTags.Version {
+			continue
+		}
+		switch field.FieldType.Kind() {
+		case reflect.Int, reflect.Int32, reflect.Int64:
+			return field, nil
+		}
+		return nil, errors.WithStack(fmt.Errorf("version field %s must be an int, int32 or int64", field.Name))
+	}
+	return nil, nil
+}
+
+// extractEncryptedFields find the fields tagged by encrypt, they must be string or []byte
+func extractEncryptedFields(fields []*EntityField) ([]*EntityField, error) {
+	var encrypted []*EntityField
+	for _, field := range fields {
+		if !field.StructTags.Encrypt {
+			continue
+		}
+		if field.FieldType.Kind() != reflect.String && field.FieldType != bytesType {
+			return nil, errors.WithStack(fmt.Errorf("encrypted field %s must be string or []byte", field.Name))
+		}
+		encrypted = append(encrypted, field)
+	}
+	return encrypted, nil
+}
+
+// dropShadowedFields keep the shallowest of the fields stored under the same db name, as the bson encoder does,
+// e.g. a field of the model hides the field of an inlined struct. Fields of the same depth can not be encoded
+func dropShadowedFields(modelType reflect.Type, fields []*EntityField) ([]*EntityField, error) {
+	shallowest := map[string]*EntityField{}
+	for _, field := range fields {
+		existing, ok := shallowest[field.DBName]
+		switch {
+		case !ok || len(field.inlineIndex) < len(existing.inlineIndex):
+			shallowest[field.DBName] = field
+		case len(field.inlineIndex) == len(existing.inlineIndex):
+			return nil, errors.WithStack(fmt.Errorf("fields %s and %s of %s are both stored as %s",
+				existing.Name, field.Name, modelType.Name(), field.DBName))
+		}
+	}
+
+	kept := fields[:0]
+	for _, field := range fields {
+		if shallowest[field.DBName] == field {
+			kept = append(kept, field)
+		}
+	}
+	return kept, nil
+}
+
+// makeFieldsByNameAndByDBName index fields by the name and by the db name,
+// the shallowest field wins for a name as in go, e.g. the same name in an inlined struct
 func makeFieldsByNameAndByDBName(fields []*EntityField) (fieldsByName, fieldsByDBName map[string]*EntityField) {
 	fieldsByName = map[string]*EntityField{}
 	fieldsByDBName = map[string]*EntityField{}
 
 	for _, field := range fields {
 
-		if _, ok := fieldsByDBName[field.DBName]; !ok {
-			fieldsByDBName[field.DBName] = field
-		}
+		fieldsByDBName[field.DBName] = field
 
-		if _, ok := fieldsByName[field.Name]; !ok {
+		if existing, ok := fieldsByName[field.Name]; !ok || len(field.inlineIndex) < len(existing.inlineIndex) {
 			fieldsByName[field.Name] = field
 		}
 	}
@@ -186,6 +425,62 @@ func (th *Entity) LookUpField(name string) *EntityField {
 	return nil
 }
 
+// MustLookUpField same as LookUpField, but return an error when the field can not be found
+func (th *Entity) MustLookUpField(name string) (*EntityField, error) {
+	if field := th.LookUpField(name); field != nil {
+		return field, nil
+	}
+	if field := th.suggestField(name); field != nil {
+		if field.Name != field.DBName {
+			return nil, errors.WithStack(fmt.Errorf("field %s can not be found in %s, did you mean '%s' or '%s'?", name, th.ModelType.Name(), field.Name, field.DBName))
+		}
+		return nil, errors.WithStack(fmt.Errorf("field %s can not be found in %s, did you mean '%s'?", name, th.ModelType.Name(), field.DBName))
+	}
+	return nil, errors.WithStack(fmt.Errorf("field %s can not be found in %s", name, th.ModelType.Name()))
+}
+
+// suggestField the field whose go name or db name is closest to the mistyped name, e.g. age for Age,
+// nil when none is within 2 edits
+func (th *Entity) suggestField(name string) *EntityField {
+	var best *EntityField
+	bestDistance := 3
+	for _, field := range th.Fields {
+		for _, candidate := range []string{field.Name, field.DBName} {
+			if distance := editDistance(strings.ToLower(name), strings.ToLower(candidate)); distance < bestDistance {
+				best, bestDistance = field, distance
+			}
+		}
+	}
+	return best
+}
+
+// editDistance the levenshtein distance of a and b
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
 func (th *Entity) IdDBName() string {
 	if th.IdField != nil {
 		return th.IdField.DBName
@@ -193,8 +488,63 @@ func (th *Entity) IdDBName() string {
 	return "_id"
 }
 
+// AppendDocument append the fields of value to dst by db name, in the order of Fields, zero values are skipped when omitZero.
+// Pass dst[:0] of a previous document to build the next one without growing a new slice
+func (th *Entity) AppendDocument(dst bson.D, value reflect.Value, omitZero bool) bson.D {
+	for _, field := range th.Fields {
+		object, zero := field.ValueOf(value)
+		if zero && omitZero {
+			continue
+		}
+		dst = append(dst, bson.E{Key: field.DBName, Value: object})
+	}
+	return dst
+}
+
 var mutex sync.Mutex
 
+// parse with StrictTags, 1 when strict. It is read atomically since GetOrParseDocument parses without the mutex
+var strictTags int32
+
+// StrictTags when strict, parsing a model returns an error for an exported field without a bson tag
+// instead of storing it by its name with the first letter lowercased. The models parsed before are parsed again, so call it before creating any collection
+func StrictTags(strict bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	var value int32
+	if strict {
+		value = 1
+	}
+	atomic.StoreInt32(&strictTags, value)
+	clearCache()
+}
+
+// ResetCache forget every parsed model, they are parsed again when used, e.g. between tests defining types of the same name.
+// Collections keep the entities they hold. It is safe to call concurrently with parsing
+func ResetCache() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	clearCache()
+}
+
+// Forget forget the parsed model of dest, e.g. &Order{}, as ResetCache does for every model
+func Forget(dest any) {
+	modelType := GetModelType(dest)
+	mutex.Lock()
+	defer mutex.Unlock()
+	cacheStore.Delete(modelType)
+	documentCacheStore.Delete(modelType)
+}
+
+func clearCache() {
+	for _, store := range []*sync.Map{cacheStore, documentCacheStore} {
+		store.Range(func(key, value any) bool {
+			store.Delete(key)
+			return true
+		})
+	}
+}
+
 func GetModelType(dest any) reflect.Type {
 	modelType := reflect.ValueOf(dest).Type()
 	for modelType.Kind() == reflect.Slice || modelType.Kind() == reflect.Array || modelType.Kind() == reflect.Ptr {
@@ -203,6 +553,23 @@ func GetModelType(dest any) reflect.Type {
 	return modelType
 }
 
+// GetOrParseDocument parse a struct which is not stored as a model, e.g. a DTO decoded from a projection,
+// unlike GetOrParse the struct does not need an id field
+func GetOrParseDocument(dest any) (*Entity, error) {
+	modelType := GetModelType(dest)
+
+	if v, ok := documentCacheStore.Load(modelType); ok {
+		return v.(*Entity), nil
+	}
+
+	entity, err := newEntityByModelType(modelType, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	v, _ := documentCacheStore.LoadOrStore(modelType, entity)
+	return v.(*Entity), nil
+}
+
 func GetOrParse(dest any) (entity *Entity, err error) {
 
 	modelType := GetModelType(dest)
@@ -222,13 +589,16 @@ func GetOrParse(dest any) (entity *Entity, err error) {
 	defer func() {
 		mutex.Unlock()
 	}()
-	if _, ok := cacheStore.Load(modelType); !ok {
-		entity, err = newEntity(dest)
-		if err != nil {
-			return nil, err
-		}
-		cacheStore.Store(modelType, entity)
+	// parsed by another caller while waiting for the lock, every model is parsed once
+	if v, ok := cacheStore.Load(modelType); ok {
+		return v.(*Entity), nil
+	}
+
+	entity, err = newEntity(dest)
+	if err != nil {
+		return nil, err
 	}
+	cacheStore.Store(modelType, entity)
 
 	return entity, nil
 }
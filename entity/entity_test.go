@@ -1,9 +1,16 @@
 package entity
 
 import (
+	"errors"
 	"fmt"
+	"github.com/JackWSK/jmongo/errortype"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 type Order struct {
@@ -46,6 +53,401 @@ func Test_Entity(t *testing.T) {
 
 }
 
+type OnlyId struct {
+	Id string `bson:"_id"`
+}
+
+type Point struct {
+	Id       string `bson:"_id"`
+	X        int
+	LongName int
+}
+
+type Empty struct {
+}
+
+func Test_Entity_OnlyId(t *testing.T) {
+	e, err := GetOrParse(&OnlyId{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if len(e.Fields) != 1 || e.IdField == nil || e.IdDBName() != "_id" {
+		t.Fatalf("unexpected entity %+v", e)
+	}
+
+	// decode into an element of the slice made from the entity
+	slice := e.MakeSlice()
+	if slice.Type() != reflect.TypeOf(&[]*OnlyId{}) {
+		t.Fatalf("unexpected slice type %s", slice.Type())
+	}
+
+	data, err := bson.Marshal(bson.M{"_id": "abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	element := reflect.New(e.ModelType)
+	if err := bson.Unmarshal(data, element.Interface()); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	slice.Elem().Set(reflect.Append(slice.Elem(), element))
+
+	models := *slice.Interface().(*[]*OnlyId)
+	if len(models) != 1 || models[0].Id != "abc" {
+		t.Errorf("unexpected models %v", models)
+	}
+}
+
+func Test_Entity_SingleLetterField(t *testing.T) {
+	e, err := GetOrParse(&Point{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if field := e.LookUpField("X"); field == nil || field.DBName != "x" {
+		t.Errorf("expect field X mapped to x, got %+v", field)
+	}
+
+	if field := e.LookUpField("LongName"); field == nil || field.DBName != "longName" {
+		t.Errorf("expect field LongName mapped to longName, got %+v", field)
+	}
+}
+
+func Test_Entity_Empty(t *testing.T) {
+	_, err := GetOrParse(&Empty{})
+	if !errors.Is(err, errortype.ErrIdFieldDoesNotExists) {
+		t.Errorf("expect id field error, got %v", err)
+	}
+}
+
+type BaseModel struct {
+	Id        string    `bson:"_id,omitempty"`
+	CreatedAt time.Time `bson:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt"`
+}
+
+type Account struct {
+	BaseModel `bson:",inline"`
+	Name      string `bson:"name"`
+}
+
+type Tagged struct {
+	Id       string    `bson:"_id"`
+	Created  time.Time `bson:"created" jmongo:"autoCreateTime"`
+	Modified time.Time `bson:"modified" jmongo:"autoUpdateTime"`
+}
+
+func Test_Entity_EmbeddedBase(t *testing.T) {
+	e, err := GetOrParse(&Account{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if e.IdField == nil || e.IdField.Name != "Id" || e.IdDBName() != "_id" {
+		t.Errorf("expect id from the embedded base, got %+v", e.IdField)
+	}
+	if e.CreateTimeField == nil || e.CreateTimeField.DBName != "createdAt" {
+		t.Errorf("unexpected create time field %+v", e.CreateTimeField)
+	}
+	if e.UpdateTimeField == nil || e.UpdateTimeField.DBName != "updatedAt" {
+		t.Errorf("unexpected update time field %+v", e.UpdateTimeField)
+	}
+	if field := e.LookUpField("name"); field == nil || field.Name != "Name" {
+		t.Errorf("unexpected name field %+v", field)
+	}
+
+	// fields of the base are read and written through the embedding model
+	account := &Account{BaseModel: BaseModel{Id: "abc"}}
+	if id, _ := e.IdField.ValueOf(reflect.ValueOf(account)); id != "abc" {
+		t.Errorf("expect id abc, got %v", id)
+	}
+	now := time.Now()
+	e.CreateTimeField.ReflectValueOf(reflect.ValueOf(account)).Set(reflect.ValueOf(now))
+	if !account.CreatedAt.Equal(now) {
+		t.Errorf("expect created at %v, got %v", now, account.CreatedAt)
+	}
+}
+
+func Test_Entity_TaggedTimestamps(t *testing.T) {
+	e, err := GetOrParse(&Tagged{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if e.CreateTimeField == nil || e.CreateTimeField.Name != "Created" {
+		t.Errorf("unexpected create time field %+v", e.CreateTimeField)
+	}
+	if e.UpdateTimeField == nil || e.UpdateTimeField.Name != "Modified" {
+		t.Errorf("unexpected update time field %+v", e.UpdateTimeField)
+	}
+}
+
+type Twin struct {
+	Id    string `bson:"_id"`
+	Name  string `bson:"name"`
+	Alias string `bson:"name"`
+}
+
+func Test_Entity_DuplicateDBName(t *testing.T) {
+	if _, err := GetOrParse(&Twin{}); err == nil || !strings.Contains(err.Error(), "Name and Alias") {
+		t.Errorf("expect the duplicate db name to be an error, got %v", err)
+	}
+}
+
+type Audit struct {
+	CreatedAt time.Time `bson:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt"`
+	Note      string    `bson:"note"`
+}
+
+type Ledger struct {
+	*Audit `bson:",inline"`
+	Id     string `bson:"_id"`
+	Note   string `bson:"note"`
+}
+
+func Test_Entity_InlinePointer(t *testing.T) {
+	e, err := GetOrParse(&Ledger{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(e.Fields) != 4 {
+		t.Errorf("expect the shadowed note of Audit to be dropped, got %d fields", len(e.Fields))
+	}
+
+	// the note of the model hides the note of the inlined struct
+	ledger := &Ledger{Note: "outer"}
+	if note, _ := e.LookUpField("note").ValueOf(reflect.ValueOf(ledger)); note != "outer" {
+		t.Errorf("expect the note of the model, got %v", note)
+	}
+	if field := e.LookUpField("Note"); field == nil || field.StructField.Index[0] != 2 {
+		t.Errorf("expect Note of the model, got %+v", field)
+	}
+
+	// the inlined pointer is allocated when a field inside is set
+	now := time.Now()
+	e.CreateTimeField.ReflectValueOf(reflect.ValueOf(ledger)).Set(reflect.ValueOf(now))
+	if ledger.Audit == nil || !ledger.CreatedAt.Equal(now) {
+		t.Errorf("expect created at %v, got %+v", now, ledger.Audit)
+	}
+}
+
+func Test_Entity_ResetCache(t *testing.T) {
+	first, err := GetOrParse(&Account{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if cached, _ := GetOrParse(&Account{}); cached != first {
+		t.Fatal("expect the parsed entity to be cached")
+	}
+
+	Forget(&Account{})
+	second, err := GetOrParse(&Account{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if second == first {
+		t.Error("expect the forgotten model to be parsed again")
+	}
+
+	document, _ := GetOrParseDocument(Summary{})
+	ResetCache()
+	if again, _ := GetOrParse(&Account{}); again == second {
+		t.Error("expect every model to be parsed again after ResetCache")
+	}
+	if again, _ := GetOrParseDocument(Summary{}); again == document {
+		t.Error("expect every document to be parsed again after ResetCache")
+	}
+}
+
+func Test_Entity_RegisterAccessors(t *testing.T) {
+	// parsed before the accessors are registered
+	if _, err := GetOrParse(&Point{}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	RegisterAccessors(&Point{}, map[string]FieldAccessor{
+		"X": {
+			ValueOf: func(value reflect.Value) (any, bool) {
+				return "generated", false
+			},
+		},
+	})
+	valueOf := func() any {
+		schema, err := GetOrParse(&Point{})
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		field, err := schema.MustLookUpField("X")
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		value, _ := field.ValueOf(reflect.ValueOf(&Point{X: 1}))
+		return value
+	}
+	if value := valueOf(); value != "generated" {
+		t.Errorf("expect the registered accessor, got %v", value)
+	}
+
+	UnregisterAccessors(&Point{})
+	if value := valueOf(); value != 1 {
+		t.Errorf("expect the reflection accessor after UnregisterAccessors, got %v", value)
+	}
+}
+
+func Test_Entity_ConcurrentParse(t *testing.T) {
+	Forget(&Account{})
+
+	var wg sync.WaitGroup
+	entities := make([]*Entity, 50)
+	errs := make([]error, len(entities))
+	start := make(chan struct{})
+	for i := range entities {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			entities[i], errs[i] = GetOrParse(&Account{})
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	for i, e := range entities {
+		if errs[i] != nil || e == nil || e != entities[0] {
+			t.Fatalf("expect every caller to get the same entity, got %p %v at %d", e, errs[i], i)
+		}
+	}
+}
+
+func Test_Entity_StrictTagsConcurrentParse(t *testing.T) {
+	defer StrictTags(false)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			StrictTags(false)
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := GetOrParseDocument(Summary{}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+type Summary struct {
+	Title string `bson:"title"`
+}
+
+func Test_Entity_Document(t *testing.T) {
+	e, err := GetOrParseDocument(Summary{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if e.IdField != nil || e.LookUpField("Title") == nil {
+		t.Errorf("unexpected document entity %+v", e)
+	}
+
+	// a model still needs an id
+	if _, err := GetOrParse(&Summary{}); !errors.Is(err, errortype.ErrIdFieldDoesNotExists) {
+		t.Errorf("expect id field error, got %v", err)
+	}
+}
+
+type Person struct {
+	Id string `bson:"_id"`
+}
+
+type Category struct {
+	Id string `bson:"_id"`
+}
+
+func Test_Entity_PluralNaming(t *testing.T) {
+	SetNamingStrategy(PluralNaming{Exceptions: map[string]string{"Person": "people"}})
+	defer SetNamingStrategy(DefaultNaming{})
+
+	for model, expect := range map[any]string{&Person{}: "people", &Order{}: "orders", &Category{}: "categories"} {
+		// newEntity does not store the entity, other tests keep the default naming
+		e, err := newEntity(model)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if e.Collection != expect {
+			t.Errorf("expect collection %s, got %s", expect, e.Collection)
+		}
+	}
+}
+
+type Keywords []string
+
+type Page struct {
+	Keywords
+	Id string `bson:"_id"`
+}
+
+type BadInline struct {
+	Id       string   `bson:"_id"`
+	Keywords []string `bson:",inline"`
+}
+
+func Test_Entity_EmbeddedSlice(t *testing.T) {
+	e, err := newEntity(&Page{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	field := e.LookUpField("keywords")
+	if field == nil || field.FieldType != reflect.TypeOf(Keywords{}) {
+		t.Fatalf("expect the embedded slice as a regular field, got %+v", field)
+	}
+	if value, zero := field.ValueOf(reflect.ValueOf(&Page{Keywords: Keywords{"go"}})); zero || len(value.(Keywords)) != 1 {
+		t.Errorf("unexpected value %v %v", value, zero)
+	}
+
+	if _, err := newEntity(&BadInline{}); err == nil {
+		t.Error("expect error for inlining a slice")
+	}
+}
+
+type CompoundKey struct {
+	Region string `bson:"region"`
+	Code   string `bson:"code"`
+}
+
+func Test_Entity_IdType(t *testing.T) {
+	type ObjectIdModel struct {
+		Id primitive.ObjectID `bson:"_id"`
+	}
+	type StringModel struct {
+		Id string `bson:"_id"`
+	}
+	type BytesModel struct {
+		Id []byte `bson:"_id"`
+	}
+	type CompoundModel struct {
+		Id CompoundKey `bson:"_id"`
+	}
+
+	for _, model := range []any{&ObjectIdModel{}, &StringModel{}} {
+		if _, err := newEntity(model); err != nil {
+			t.Errorf("%+v", err)
+		}
+	}
+
+	_, err := newEntity(&BytesModel{})
+	if !errors.Is(err, errortype.ErrUnsupportedIdType) || !strings.Contains(err.Error(), "id field Id of BytesModel is []uint8") {
+		t.Errorf("expect unsupported id type, got %v", err)
+	}
+
+	RegisterIdType(CompoundKey{})
+	if _, err := newEntity(&CompoundModel{}); err != nil {
+		t.Errorf("expect the registered id type, got %+v", err)
+	}
+}
+
 func Benchmark(b *testing.B) {
 
 	//e, err := GetOrParse(&User{})
@@ -41,6 +41,7 @@ func newField(structField reflect.StructField, structTags StructTags, inlineInde
 		FieldType:      structField.Type,
 		StructField:    structField,
 		index:          index,
+		inlineIndex:    inlineIndex,
 		ReflectValueOf: inlineReflectValueOf,
 		ValueOf:        inlineValueOf,
 	}
@@ -118,16 +119,12 @@ func setupValuerAndSetter(index []int, fieldType reflect.Type) (valueOf ValueOfF
 					v = v.Field(-fieldIdx - 1)
 				}
 
-				if v.Kind() == reflect.Ptr {
-					if v.Type().Elem().Kind() == reflect.Struct {
-						if v.IsNil() {
-							v.Set(reflect.New(v.Type().Elem()))
-						}
-					}
-
-					if idx < len(index)-1 {
-						v = v.Elem()
+				// allocate the inline pointers on the way, the field itself is returned as it is
+				if v.Kind() == reflect.Ptr && idx < len(index)-1 {
+					if v.IsNil() {
+						v.Set(reflect.New(v.Type().Elem()))
 					}
+					v = v.Elem()
 				}
 			}
 			return v
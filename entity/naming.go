@@ -0,0 +1,47 @@
+package entity
+
+import (
+	"github.com/JackWSK/jmongo/internal/utils"
+	"strings"
+)
+
+// NamingStrategy name the collection of a model which does not implement CollectionNameSupplier
+type NamingStrategy interface {
+	CollectionName(modelName string) string
+}
+
+// DefaultNaming the model name with the first letter lowered, Order -> order
+type DefaultNaming struct{}
+
+func (DefaultNaming) CollectionName(modelName string) string {
+	return utils.LowerFirst(modelName)
+}
+
+// PluralNaming the plural of the lowered model name, Order -> orders, Category -> categories.
+// Exceptions map model names to explicit collection names for irregular plurals, e.g. Person -> people
+type PluralNaming struct {
+	Exceptions map[string]string
+}
+
+func (th PluralNaming) CollectionName(modelName string) string {
+	if name, ok := th.Exceptions[modelName]; ok {
+		return name
+	}
+
+	name := utils.LowerFirst(modelName)
+	switch {
+	case strings.HasSuffix(name, "y") && len(name) > 1 && !strings.ContainsAny(name[len(name)-2:len(name)-1], "aeiou"):
+		return name[:len(name)-1] + "ies"
+	case strings.HasSuffix(name, "s") || strings.HasSuffix(name, "x") || strings.HasSuffix(name, "ch") || strings.HasSuffix(name, "sh"):
+		return name + "es"
+	}
+	return name + "s"
+}
+
+var namingStrategy NamingStrategy = DefaultNaming{}
+
+// SetNamingStrategy replace the naming of collections, the name of a model is fixed once it is parsed,
+// so call it before creating any collection
+func SetNamingStrategy(strategy NamingStrategy) {
+	namingStrategy = strategy
+}
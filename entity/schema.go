@@ -0,0 +1,145 @@
+package entity
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"reflect"
+	"strings"
+)
+
+// BSONTyper is implemented by types stored with their own marshaler to tell the bson types used by JSONSchema,
+// e.g. "objectId" or bson.A{"objectId", "string"}
+type BSONTyper interface {
+	BSONType() any
+}
+
+var (
+	bsonTyperType  = reflect.TypeOf((*BSONTyper)(nil)).Elem()
+	bytesType      = reflect.TypeOf([]byte(nil))
+	knownBSONTypes = map[reflect.Type]string{
+		timeType:                               "date",
+		reflect.TypeOf(primitive.DateTime(0)):  "date",
+		reflect.TypeOf(primitive.ObjectID{}):   "objectId",
+		reflect.TypeOf(primitive.Decimal128{}): "decimal",
+		reflect.TypeOf(primitive.Binary{}):     "binData",
+		reflect.TypeOf(primitive.Timestamp{}):  "timestamp",
+		reflect.TypeOf(primitive.Regex{}):      "regex",
+	}
+)
+
+// JSONSchema the $jsonSchema of the model, e.g. used as the validator of the collection.
+// The bsonType of a field comes from its go type, pointers also accept null,
+// fields with validate:"required" are required
+func (th *Entity) JSONSchema() bson.M {
+	return objectSchema(th.Fields, map[reflect.Type]bool{th.ModelType: true})
+}
+
+func objectSchema(fields []*EntityField, visiting map[reflect.Type]bool) bson.M {
+	properties := bson.M{}
+	var required bson.A
+	for _, field := range fields {
+		if field.StructTags.Encrypt {
+			// stored as the ciphertext
+			properties[field.DBName] = bson.M{"bsonType": "binData"}
+		} else {
+			properties[field.DBName] = typeSchema(field.FieldType, visiting)
+		}
+		if isRequired(field.StructField) {
+			required = append(required, field.DBName)
+		}
+	}
+
+	schema := bson.M{"bsonType": "object", "properties": properties}
+	// required can not be empty
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func typeSchema(fieldType reflect.Type, visiting map[reflect.Type]bool) bson.M {
+	if fieldType.Implements(bsonTyperType) {
+		return bson.M{"bsonType": reflect.Zero(fieldType).Interface().(BSONTyper).BSONType()}
+	}
+	if bsonType, ok := knownBSONTypes[fieldType]; ok {
+		return bson.M{"bsonType": bsonType}
+	}
+	// stored as whatever the converter returns
+	if LookUpConverter(fieldType) != nil {
+		return bson.M{}
+	}
+
+	switch fieldType.Kind() {
+	case reflect.Ptr:
+		schema := typeSchema(fieldType.Elem(), visiting)
+		if bsonType, ok := schema["bsonType"]; ok {
+			schema["bsonType"] = appendBSONType(bsonType, "null")
+		}
+		return schema
+	case reflect.Bool:
+		return bson.M{"bsonType": "bool"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return bson.M{"bsonType": "int"}
+	case reflect.Int64:
+		return bson.M{"bsonType": "long"}
+	// int and uint are stored as int32 when they fit
+	case reflect.Int, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return bson.M{"bsonType": bson.A{"int", "long"}}
+	case reflect.Float32, reflect.Float64:
+		return bson.M{"bsonType": "double"}
+	case reflect.String:
+		return bson.M{"bsonType": "string"}
+	case reflect.Slice, reflect.Array:
+		if fieldType == bytesType {
+			return bson.M{"bsonType": "binData"}
+		}
+		// a nil slice is stored as null
+		bsonType := any("array")
+		if fieldType.Kind() == reflect.Slice {
+			bsonType = bson.A{"array", "null"}
+		}
+		return bson.M{"bsonType": bsonType, "items": typeSchema(fieldType.Elem(), visiting)}
+	case reflect.Map:
+		return bson.M{"bsonType": bson.A{"object", "null"}}
+	case reflect.Struct:
+		// recursive types are only checked to be objects
+		if visiting[fieldType] {
+			return bson.M{"bsonType": "object"}
+		}
+		fields, err := extractFields(fieldType, nil)
+		if err != nil {
+			return bson.M{"bsonType": "object"}
+		}
+		visiting[fieldType] = true
+		defer delete(visiting, fieldType)
+		return objectSchema(fields, visiting)
+	}
+	// interfaces accept anything
+	return bson.M{}
+}
+
+func appendBSONType(bsonType any, more string) any {
+	switch v := bsonType.(type) {
+	case bson.A:
+		for _, t := range v {
+			if t == more {
+				return v
+			}
+		}
+		return append(v, more)
+	default:
+		if v == more {
+			return v
+		}
+		return bson.A{v, more}
+	}
+}
+
+func isRequired(structField reflect.StructField) bool {
+	for _, rule := range strings.Split(structField.Tag.Get("validate"), ",") {
+		if rule == "required" {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,10 @@
 package entity
 
-import "strings"
+import (
+	"fmt"
+	"github.com/JackWSK/jmongo/internal/utils"
+	"strings"
+)
 
 type StructTags struct {
 	Name      string
@@ -9,6 +13,56 @@ type StructTags struct {
 	Truncate  bool
 	Inline    bool
 	Skip      bool
+	// set by jmongo:"autoCreateTime" and jmongo:"autoUpdateTime"
+	AutoCreateTime bool
+	AutoUpdateTime bool
+	// set by jmongo:"index" and jmongo:"unique", fields with the same IndexName, e.g. jmongo:"index=idx_a_b",
+	// make one compound index in the order of the fields
+	Index     bool
+	Unique    bool
+	IndexName string
+	// set by jmongo:"unixTime", a time.Time field decoded from unix seconds
+	UnixTime bool
+	// set by jmongo:"softDelete", a *time.Time field which is nil until the document is deleted
+	SoftDelete bool
+	// set by jmongo:"raw", a bson.Raw or bson.M field tagged bson:"-" which receives the whole document when read
+	Raw bool
+	// set by jmongo:"encrypt", a string or []byte field stored encrypted by the cipher of the client
+	Encrypt bool
+	// set by jmongo:"version", an integer field increased by each save of Collection.Mutate
+	Version bool
+}
+
+// parse the jmongo tag of a model field, e.g. jmongo:"autoCreateTime"
+func parseJmongoTags(st StructTags, tag string) (StructTags, error) {
+	for key, value := range utils.ParseTagOptions(tag) {
+		switch key {
+		case "encrypt":
+			st.Encrypt = true
+		case "version":
+			st.Version = true
+		case "raw":
+			st.Raw = true
+		case "softDelete":
+			st.SoftDelete = true
+		case "unixTime":
+			st.UnixTime = true
+		case "index":
+			st.Index = true
+			st.IndexName = value
+		case "unique":
+			st.Index = true
+			st.Unique = true
+			st.IndexName = value
+		case "autoCreateTime":
+			st.AutoCreateTime = true
+		case "autoUpdateTime":
+			st.AutoUpdateTime = true
+		default:
+			return st, fmt.Errorf("unsupported option %s in tag %s", key, tag)
+		}
+	}
+	return st, nil
 }
 
 func parseTags(key string, tag string) (StructTags, error) {
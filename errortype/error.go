@@ -1,13 +1,52 @@
 package errortype
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	ErrUnsupportedDataType = errors.New("unsupported data type")
 
 	ErrFilterNotContainAnyCondition = errors.New("filter not contain any condition, this behavior is not allow")
 
+	// ErrEmptyFilter a delete has no condition, which would delete every document
+	ErrEmptyFilter = errors.New("filter of the delete has no condition")
+
 	ErrIdFieldDoesNotExists = errors.New("id field does not exits, please add tag bson:\"_id\" on any field you want")
 
+	// ErrUnsupportedIdType the id field of a model is not an ObjectID, a string, an integer or a type registered by entity.RegisterIdType
+	ErrUnsupportedIdType = errors.New("unsupported id type")
+
+	// ErrInvalidObjectId a string id is not the hex of an ObjectID
+	ErrInvalidObjectId = errors.New("invalid ObjectId")
+
 	ErrModelTypeNotMatchInCollection = errors.New("model type not match in operator")
+
+	// ErrVersionConflict the document kept being saved by others during every attempt of Collection.Mutate
+	ErrVersionConflict = errors.New("version conflict")
+
+	// ErrPreconditionFailed the document does not exist or does not match the precondition of a conditional write
+	ErrPreconditionFailed = errors.New("precondition failed")
 )
+
+// InsertFailure a document InsertMany failed to insert
+type InsertFailure struct {
+	Index int
+	Id    any
+	Err   error
+}
+
+// InsertManyError some documents of InsertMany failed, the others listed by the returned ids were inserted
+type InsertManyError struct {
+	Failures []InsertFailure
+	Err      error
+}
+
+func (th *InsertManyError) Error() string {
+	return fmt.Sprintf("%d documents failed to insert: %v", len(th.Failures), th.Err)
+}
+
+func (th *InsertManyError) Unwrap() error {
+	return th.Err
+}
@@ -0,0 +1,68 @@
+package jmongo
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Explain the query plan of the find which Find runs with filter and opts, e.g. to check an index is used.
+// The plan is the queryPlanner output of the explain command
+func (th *Collection[MODEL, ID]) Explain(ctx context.Context, filter any, opts ...*FindOption) (bson.M, error) {
+	ctx, cancel := th.withTimeout(ctx)
+	defer cancel()
+
+	command, err := th.explainCommand(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return retry(ctx, th.retryPolicy(), false, func() (bson.M, error) {
+		var plan bson.M
+		err := th.reader().Database().RunCommand(ctx, command).Decode(&plan)
+		return plan, errors.WithStack(err)
+	})
+}
+
+// explainCommand the explain command of the find built by findQuery as Find does, the soft deleted documents excluded,
+// the limit capped and the maxTimeMS of the deadline of ctx
+func (th *Collection[MODEL, ID]) explainCommand(ctx context.Context, filter any, opts ...*FindOption) (bson.D, error) {
+	convertedFilter, findOpts, err := th.findQuery(filter, Merge(opts), true)
+	if err != nil {
+		return nil, err
+	}
+	if maxTime, ok := deadlineMaxTime(ctx); ok {
+		findOpts = append([]*options.FindOptions{options.Find().SetMaxTime(maxTime)}, findOpts...)
+	}
+
+	find := bson.D{
+		{Key: "find", Value: th.collection.Name()},
+		{Key: "filter", Value: convertedFilter},
+	}
+
+	merged := options.MergeFindOptions(findOpts...)
+	if merged.Projection != nil {
+		find = append(find, bson.E{Key: "projection", Value: merged.Projection})
+	}
+	if merged.Sort != nil {
+		find = append(find, bson.E{Key: "sort", Value: merged.Sort})
+	}
+	if merged.Hint != nil {
+		find = append(find, bson.E{Key: "hint", Value: merged.Hint})
+	}
+	if merged.Skip != nil {
+		find = append(find, bson.E{Key: "skip", Value: *merged.Skip})
+	}
+	if merged.Limit != nil {
+		find = append(find, bson.E{Key: "limit", Value: *merged.Limit})
+	}
+	if merged.MaxTime != nil {
+		find = append(find, bson.E{Key: "maxTimeMS", Value: merged.MaxTime.Milliseconds()})
+	}
+
+	return bson.D{
+		{Key: "explain", Value: find},
+		{Key: "verbosity", Value: "queryPlanner"},
+	}, nil
+}
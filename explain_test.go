@@ -0,0 +1,74 @@
+package jmongo
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_Explain_Command(t *testing.T) {
+	col := newOfflineCollection(t)
+
+	filter := Cond().Eq("Name", "abc")
+	command, err := col.explainCommand(context.Background(), filter, Option().AddIncludes("Name").AddOrder("Age", false).Offset(5).Limit(10))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	// the filter is the one Find sends
+	query, _, err := col.convertFilter(filter)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := bson.D{
+		{Key: "explain", Value: bson.D{
+			{Key: "find", Value: col.collection.Name()},
+			{Key: "filter", Value: query},
+			{Key: "projection", Value: bson.D{{Key: "name", Value: 1}}},
+			{Key: "sort", Value: bson.D{{Key: "happy", Value: -1}}},
+			{Key: "skip", Value: int64(5)},
+			{Key: "limit", Value: int64(10)},
+		}},
+		{Key: "verbosity", Value: "queryPlanner"},
+	}
+	if !reflect.DeepEqual(command, expect) {
+		t.Errorf("expect %v, got %v", expect, command)
+	}
+}
+
+func Test_Explain_CommandAsFind(t *testing.T) {
+	col := NewCollection[*Member, SObjectId](&Member{}, newOfflineDatabase(t)).MaxUnboundedLimit(100)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	command, err := col.explainCommand(ctx, bson.M{"email": "a"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	find := command[0].Value.(bson.D).Map()
+	if expect := col.liveFilter(bson.M{"email": "a"}, false); !reflect.DeepEqual(find["filter"], expect) {
+		t.Errorf("expect the soft deleted documents excluded as Find does, got %v", find["filter"])
+	}
+	if find["limit"] != int64(100) {
+		t.Errorf("expect the limit capped by MaxUnboundedLimit, got %v", find["limit"])
+	}
+	if maxTime, ok := find["maxTimeMS"].(int64); !ok || maxTime <= 0 || maxTime > time.Minute.Milliseconds() {
+		t.Errorf("expect the maxTimeMS of the deadline, got %v", find["maxTimeMS"])
+	}
+}
+
+func Test_Explain(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Test, SObjectId](&Test{}, c.Database("test"))
+
+	plan, err := col.Explain(context.Background(), Cond().Eq("Name", "abc"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, ok := plan["queryPlanner"]; !ok {
+		t.Errorf("expect the query planner in the plan, got %v", plan)
+	}
+}
@@ -0,0 +1,14 @@
+// Package extype holds the extra types understood by jmongo
+package extype
+
+// Null matches null in struct filters.
+// Zero values of a struct filter are omitted, so assign Null to an interface field to filter by null, e.g.
+//
+//	type Filter struct {
+//		OrderId any `bson:"orderId"`
+//	}
+//	col.Find(ctx, Filter{OrderId: extype.Null{}}) // {orderId: null}
+//
+// Like mongodb, {field: null} also matches documents without the field.
+// It works with the op tag too, jmongo:"op=ne" emits {field: {$ne: null}}
+type Null struct{}
@@ -7,6 +7,7 @@ import (
 	"github.com/JackWSK/jmongo/filter"
 	"github.com/JackWSK/jmongo/internal/utils"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type FilterOperator interface {
@@ -111,17 +112,17 @@ type Match struct {
 }
 
 func (th Match) handle(field *entity.EntityField, filterField *filter.FilterField, query bson.M) error {
-	var like = ""
+	var regex primitive.Regex
 	switch th.Type {
 	case MatchTypePrefix:
-		like = fmt.Sprintf("%s.*", th.Value)
+		regex = literalRegex("^", th.Value, "")
 	case MatchTypeSuffix:
-		like = fmt.Sprintf("'.*%s'", th.Value)
+		regex = literalRegex("", th.Value, "$")
 	case MatchTypeContains:
-		like = fmt.Sprintf(".*%s.*", th.Value)
+		regex = literalRegex("", th.Value, "")
 	}
 
-	query[field.DBName] = bson.M{"$regex": like}
+	query[field.DBName] = regex
 	return nil
 }
 
@@ -80,12 +80,18 @@ func extractFields(modelType reflect.Type, index []int) (fields []*FilterField,
 			return nil, err
 		}
 
+		structTags, err = parseJmongoTags(structTags, structField.Tag.Get("jmongo"))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
 		// filter skip field
 		if structTags.Skip {
 			continue
 		}
 
-		if structField.Anonymous {
+		// embedded non struct types, e.g. a named slice of tags, are regular fields
+		if structField.Anonymous && structField.Type.Kind() == reflect.Struct {
 			subFields, err := extractFields(structField.Type, cloneIndex)
 			if err != nil {
 				return nil, err
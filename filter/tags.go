@@ -1,36 +1,72 @@
 package filter
 
-import "strings"
+import (
+	"fmt"
+	"github.com/JackWSK/jmongo/internal/utils"
+	"strings"
+)
 
 type StructTags struct {
-    Name   string
-    Skip   bool
+	Name string
+	Skip bool
+	// slice value matches the array exactly instead of $in
+	Exact bool
+	// comparison operator from the jmongo tag, e.g. gte, empty means equality
+	Operator string
+}
+
+var operators = map[string]bool{
+	"eq": true, "ne": true, "gt": true, "gte": true, "lt": true, "lte": true, "in": true, "nin": true,
+}
+
+// parse jmongo tag, e.g. jmongo:"field=happy,op=gte"
+// - field: the model field to compare, model field name or db name
+// - op: operator used to compare, one of eq, ne, gt, gte, lt, lte, in, nin
+// - exact: slice value matches the array exactly instead of $in, same as bson:",exact"
+func parseJmongoTags(st StructTags, tag string) (StructTags, error) {
+	settings := utils.ParseTagOptions(tag)
+	if field, ok := settings["field"]; ok && field != "" {
+		st.Name = field
+	}
+	if _, ok := settings["exact"]; ok {
+		st.Exact = true
+	}
+	if op, ok := settings["op"]; ok {
+		if !operators[op] {
+			return st, fmt.Errorf("unsupported operator %s in tag %s", op, tag)
+		}
+		st.Operator = op
+	}
+	return st, nil
 }
 
 func parseTags(key string, tag string) (StructTags, error) {
-    var st StructTags
-    if tag == "-" {
-        st.Skip = true
-        return st, nil
-    }
-
-    for idx, str := range strings.Split(tag, ",") {
-       if idx == 0 && str != "" {
-           key = str
-       }
-       //switch str {
-       //case "omitempty":
-       //    st.OmitEmpty = true
-       //case "minsize":
-       //    st.MinSize = true
-       //case "truncate":
-       //    st.Truncate = true
-       //case "inline":
-       //    st.Inline = true
-       //}
-    }
-
-    st.Name = key
-
-    return st, nil
+	var st StructTags
+	if tag == "-" {
+		st.Skip = true
+		return st, nil
+	}
+
+	for idx, str := range strings.Split(tag, ",") {
+		if idx == 0 && str != "" {
+			key = str
+		}
+		if idx > 0 && str == "exact" {
+			st.Exact = true
+		}
+		//switch str {
+		//case "omitempty":
+		//    st.OmitEmpty = true
+		//case "minsize":
+		//    st.MinSize = true
+		//case "truncate":
+		//    st.Truncate = true
+		//case "inline":
+		//    st.Inline = true
+		//}
+	}
+
+	st.Name = key
+
+	return st, nil
 }
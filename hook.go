@@ -15,3 +15,10 @@ type BeforeUpdate interface {
 type AfterUpdate interface {
 	AfterUpdate()
 }
+
+// ComputeID derive the id from a business key, e.g. a hash of the email, it is called on insert when the id is zero,
+// so inserting the same key twice fails with a duplicate key error.
+// The value must be assignable or convertible to the id field
+type ComputeID interface {
+	ComputeID() any
+}
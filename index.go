@@ -0,0 +1,82 @@
+package jmongo
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"reflect"
+)
+
+// Indexer declare indexes which are too complex for tags, e.g. text indexes with weights,
+// they are created by EnsureIndexes together with the indexes declared by tags
+type Indexer interface {
+	Indexes() []mongo.IndexModel
+}
+
+// EnsureIndexes create the indexes declared by jmongo:"index" / jmongo:"unique" tags and by Indexer,
+// return the names of the indexes.
+// When the model has a soft delete field, unique indexes without a partial filter only cover documents not deleted
+func (th *Collection[MODEL, ID]) EnsureIndexes(ctx context.Context) ([]string, error) {
+	ctx, cancel := th.withTimeout(ctx)
+	defer cancel()
+
+	models := th.indexModels()
+	if len(models) == 0 {
+		return nil, nil
+	}
+
+	names, err := th.collection.Indexes().CreateMany(ctx, models)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return names, nil
+}
+
+func (th *Collection[MODEL, ID]) indexModels() []mongo.IndexModel {
+	var models []mongo.IndexModel
+	// position of the named indexes in models
+	named := map[string]int{}
+
+	for _, field := range th.schema.Fields {
+		tags := field.StructTags
+		if !tags.Index {
+			continue
+		}
+
+		// a field of a declared compound index
+		if i, ok := named[tags.IndexName]; ok && tags.IndexName != "" {
+			models[i].Keys = append(models[i].Keys.(bson.D), bson.E{Key: field.DBName, Value: 1})
+			if tags.Unique {
+				models[i].Options.SetUnique(true)
+			}
+			continue
+		}
+
+		indexOptions := options.Index()
+		if tags.IndexName != "" {
+			indexOptions.SetName(tags.IndexName)
+			named[tags.IndexName] = len(models)
+		}
+		if tags.Unique {
+			indexOptions.SetUnique(true)
+		}
+		models = append(models, mongo.IndexModel{Keys: bson.D{{Key: field.DBName, Value: 1}}, Options: indexOptions})
+	}
+
+	if indexer, ok := reflect.New(th.schema.ModelType).Interface().(Indexer); ok {
+		models = append(models, indexer.Indexes()...)
+	}
+
+	// soft deleted documents do not block creating the same unique key again
+	if field := th.schema.SoftDeleteField; field != nil {
+		for i := range models {
+			indexOptions := models[i].Options
+			if indexOptions != nil && indexOptions.Unique != nil && *indexOptions.Unique && indexOptions.PartialFilterExpression == nil {
+				indexOptions.SetPartialFilterExpression(bson.M{field.DBName: bson.M{"$type": "null"}})
+			}
+		}
+	}
+	return models
+}
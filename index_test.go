@@ -0,0 +1,97 @@
+package jmongo
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type Post struct {
+	Id      SObjectId `bson:"_id,omitempty"`
+	Slug    string    `bson:"slug" jmongo:"unique"`
+	Author  string    `bson:"author" jmongo:"index=idx_author_date"`
+	Date    string    `bson:"date" jmongo:"index=idx_author_date"`
+	Title   string    `bson:"title"`
+	Content string    `bson:"content"`
+}
+
+func (p *Post) Indexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "title", Value: "text"}, {Key: "content", Value: "text"}},
+			Options: options.Index().SetWeights(bson.M{"title": 10, "content": 1}),
+		},
+	}
+}
+
+func Test_IndexModels(t *testing.T) {
+	col := NewCollection[*Post, SObjectId](&Post{}, newOfflineDatabase(t))
+
+	models := col.indexModels()
+	if len(models) != 3 {
+		t.Fatalf("expect 3 indexes, got %d", len(models))
+	}
+
+	if !reflect.DeepEqual(models[0].Keys, bson.D{{Key: "slug", Value: 1}}) || models[0].Options.Unique == nil || !*models[0].Options.Unique {
+		t.Errorf("unexpected unique index %+v", models[0])
+	}
+	if !reflect.DeepEqual(models[1].Keys, bson.D{{Key: "author", Value: 1}, {Key: "date", Value: 1}}) || *models[1].Options.Name != "idx_author_date" {
+		t.Errorf("unexpected compound index %+v", models[1])
+	}
+	if models[2].Options.Weights == nil {
+		t.Errorf("expect the text index declared by Indexes, got %+v", models[2])
+	}
+}
+
+type Member struct {
+	Id        SObjectId  `bson:"_id,omitempty"`
+	Email     string     `bson:"email" jmongo:"unique"`
+	Phone     string     `bson:"phone"`
+	DeletedAt *time.Time `bson:"deletedAt" jmongo:"softDelete"`
+}
+
+func (m *Member) Indexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "phone", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"phone": bson.M{"$type": "string"}}),
+		},
+	}
+}
+
+func Test_IndexModels_SoftDelete(t *testing.T) {
+	col := NewCollection[*Member, SObjectId](&Member{}, newOfflineDatabase(t))
+
+	models := col.indexModels()
+	if len(models) != 2 {
+		t.Fatalf("expect 2 indexes, got %d", len(models))
+	}
+
+	expect := bson.M{"deletedAt": bson.M{"$type": "null"}}
+	if !reflect.DeepEqual(models[0].Options.PartialFilterExpression, expect) {
+		t.Errorf("expect partial filter %v, got %v", expect, models[0].Options.PartialFilterExpression)
+	}
+
+	// the partial filter of the user is kept
+	expect = bson.M{"phone": bson.M{"$type": "string"}}
+	if !reflect.DeepEqual(models[1].Options.PartialFilterExpression, expect) {
+		t.Errorf("expect partial filter %v, got %v", expect, models[1].Options.PartialFilterExpression)
+	}
+}
+
+func Test_EnsureIndexes(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Post, SObjectId](&Post{}, c.Database("test"))
+
+	names, err := col.EnsureIndexes(context.Background())
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(names) != 3 {
+		t.Errorf("expect 3 indexes, got %v", names)
+	}
+}
@@ -0,0 +1,129 @@
+package jmongo
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// BufferedInserter buffer the documents added by Add and insert them by InsertMany in batches,
+// when flushSize documents are buffered or every flushInterval. It is safe for concurrent use,
+// errors of the flushes run by Add or the interval are passed to the handler set by OnError
+type BufferedInserter[MODEL any, ID any] struct {
+	flushSize int
+	insert    func(ctx context.Context, docs []MODEL) error
+
+	mutex   sync.Mutex
+	buffer  []MODEL
+	onError func(docs []MODEL, err error)
+	closed  bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// BufferedInserter create an inserter of the collection, flushSize <= 0 flushes only by the interval,
+// flushInterval <= 0 flushes only when the buffer is full. Close it to insert the rest and stop the interval
+func (th *Collection[MODEL, ID]) BufferedInserter(flushSize int, flushInterval time.Duration) *BufferedInserter[MODEL, ID] {
+	return newBufferedInserter[MODEL, ID](flushSize, flushInterval, func(ctx context.Context, docs []MODEL) error {
+		_, err := th.InsertMany(ctx, docs)
+		return err
+	})
+}
+
+func newBufferedInserter[MODEL any, ID any](flushSize int, flushInterval time.Duration, insert func(ctx context.Context, docs []MODEL) error) *BufferedInserter[MODEL, ID] {
+	inserter := &BufferedInserter[MODEL, ID]{
+		flushSize: flushSize,
+		insert:    insert,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	if flushInterval <= 0 {
+		close(inserter.done)
+		return inserter
+	}
+
+	go func() {
+		defer close(inserter.done)
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				inserter.flushReporting(context.Background())
+			case <-inserter.stop:
+				return
+			}
+		}
+	}()
+	return inserter
+}
+
+// OnError set the handler of the errors of the flushes run by Add or the interval, with the documents not inserted.
+// Errors are dropped when it is not set
+func (th *BufferedInserter[MODEL, ID]) OnError(handler func(docs []MODEL, err error)) *BufferedInserter[MODEL, ID] {
+	th.mutex.Lock()
+	defer th.mutex.Unlock()
+	th.onError = handler
+	return th
+}
+
+// Add buffer doc, the buffer is flushed when it is full. Documents added after Close are inserted one batch each
+func (th *BufferedInserter[MODEL, ID]) Add(doc MODEL) {
+	th.mutex.Lock()
+	th.buffer = append(th.buffer, doc)
+	full := th.closed || (th.flushSize > 0 && len(th.buffer) >= th.flushSize)
+	th.mutex.Unlock()
+
+	if full {
+		th.flushReporting(context.Background())
+	}
+}
+
+// Flush insert the buffered documents now
+func (th *BufferedInserter[MODEL, ID]) Flush(ctx context.Context) error {
+	docs := th.take()
+	if len(docs) == 0 {
+		return nil
+	}
+	return th.insert(ctx, docs)
+}
+
+// Close stop the interval and insert the buffered documents
+func (th *BufferedInserter[MODEL, ID]) Close(ctx context.Context) error {
+	th.mutex.Lock()
+	if !th.closed {
+		th.closed = true
+		close(th.stop)
+	}
+	th.mutex.Unlock()
+
+	<-th.done
+	return th.Flush(ctx)
+}
+
+// flushReporting flush and pass the error to the handler
+func (th *BufferedInserter[MODEL, ID]) flushReporting(ctx context.Context) {
+	docs := th.take()
+	if len(docs) == 0 {
+		return
+	}
+	if err := th.insert(ctx, docs); err != nil {
+		th.mutex.Lock()
+		onError := th.onError
+		th.mutex.Unlock()
+		if onError != nil {
+			onError(docs, err)
+		}
+	}
+}
+
+// take the buffered documents and empty the buffer
+func (th *BufferedInserter[MODEL, ID]) take() []MODEL {
+	th.mutex.Lock()
+	defer th.mutex.Unlock()
+	docs := th.buffer
+	th.buffer = nil
+	return docs
+}
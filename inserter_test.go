@@ -0,0 +1,106 @@
+package jmongo
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordedInserts the batches passed to the insert of a BufferedInserter
+type recordedInserts struct {
+	mutex   sync.Mutex
+	batches [][]*Test
+	err     error
+}
+
+func (th *recordedInserts) insert(ctx context.Context, docs []*Test) error {
+	th.mutex.Lock()
+	defer th.mutex.Unlock()
+	th.batches = append(th.batches, docs)
+	return th.err
+}
+
+func (th *recordedInserts) count() int {
+	th.mutex.Lock()
+	defer th.mutex.Unlock()
+	return len(th.batches)
+}
+
+func Test_BufferedInserter_Flush(t *testing.T) {
+	recorded := &recordedInserts{}
+	inserter := newBufferedInserter[*Test, SObjectId](3, 0, recorded.insert)
+
+	inserter.Add(&Test{Name: "a"})
+	inserter.Add(&Test{Name: "b"})
+	if recorded.count() != 0 {
+		t.Fatalf("expect nothing inserted below the flush size, got %d batches", recorded.count())
+	}
+
+	if err := inserter.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if recorded.count() != 1 || len(recorded.batches[0]) != 2 {
+		t.Fatalf("expect one batch of 2, got %v", recorded.batches)
+	}
+
+	// a full buffer is flushed by Add
+	for _, name := range []string{"c", "d", "e", "f"} {
+		inserter.Add(&Test{Name: name})
+	}
+	if recorded.count() != 2 || len(recorded.batches[1]) != 3 {
+		t.Fatalf("expect a batch of 3, got %v", recorded.batches)
+	}
+
+	// the rest is inserted by Close
+	if err := inserter.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if recorded.count() != 3 || recorded.batches[2][0].Name != "f" {
+		t.Errorf("expect the rest to be inserted, got %v", recorded.batches)
+	}
+}
+
+func Test_BufferedInserter_Interval(t *testing.T) {
+	recorded := &recordedInserts{err: errors.New("insert failed")}
+	failed := make(chan []*Test, 1)
+	inserter := newBufferedInserter[*Test, SObjectId](100, 10*time.Millisecond, recorded.insert).
+		OnError(func(docs []*Test, err error) {
+			failed <- docs
+		})
+	defer inserter.Close(context.Background())
+
+	inserter.Add(&Test{Name: "a"})
+	select {
+	case docs := <-failed:
+		if len(docs) != 1 || docs[0].Name != "a" {
+			t.Errorf("expect the failed documents, got %v", docs)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect the interval to flush")
+	}
+}
+
+func Test_BufferedInserter(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Test, SObjectId](&Test{}, c.Database("test"))
+	ctx := context.Background()
+
+	inserter := col.BufferedInserter(10, time.Minute)
+	name := NewSObjectId().ToString()
+	for i := 0; i < 5; i++ {
+		inserter.Add(&Test{Name: name})
+	}
+	if err := inserter.Close(ctx); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	count, err := col.Count(ctx, Cond().Eq("Name", name))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if count != 5 {
+		t.Errorf("expect 5 inserted, got %d", count)
+	}
+}
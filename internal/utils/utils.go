@@ -66,7 +66,7 @@ func tryMapToObjectId(value reflect.Value) any {
 }
 
 func LowerFirst(s string) string {
-	if len(s) > 1 {
+	if len(s) > 0 {
 		return strings.ToLower(s[0:1]) + s[1:]
 	}
 
@@ -104,3 +104,17 @@ func ParseTagSetting(str string, sep string) map[string]string {
 
 	return settings
 }
+
+// ParseTagOptions 解析 key=value,key2=value2 格式的tag, 没有值的key对应空字符串
+func ParseTagOptions(tag string) map[string]string {
+	settings := map[string]string{}
+	for _, option := range strings.Split(tag, ",") {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(option, "=")
+		settings[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return settings
+}
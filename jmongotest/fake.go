@@ -0,0 +1,279 @@
+package jmongotest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/JackWSK/jmongo"
+	"github.com/JackWSK/jmongo/entity"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"reflect"
+	"sync"
+)
+
+// FakeCollection in memory jmongo.CollectionAPI for tests.
+// Filters can be nil (all documents), an id, or bson.M of equalities on db names,
+// the models are stored and returned as they are, not copied
+type FakeCollection[MODEL any, ID any] struct {
+	mutex  sync.Mutex
+	schema *entity.Entity
+	models []MODEL
+}
+
+var _ jmongo.CollectionAPI[any, any] = (*FakeCollection[any, any])(nil)
+
+func NewFakeCollection[MODEL any, ID any](model MODEL) *FakeCollection[MODEL, ID] {
+	schema, err := entity.GetOrParse(model)
+	if err != nil {
+		panic(err)
+	}
+	return &FakeCollection[MODEL, ID]{schema: schema}
+}
+
+func (th *FakeCollection[MODEL, ID]) FindOneById(ctx context.Context, id ID, opts ...*jmongo.FindOption) (MODEL, error) {
+	return th.FindOneByFilter(ctx, bson.M{th.schema.IdDBName(): id})
+}
+
+func (th *FakeCollection[MODEL, ID]) FindOneByFilter(ctx context.Context, filter any, opts ...*jmongo.FindOption) (MODEL, error) {
+	var out MODEL
+	models, err := th.Find(ctx, filter)
+	if err != nil || len(models) == 0 {
+		return out, err
+	}
+	return models[0], nil
+}
+
+func (th *FakeCollection[MODEL, ID]) Find(ctx context.Context, filter any, opts ...*jmongo.FindOption) ([]MODEL, error) {
+	th.mutex.Lock()
+	defer th.mutex.Unlock()
+
+	indexes, err := th.matches(filter, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []MODEL
+	for _, index := range indexes {
+		out = append(out, th.models[index])
+	}
+	return out, nil
+}
+
+func (th *FakeCollection[MODEL, ID]) Count(ctx context.Context, filter any, opts ...*jmongo.FindOption) (int64, error) {
+	models, err := th.Find(ctx, filter)
+	return int64(len(models)), err
+}
+
+func (th *FakeCollection[MODEL, ID]) Exists(ctx context.Context, filter any, opts ...*jmongo.FindOption) (bool, error) {
+	count, err := th.Count(ctx, filter)
+	return count > 0, err
+}
+
+func (th *FakeCollection[MODEL, ID]) InsertOne(ctx context.Context, model MODEL, opts ...*options.InsertOneOptions) error {
+	th.mutex.Lock()
+	defer th.mutex.Unlock()
+
+	_, err := th.insert(model)
+	return err
+}
+
+// InsertMany stop at the first failure like an ordered insert
+func (th *FakeCollection[MODEL, ID]) InsertMany(ctx context.Context, models []MODEL, opts ...*options.InsertManyOptions) ([]ID, error) {
+	th.mutex.Lock()
+	defer th.mutex.Unlock()
+
+	var ids []ID
+	for _, model := range models {
+		id, err := th.insert(model)
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (th *FakeCollection[MODEL, ID]) UpdateOneById(ctx context.Context, id ID, model MODEL, opts ...*options.UpdateOptions) (bool, error) {
+	count, err := th.update(bson.M{th.schema.IdDBName(): id}, model, false)
+	return count > 0, err
+}
+
+func (th *FakeCollection[MODEL, ID]) UpdateOne(ctx context.Context, filter any, model MODEL, opts ...*jmongo.UpdateOption) (int64, int64, error) {
+	count, err := th.update(filter, model, false)
+	return count, count, err
+}
+
+func (th *FakeCollection[MODEL, ID]) UpdateMany(ctx context.Context, filter any, model MODEL, opts ...*options.UpdateOptions) (int64, error) {
+	return th.update(filter, model, true)
+}
+
+func (th *FakeCollection[MODEL, ID]) DeleteOneById(ctx context.Context, id ID) (bool, error) {
+	return th.DeleteOne(ctx, bson.M{th.schema.IdDBName(): id})
+}
+
+func (th *FakeCollection[MODEL, ID]) DeleteOne(ctx context.Context, filter any) (bool, error) {
+	count, err := th.delete(filter, false)
+	return count > 0, err
+}
+
+func (th *FakeCollection[MODEL, ID]) Delete(ctx context.Context, filter any) (bool, error) {
+	count, err := th.delete(filter, true)
+	return count > 0, err
+}
+
+func (th *FakeCollection[MODEL, ID]) insert(model MODEL) (ID, error) {
+	var id ID
+	value := th.reflectValue(model)
+	idValue := th.schema.IdField.ReflectValueOf(value)
+	if idValue.IsZero() {
+		switch {
+		case idValue.Type() == reflect.TypeOf(primitive.ObjectID{}):
+			idValue.Set(reflect.ValueOf(primitive.NewObjectID()))
+		case idValue.Kind() == reflect.String:
+			idValue.SetString(primitive.NewObjectID().Hex())
+		}
+	}
+
+	existing, err := th.matches(bson.M{th.schema.IdDBName(): idValue.Interface()}, false)
+	if err != nil {
+		return id, err
+	}
+	if len(existing) > 0 {
+		return id, errors.WithStack(fmt.Errorf("duplicate id %v", idValue.Interface()))
+	}
+
+	th.models = append(th.models, th.modelOf(model, value))
+	id, _ = idValue.Interface().(ID)
+	return id, nil
+}
+
+// copy non zero fields of model into the matched models
+func (th *FakeCollection[MODEL, ID]) update(filter any, model MODEL, multi bool) (int64, error) {
+	th.mutex.Lock()
+	defer th.mutex.Unlock()
+
+	indexes, err := th.matches(filter, multi)
+	if err != nil {
+		return 0, err
+	}
+
+	source := reflect.ValueOf(model)
+	for _, index := range indexes {
+		target := th.reflectValue(th.models[index])
+		for _, field := range th.schema.Fields {
+			if field.Id {
+				continue
+			}
+			object, zero := field.ValueOf(source)
+			if !zero {
+				field.ReflectValueOf(target).Set(reflect.ValueOf(object))
+			}
+		}
+		th.models[index] = th.modelOf(th.models[index], target)
+	}
+	return int64(len(indexes)), nil
+}
+
+func (th *FakeCollection[MODEL, ID]) delete(filter any, multi bool) (int64, error) {
+	th.mutex.Lock()
+	defer th.mutex.Unlock()
+
+	indexes, err := th.matches(filter, multi)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := map[int]bool{}
+	for _, index := range indexes {
+		removed[index] = true
+	}
+	models := th.models[:0]
+	for index, model := range th.models {
+		if !removed[index] {
+			models = append(models, model)
+		}
+	}
+	th.models = models
+	return int64(len(indexes)), nil
+}
+
+// indexes of the models matched by filter
+func (th *FakeCollection[MODEL, ID]) matches(filter any, multi bool) ([]int, error) {
+	query, err := th.toQuery(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var indexes []int
+	for index, model := range th.models {
+		ok, err := th.match(model, query)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			indexes = append(indexes, index)
+			if !multi {
+				break
+			}
+		}
+	}
+	return indexes, nil
+}
+
+func (th *FakeCollection[MODEL, ID]) toQuery(filter any) (bson.M, error) {
+	switch v := filter.(type) {
+	case nil:
+		return bson.M{}, nil
+	case bson.M:
+		return v, nil
+	case ID:
+		return bson.M{th.schema.IdDBName(): v}, nil
+	}
+	return nil, errors.WithStack(fmt.Errorf("fake collection does not support filter %T", filter))
+}
+
+// compare the encoded values, so the model matches the same way as it is stored
+func (th *FakeCollection[MODEL, ID]) match(model MODEL, query bson.M) (bool, error) {
+	doc, err := bson.MarshalWithRegistry(jmongo.DefaultRegistry, model)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	for key, expect := range query {
+		actual, err := bson.Raw(doc).LookupErr(key)
+		if err != nil {
+			return false, nil
+		}
+
+		t, data, err := bson.MarshalValueWithRegistry(jmongo.DefaultRegistry, expect)
+		if err != nil {
+			return false, errors.WithStack(err)
+		}
+		if actual.Type != t || !bytes.Equal(actual.Value, data) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// addressable value of the model, so its fields can be set
+// modelOf the model to keep after value of reflectValue(model) is set, the addressable copy of a value model
+func (th *FakeCollection[MODEL, ID]) modelOf(model MODEL, value reflect.Value) MODEL {
+	if reflect.ValueOf(model).Kind() == reflect.Ptr {
+		return model
+	}
+	return value.Elem().Interface().(MODEL)
+}
+
+func (th *FakeCollection[MODEL, ID]) reflectValue(model MODEL) reflect.Value {
+	value := reflect.ValueOf(model)
+	if value.Kind() == reflect.Ptr {
+		return value
+	}
+	pointer := reflect.New(value.Type())
+	pointer.Elem().Set(value)
+	return pointer
+}
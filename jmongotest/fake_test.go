@@ -0,0 +1,94 @@
+package jmongotest
+
+import (
+	"context"
+	"github.com/JackWSK/jmongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"testing"
+)
+
+type User struct {
+	Id    jmongo.SObjectId `bson:"_id,omitempty"`
+	Name  string           `bson:"name"`
+	Email string           `bson:"email"`
+}
+
+type userService struct {
+	users jmongo.CollectionAPI[*User, jmongo.SObjectId]
+}
+
+func (th *userService) Rename(ctx context.Context, id jmongo.SObjectId, name string) error {
+	_, err := th.users.UpdateOneById(ctx, id, &User{Name: name})
+	return err
+}
+
+func Test_FakeCollection_Service(t *testing.T) {
+	ctx := context.Background()
+
+	// the real collection and the fake are interchangeable
+	client, err := jmongo.NewClient(options.Client().ApplyURI("mongodb://localhost:27017"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := &userService{users: jmongo.NewCollection[*User, jmongo.SObjectId](&User{}, client.Database("test"))}
+
+	fake := NewFakeCollection[*User, jmongo.SObjectId](&User{})
+	service.users = fake
+
+	user := &User{Name: "jack", Email: "jack@example.com"}
+	if err := fake.InsertOne(ctx, user); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if user.Id == "" {
+		t.Fatal("expect id to be generated")
+	}
+
+	if err := service.Rename(ctx, user.Id, "rose"); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	found, err := fake.FindOneById(ctx, user.Id)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if found == nil || found.Name != "rose" || found.Email != "jack@example.com" {
+		t.Errorf("unexpected user %+v", found)
+	}
+
+	count, err := fake.Count(ctx, map[string]any{"name": "rose"})
+	if err == nil {
+		t.Errorf("expect unsupported filter error, got count %d", count)
+	}
+
+	deleted, err := fake.DeleteOneById(ctx, user.Id)
+	if err != nil || !deleted {
+		t.Fatalf("expect user to be deleted, %+v", err)
+	}
+	if exists, _ := fake.Exists(ctx, user.Id); exists {
+		t.Error("expect user to be gone")
+	}
+}
+
+func Test_FakeCollection_ValueModel(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFakeCollection[User, jmongo.SObjectId](User{})
+
+	ids, err := fake.InsertMany(ctx, []User{{Name: "jack"}})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(ids) != 1 || ids[0] == "" {
+		t.Fatalf("expect the id to be generated, got %v", ids)
+	}
+	if _, err := fake.UpdateOneById(ctx, ids[0], User{Name: "rose"}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	found, err := fake.FindOneById(ctx, ids[0])
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if found.Id != ids[0] || found.Name != "rose" {
+		t.Errorf("expect the stored copy with the generated id and the update, got %+v", found)
+	}
+}
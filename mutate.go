@@ -0,0 +1,86 @@
+package jmongo
+
+import (
+	"context"
+	"github.com/JackWSK/jmongo/errortype"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"reflect"
+)
+
+// mutateAttempts the load-edit-save cycles of Mutate before it gives up
+const mutateAttempts = 10
+
+// Mutate load the document of id, let fn edit it and save every field, only if no one saved the document in between.
+// Saves are told apart by the field tagged jmongo:"version", which each save increases, on a conflict the whole cycle
+// runs again on the new document, so fn may run several times and must only depend on the document.
+// mongo.ErrNoDocuments is returned when the document does not exist, errortype.ErrVersionConflict when every attempt conflicts
+func (th *Collection[MODEL, ID]) Mutate(ctx context.Context, id ID, fn func(doc MODEL) error) error {
+	field := th.schema.VersionField
+	if field == nil {
+		return errors.Errorf("%s has no field tagged jmongo:\"version\" for Mutate", th.schema.Name)
+	}
+
+	load := func() (MODEL, error) {
+		return th.FindOneById(ctx, id)
+	}
+	save := func(doc MODEL, version int64) (bool, error) {
+		filter, err := th.preconditionFilter(id, versionCondition(field.DBName, version))
+		if err != nil {
+			return false, err
+		}
+		result, err := th.doUpdate(ctx, filter, doc, false, th.mapAllToUpdate, nil)
+		if err != nil {
+			return false, err
+		}
+		return result.MatchedCount > 0, nil
+	}
+	return th.mutate(ctx, fn, load, save)
+}
+
+// mutate run the load-edit-save cycles of Mutate, save stores the edited document only if it is still at version
+// and reports whether it did
+func (th *Collection[MODEL, ID]) mutate(ctx context.Context, fn func(doc MODEL) error, load func() (MODEL, error), save func(doc MODEL, version int64) (bool, error)) error {
+	field := th.schema.VersionField
+	for attempt := 0; attempt < mutateAttempts; attempt++ {
+		doc, err := load()
+		if err != nil {
+			return err
+		}
+		// fn edits the document through the pointer, models are pointers
+		value := reflect.ValueOf(doc)
+		if value.Kind() != reflect.Ptr {
+			return errors.Errorf("Mutate needs a pointer model, got %T", doc)
+		}
+		if value.IsNil() {
+			return errors.WithStack(mongo.ErrNoDocuments)
+		}
+
+		version := field.ReflectValueOf(value).Int()
+		if err := fn(doc); err != nil {
+			return err
+		}
+		field.ReflectValueOf(value).SetInt(version + 1)
+
+		saved, err := save(doc, version)
+		if err != nil {
+			return err
+		}
+		if saved {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return errors.WithStack(errortype.ErrVersionConflict)
+}
+
+// versionCondition match the document still at version, documents inserted without the field are at version 0
+func versionCondition(dbName string, version int64) bson.M {
+	if version == 0 {
+		return bson.M{dbName: bson.M{"$in": bson.A{0, nil}}}
+	}
+	return bson.M{dbName: version}
+}
@@ -0,0 +1,165 @@
+package jmongo
+
+import (
+	"context"
+	"errors"
+	"github.com/JackWSK/jmongo/entity"
+	"github.com/JackWSK/jmongo/errortype"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type Wallet struct {
+	Id      SObjectId `bson:"_id,omitempty"`
+	Balance int       `bson:"balance"`
+	Version int64     `bson:"version" jmongo:"version"`
+}
+
+func Test_VersionCondition(t *testing.T) {
+	if condition := versionCondition("version", 3); !reflect.DeepEqual(condition, bson.M{"version": int64(3)}) {
+		t.Errorf("unexpected condition %v", condition)
+	}
+	expect := bson.M{"version": bson.M{"$in": bson.A{0, nil}}}
+	if condition := versionCondition("version", 0); !reflect.DeepEqual(condition, expect) {
+		t.Errorf("expect documents without version to match, got %v", condition)
+	}
+
+	type BadVersion struct {
+		Id      SObjectId `bson:"_id"`
+		Version string    `bson:"version" jmongo:"version"`
+	}
+	if _, err := entity.GetOrParse(&BadVersion{}); err == nil {
+		t.Error("expect error for a version which is not an integer")
+	}
+
+	if err := newOfflineCollection(t).Mutate(context.Background(), NewSObjectId(), func(doc *Test) error { return nil }); err == nil {
+		t.Error("expect error for a model without version")
+	}
+}
+
+// walletStore keeps one wallet in memory and saves it only at the expected version, as the filter of Mutate does
+type walletStore struct {
+	mutex  sync.Mutex
+	wallet *Wallet
+	saves  int
+}
+
+func (th *walletStore) load() (*Wallet, error) {
+	th.mutex.Lock()
+	defer th.mutex.Unlock()
+	if th.wallet == nil {
+		return nil, nil
+	}
+	wallet := *th.wallet
+	return &wallet, nil
+}
+
+func (th *walletStore) save(doc *Wallet, version int64) (bool, error) {
+	th.mutex.Lock()
+	defer th.mutex.Unlock()
+	th.saves++
+	if th.wallet.Version != version {
+		return false, nil
+	}
+	wallet := *doc
+	th.wallet = &wallet
+	return true, nil
+}
+
+// deposit save amount as another writer would
+func (th *walletStore) deposit(amount int) {
+	th.mutex.Lock()
+	defer th.mutex.Unlock()
+	th.wallet.Balance += amount
+	th.wallet.Version++
+}
+
+func Test_Mutate_Conflict(t *testing.T) {
+	col := NewCollection[*Wallet, SObjectId](&Wallet{}, newOfflineDatabase(t))
+	ctx := context.Background()
+
+	// another writer saves between the first load and its save, the cycle runs again on its document
+	store := &walletStore{wallet: &Wallet{Id: NewSObjectId()}}
+	runs := 0
+	err := col.mutate(ctx, func(doc *Wallet) error {
+		runs++
+		if runs == 1 {
+			store.deposit(5)
+		}
+		doc.Balance += 10
+		return nil
+	}, store.load, store.save)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if runs != 2 || store.saves != 2 {
+		t.Errorf("expect fn to run again after the conflict, got %d runs and %d saves", runs, store.saves)
+	}
+	if store.wallet.Balance != 15 || store.wallet.Version != 2 {
+		t.Errorf("expect both writes kept, got balance %d at version %d", store.wallet.Balance, store.wallet.Version)
+	}
+
+	// every attempt conflicts
+	store = &walletStore{wallet: &Wallet{Id: NewSObjectId()}}
+	err = col.mutate(ctx, func(doc *Wallet) error {
+		store.deposit(1)
+		return nil
+	}, store.load, store.save)
+	if !errors.Is(err, errortype.ErrVersionConflict) || store.saves != mutateAttempts {
+		t.Errorf("expect ErrVersionConflict after %d saves, got %v after %d", mutateAttempts, err, store.saves)
+	}
+
+	// nothing is saved when the document does not exist or fn fails
+	store = &walletStore{}
+	err = col.mutate(ctx, func(doc *Wallet) error { return nil }, store.load, store.save)
+	if !errors.Is(err, mongo.ErrNoDocuments) || store.saves != 0 {
+		t.Errorf("expect ErrNoDocuments without saving, got %v after %d saves", err, store.saves)
+	}
+	failure := errors.New("insufficient balance")
+	store = &walletStore{wallet: &Wallet{Id: NewSObjectId()}}
+	err = col.mutate(ctx, func(doc *Wallet) error { return failure }, store.load, store.save)
+	if !errors.Is(err, failure) || store.saves != 0 {
+		t.Errorf("expect the error of fn without saving, got %v after %d saves", err, store.saves)
+	}
+}
+
+func Test_Mutate_Concurrent(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Wallet, SObjectId](&Wallet{}, c.Database("test"))
+	ctx := context.Background()
+
+	wallet := &Wallet{Id: NewSObjectId()}
+	if err := col.InsertOne(ctx, wallet); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = col.Mutate(ctx, wallet.Id, func(doc *Wallet) error {
+				doc.Balance += 10
+				return nil
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	found, err := col.FindOneById(ctx, wallet.Id)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if found.Balance != 20 || found.Version != 2 {
+		t.Errorf("expect both mutations saved, got balance %d at version %d", found.Balance, found.Version)
+	}
+}
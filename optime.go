@@ -0,0 +1,76 @@
+package jmongo
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/event"
+	"sync"
+)
+
+// commands whose operationTime is recorded
+var writeCommands = map[string]bool{"insert": true, "update": true, "delete": true, "findAndModify": true}
+
+// operationTimes the latest operationTime of the writes to every namespace, recorded by the command monitor
+type operationTimes struct {
+	// request id -> namespace of the write commands in flight
+	pending sync.Map
+	mutex   sync.Mutex
+	times   map[string]primitive.Timestamp
+}
+
+// monitor record the operationTime of write replies, then call next, the monitor of the caller
+func (th *operationTimes) monitor(next *event.CommandMonitor) *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			if writeCommands[e.CommandName] {
+				// the collection is the value of the first element, e.g. {insert: "tests", ...}
+				if element, err := e.Command.IndexErr(0); err == nil {
+					if name, ok := element.Value().StringValueOK(); ok {
+						th.pending.Store(e.RequestID, e.DatabaseName+"."+name)
+					}
+				}
+			}
+			if next != nil && next.Started != nil {
+				next.Started(ctx, e)
+			}
+		},
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			if namespace, ok := th.pending.LoadAndDelete(e.RequestID); ok {
+				// only replica sets and sharded clusters reply the operationTime
+				if t, i, ok := e.Reply.Lookup("operationTime").TimestampOK(); ok {
+					th.advance(namespace.(string), primitive.Timestamp{T: t, I: i})
+				}
+			}
+			if next != nil && next.Succeeded != nil {
+				next.Succeeded(ctx, e)
+			}
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			th.pending.Delete(e.RequestID)
+			if next != nil && next.Failed != nil {
+				next.Failed(ctx, e)
+			}
+		},
+	}
+}
+
+// advance keep the greater operation time of the namespace, replies of concurrent writes come in any order
+func (th *operationTimes) advance(namespace string, ts primitive.Timestamp) {
+	th.mutex.Lock()
+	defer th.mutex.Unlock()
+	if th.times == nil {
+		th.times = map[string]primitive.Timestamp{}
+	}
+	if primitive.CompareTimestamp(ts, th.times[namespace]) > 0 {
+		th.times[namespace] = ts
+	}
+}
+
+func (th *operationTimes) load(namespace string) primitive.Timestamp {
+	if th == nil {
+		return primitive.Timestamp{}
+	}
+	th.mutex.Lock()
+	defer th.mutex.Unlock()
+	return th.times[namespace]
+}
@@ -0,0 +1,73 @@
+package jmongo
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"testing"
+)
+
+func Test_LastOperationTime(t *testing.T) {
+	col := newOfflineCollection(t)
+	namespace := col.collection.Database().Name() + "." + col.collection.Name()
+
+	started := 0
+	monitor := col.client.operationTimes.monitor(&event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			started++
+		},
+	})
+
+	write := func(requestID int64, command string, ts primitive.Timestamp) {
+		raw, _ := bson.Marshal(bson.D{{Key: command, Value: col.collection.Name()}})
+		monitor.Started(context.Background(), &event.CommandStartedEvent{Command: raw, DatabaseName: col.collection.Database().Name(), CommandName: command, RequestID: requestID})
+		reply, _ := bson.Marshal(bson.D{{Key: "ok", Value: 1}, {Key: "operationTime", Value: ts}})
+		monitor.Succeeded(context.Background(), &event.CommandSucceededEvent{
+			CommandFinishedEvent: event.CommandFinishedEvent{CommandName: command, RequestID: requestID},
+			Reply:                reply,
+		})
+	}
+
+	write(1, "insert", primitive.Timestamp{T: 100, I: 2})
+	// replies of reads and older writes are ignored
+	write(2, "find", primitive.Timestamp{T: 200, I: 1})
+	write(3, "update", primitive.Timestamp{T: 100, I: 1})
+
+	if started != 3 {
+		t.Errorf("expect the monitor of the caller to be called, got %d", started)
+	}
+	if ts := col.LastOperationTime(); !ts.Equal(primitive.Timestamp{T: 100, I: 2}) {
+		t.Errorf("unexpected operation time %v of %s", ts, namespace)
+	}
+}
+
+func Test_AfterClusterTime(t *testing.T) {
+	col := newOfflineCollection(t)
+	ctx := context.Background()
+	if err := col.client.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = col.client.Client().Disconnect(ctx)
+	}()
+
+	ts := primitive.Timestamp{T: 100, I: 2}
+	option := Merge([]*FindOption{Option().Limit(1), Option().AfterClusterTime(ts)})
+	readCtx, end, err := col.withClusterTime(ctx, option)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer end()
+
+	session := mongo.SessionFromContext(readCtx)
+	if session == nil || session.OperationTime() == nil || !session.OperationTime().Equal(ts) {
+		t.Fatalf("expect the read in a session after %v", ts)
+	}
+
+	// reads without the option keep ctx
+	if readCtx, _, _ := col.withClusterTime(ctx, Option().Limit(1)); mongo.SessionFromContext(readCtx) != nil {
+		t.Error("expect no session")
+	}
+}
@@ -1,29 +1,53 @@
 package jmongo
 
 import (
-	"fmt"
 	"github.com/JackWSK/jmongo/entity"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
 )
 
 // Sort 排序
 type Sort struct {
 	Field string
 	Asc   bool
+	// Field is the name in mongo, e.g. a nested path, which is not resolved by the model
+	dbName bool
 }
 
 type FindOption struct {
-	skip        int
-	limit       int
-	total       *int64
-	includes    []string
-	excludes    []string
-	sorts       []*Sort
-	findOneOpts []*options.FindOneOptions
-	findOpts    []*options.FindOptions
+	skip      int
+	limit     int
+	noLimit   bool
+	total     *int64
+	includes  []string
+	excludes  []string
+	excludeId bool
+	// only for operations returning a cursor
+	noCursorTimeout bool
+	// server side time budget, see MaxTime
+	maxTime time.Duration
+	// only for FindOneAndUpdate
+	returnAfter bool
+	// FindOneAndUpdate and UpdateOne
+	upsert bool
+	// only for UpdateOne, see SetZeroFields
+	setZeroFields bool
+	// return the documents decoded before ctx is done with the error
+	keepPartialOnCancel bool
+	// read the soft deleted documents too
+	includeDeleted bool
+	// filled by Find, see WithStats
+	stats *QueryStats
+	sorts []*Sort
+	// read after the operation time, see AfterClusterTime
+	afterClusterTime *primitive.Timestamp
+	findOneOpts      []*options.FindOneOptions
+	findOpts         []*options.FindOptions
+	countOpts        []*options.CountOptions
+	updateOpts       []*options.UpdateOptions
 }
 
 func Option() *FindOption {
@@ -40,24 +64,128 @@ func (th *FindOption) Limit(limit int) *FindOption {
 	return th
 }
 
+// NoLimit read every document matched even when the collection caps finds without a limit,
+// see Collection.MaxUnboundedLimit
+func (th *FindOption) NoLimit() *FindOption {
+	th.noLimit = true
+	return th
+}
+
 func (th *FindOption) WithTotal(total *int64) *FindOption {
 	th.total = total
 	return th
 }
 
+// WithStats fill stats with the duration and the number of documents of Find, nothing is measured without it
+func (th *FindOption) WithStats(stats *QueryStats) *FindOption {
+	th.stats = stats
+	return th
+}
+
 // AddIncludes 要选择的属性，注意用模型定义的属性名字，而不是
+// mongo always returns _id with the includes, call ExcludeID to drop it
 func (th *FindOption) AddIncludes(includes ...string) *FindOption {
 	th.includes = append(th.includes, includes...)
 	return th
 }
 
-// AddExcludes 不选择的属性
+// OnlyFields select only the fields, by the names of the model, same as AddIncludes
+func (th *FindOption) OnlyFields(fields ...string) *FindOption {
+	return th.AddIncludes(fields...)
+}
+
+// AddExcludes 不选择的属性, mongo does not mix them with includes, except the id
 func (th *FindOption) AddExcludes(excludes ...string) *FindOption {
 	th.excludes = append(th.excludes, excludes...)
 	return th
 }
 
-// AddOrder 排序
+// ExcludeID drop _id from the result, e.g. AddIncludes("Name").ExcludeID() selects {name: 1, _id: 0}
+func (th *FindOption) ExcludeID() *FindOption {
+	th.excludeId = true
+	return th
+}
+
+// NoCursorTimeout keep the cursor of Find alive on the server even when it is idle for long,
+// e.g. iterating a huge collection slowly. The server never reclaims such a cursor,
+// so it leaks until it is exhausted or closed, always close the cursor
+func (th *FindOption) NoCursorTimeout() *FindOption {
+	th.noCursorTimeout = true
+	return th
+}
+
+// MaxTime let the server stop the find after d, instead of the time left before the deadline of ctx
+func (th *FindOption) MaxTime(d time.Duration) *FindOption {
+	th.maxTime = d
+	return th
+}
+
+// ReturnAfter let FindOneAndUpdate return the document after the update instead of the original
+func (th *FindOption) ReturnAfter(after bool) *FindOption {
+	th.returnAfter = after
+	return th
+}
+
+// Upsert let FindOneAndUpdate and UpdateOne insert a document when nothing matches
+func (th *FindOption) Upsert(upsert bool) *FindOption {
+	th.upsert = upsert
+	return th
+}
+
+// SetZeroFields let UpdateOne $set the zero fields of the model too, e.g. a count back to 0 or a name to "",
+// the id and the create time are still kept
+func (th *FindOption) SetZeroFields() *FindOption {
+	th.setZeroFields = true
+	return th
+}
+
+// KeepPartialOnCancel return the documents decoded before ctx is cancelled or timed out, by its deadline
+// or the timeout of the collection, together with the error of ctx,
+// instead of discarding them, e.g. a best effort export. Check the error, the result is incomplete
+func (th *FindOption) KeepPartialOnCancel() *FindOption {
+	th.keepPartialOnCancel = true
+	return th
+}
+
+// IncludeDeleted read the soft deleted documents too, which Find, FindOneByFilter, FindWithTotal, Count, Exists
+// and FindOneAndUpdate of a model with a jmongo:"softDelete" field exclude
+func (th *FindOption) IncludeDeleted() *FindOption {
+	th.includeDeleted = true
+	return th
+}
+
+// AfterClusterTime read data at least as new as ts, e.g. the Collection.LastOperationTime of a write
+// in another service, the read runs in a causally consistent session
+func (th *FindOption) AfterClusterTime(ts primitive.Timestamp) *FindOption {
+	th.afterClusterTime = &ts
+	return th
+}
+
+// FindOptions driver options of Find, the options set by FindOption take precedence
+func (th *FindOption) FindOptions(opts ...*options.FindOptions) *FindOption {
+	th.findOpts = append(th.findOpts, opts...)
+	return th
+}
+
+// FindOneOptions driver options of FindOne, the options set by FindOption take precedence
+func (th *FindOption) FindOneOptions(opts ...*options.FindOneOptions) *FindOption {
+	th.findOneOpts = append(th.findOneOpts, opts...)
+	return th
+}
+
+// CountOptions driver options of Count and Exists, the skip and the limit set by FindOption take precedence
+func (th *FindOption) CountOptions(opts ...*options.CountOptions) *FindOption {
+	th.countOpts = append(th.countOpts, opts...)
+	return th
+}
+
+// UpdateOptions driver options of UpdateOne, e.g. a hint, the upsert set by FindOption takes precedence
+func (th *FindOption) UpdateOptions(opts ...*options.UpdateOptions) *FindOption {
+	th.updateOpts = append(th.updateOpts, opts...)
+	return th
+}
+
+// AddOrder 排序, call it again for more keys, which are sorted in the order they are added
 // - fieldName: 属性名字
 // - asc: 是否从小到大排序
 func (th *FindOption) AddOrder(fieldName string, asc bool) *FindOption {
@@ -68,6 +196,16 @@ func (th *FindOption) AddOrder(fieldName string, asc bool) *FindOption {
 	return th
 }
 
+// AddOrderByDBName 排序 by the name in mongo as it is, e.g. "address.city", call it again for more keys
+func (th *FindOption) AddOrderByDBName(dbName string, asc bool) *FindOption {
+	th.sorts = append(th.sorts, &Sort{
+		Field:  dbName,
+		Asc:    asc,
+		dbName: true,
+	})
+	return th
+}
+
 // Merge 复制options不存在的配置
 // 如果options中有属性与当前配置冲突,则使用当前配置
 func (th *FindOption) Merge(options []*FindOption) *FindOption {
@@ -100,10 +238,18 @@ func Merge(options []*FindOption) *FindOption {
 			current.limit = o.limit
 		}
 
+		if o.noLimit {
+			current.noLimit = true
+		}
+
 		if o.total != nil {
 			current.total = o.total
 		}
 
+		if o.stats != nil {
+			current.stats = o.stats
+		}
+
 		if o.excludes != nil {
 			current.excludes = append(current.excludes, o.excludes...)
 		}
@@ -112,14 +258,80 @@ func Merge(options []*FindOption) *FindOption {
 			current.includes = append(current.includes, o.includes...)
 		}
 
+		if o.excludeId {
+			current.excludeId = true
+		}
+
+		if o.noCursorTimeout {
+			current.noCursorTimeout = true
+		}
+
+		if o.maxTime > 0 {
+			current.maxTime = o.maxTime
+		}
+
+		if o.returnAfter {
+			current.returnAfter = true
+		}
+
+		if o.upsert {
+			current.upsert = true
+		}
+
+		if o.setZeroFields {
+			current.setZeroFields = true
+		}
+
+		if o.keepPartialOnCancel {
+			current.keepPartialOnCancel = true
+		}
+
+		if o.includeDeleted {
+			current.includeDeleted = true
+		}
+
 		if o.sorts != nil {
 			current.sorts = append(current.sorts, o.sorts...)
 		}
+
+		if o.afterClusterTime != nil {
+			current.afterClusterTime = o.afterClusterTime
+		}
+
+		current.findOneOpts = append(current.findOneOpts, o.findOneOpts...)
+		current.findOpts = append(current.findOpts, o.findOpts...)
+		current.countOpts = append(current.countOpts, o.countOpts...)
+		current.updateOpts = append(current.updateOpts, o.updateOpts...)
 	}
 
 	return current
 }
 
+// includeDeleted whether option reads the soft deleted documents too
+func includeDeleted(option *FindOption) bool {
+	return option != nil && option.includeDeleted
+}
+
+// keepPartial whether option keeps the documents on cancel
+func keepPartial(option *FindOption) bool {
+	return option != nil && option.keepPartialOnCancel
+}
+
+// countOptions driver options of a count by option, nil when option is nil
+func countOptions(option *FindOption) []*options.CountOptions {
+	if option == nil {
+		return nil
+	}
+	count := options.Count()
+	if option.skip > 0 {
+		count.SetSkip(int64(option.skip))
+	}
+	if option.limit > 0 {
+		count.SetLimit(int64(option.limit))
+	}
+	return append(append([]*options.CountOptions{}, option.countOpts...), count)
+}
+
 func (th *FindOption) makeFindOneOptions(schema *entity.Entity) ([]*options.FindOneOptions, error) {
 	option := options.FindOne()
 
@@ -128,6 +340,10 @@ func (th *FindOption) makeFindOneOptions(schema *entity.Entity) ([]*options.Find
 		option.SetSkip(int64(th.skip))
 	}
 
+	if th.maxTime > 0 {
+		option.SetMaxTime(th.maxTime)
+	}
+
 	// 设置projection
 	projection, err := th.makeProjection(schema, th.includes, th.excludes)
 	if err != nil {
@@ -146,8 +362,40 @@ func (th *FindOption) makeFindOneOptions(schema *entity.Entity) ([]*options.Find
 		option.SetSort(sort)
 	}
 
-	return []*options.FindOneOptions{option}, nil
+	return append(append([]*options.FindOneOptions{}, th.findOneOpts...), option), nil
+
+}
+
+func (th *FindOption) makeFindOneAndUpdateOptions(schema *entity.Entity) (*options.FindOneAndUpdateOptions, error) {
+	option := options.FindOneAndUpdate().SetUpsert(th.upsert)
+
+	if th.returnAfter {
+		option.SetReturnDocument(options.After)
+	}
+
+	if th.maxTime > 0 {
+		option.SetMaxTime(th.maxTime)
+	}
+
+	// 设置projection
+	projection, err := th.makeProjection(schema, th.includes, th.excludes)
+	if err != nil {
+		return nil, err
+	}
+	if len(projection) > 0 {
+		option.SetProjection(projection)
+	}
 
+	// 设置sort
+	sort, err := th.makeSort(schema, th.sorts)
+	if err != nil {
+		return nil, err
+	}
+	if len(sort) > 0 {
+		option.SetSort(sort)
+	}
+
+	return option, nil
 }
 
 func (th *FindOption) makeFindOption(schema *entity.Entity) ([]*options.FindOptions, error) {
@@ -163,6 +411,14 @@ func (th *FindOption) makeFindOption(schema *entity.Entity) ([]*options.FindOpti
 		option.SetLimit(int64(th.limit))
 	}
 
+	if th.noCursorTimeout {
+		option.SetNoCursorTimeout(true)
+	}
+
+	if th.maxTime > 0 {
+		option.SetMaxTime(th.maxTime)
+	}
+
 	// 设置projection
 	projection, err := th.makeProjection(schema, th.includes, th.excludes)
 	if err != nil {
@@ -182,22 +438,26 @@ func (th *FindOption) makeFindOption(schema *entity.Entity) ([]*options.FindOpti
 		option.SetSort(sort)
 	}
 
-	return []*options.FindOptions{option}, nil
+	return append(append([]*options.FindOptions{}, th.findOpts...), option), nil
 
 }
 
 func (th *FindOption) makeProjection(schema *entity.Entity, includes []string, excludes []string) (bson.D, error) {
 
-	if len(includes) == 0 && len(excludes) == 0 {
+	if len(includes) == 0 && len(excludes) == 0 && !th.excludeId {
 		return nil, nil
 	}
 
 	var projection bson.D
+	idExcluded := false
 
-	for _, include := range th.includes {
-		field := schema.LookUpField(include)
-		if field == nil {
-			return nil, errors.New(fmt.Sprintf("field %s not found in model %s", include, schema.Name))
+	for _, include := range includes {
+		field, err := schema.MustLookUpField(include)
+		if err != nil {
+			return nil, err
+		}
+		if th.excludeId && field.Id {
+			return nil, errors.Errorf("field %s is included but the id is excluded", include)
 		}
 
 		projection = append(projection, primitive.E{
@@ -206,10 +466,15 @@ func (th *FindOption) makeProjection(schema *entity.Entity, includes []string, e
 		})
 	}
 
-	for _, exclude := range th.excludes {
-		field := schema.LookUpField(exclude)
-		if field == nil {
-			return nil, errors.New(fmt.Sprintf("field %s not found in model %s", exclude, schema.Name))
+	for _, exclude := range excludes {
+		field, err := schema.MustLookUpField(exclude)
+		if err != nil {
+			return nil, err
+		}
+		if field.Id {
+			idExcluded = true
+		} else if len(includes) > 0 {
+			return nil, errors.Errorf("field %s is excluded but %v are included, only the id can be excluded with includes", exclude, includes)
 		}
 
 		projection = append(projection, primitive.E{
@@ -217,6 +482,14 @@ func (th *FindOption) makeProjection(schema *entity.Entity, includes []string, e
 			Value: 0,
 		})
 	}
+
+	// excluding the id by AddExcludes too writes it once
+	if th.excludeId && !idExcluded {
+		projection = append(projection, primitive.E{
+			Key:   schema.IdDBName(),
+			Value: 0,
+		})
+	}
 	return projection, nil
 }
 
@@ -224,17 +497,21 @@ func (th *FindOption) makeProjection(schema *entity.Entity, includes []string, e
 func (th *FindOption) makeSort(schema *entity.Entity, sorts []*Sort) (bson.D, error) {
 
 	var d bson.D = make([]primitive.E, len(sorts))
-	for index, sort := range th.sorts {
-		field := schema.LookUpField(sort.Field)
-		if field == nil {
-			return nil, errors.New(fmt.Sprintf("field %s not found in model %s", sort.Field, schema.Name))
+	for index, sort := range sorts {
+		key := sort.Field
+		if !sort.dbName {
+			field, err := schema.MustLookUpField(sort.Field)
+			if err != nil {
+				return nil, err
+			}
+			key = field.DBName
 		}
 		var asc = 1
 		if !sort.Asc {
 			asc = -1
 		}
 		d[index] = primitive.E{
-			Key:   field.DBName,
+			Key:   key,
 			Value: asc,
 		}
 	}
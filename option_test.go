@@ -0,0 +1,243 @@
+package jmongo
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_Option_ExcludeID(t *testing.T) {
+	schema := newOfflineCollection(t).schema
+
+	projection, err := Option().AddIncludes("Name").ExcludeID().makeProjection(schema, []string{"Name"}, nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 0}}
+	if !reflect.DeepEqual(projection, expect) {
+		t.Errorf("expect %v, got %v", expect, projection)
+	}
+
+	// the id excluded by both is written once
+	option := Option().AddExcludes("Id").ExcludeID()
+	projection, err = option.makeProjection(schema, nil, option.excludes)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(projection, bson.D{{Key: "_id", Value: 0}}) {
+		t.Errorf("unexpected projection %v", projection)
+	}
+
+	option = Option().AddIncludes("Id").ExcludeID()
+	if _, err := option.makeProjection(schema, option.includes, nil); err == nil {
+		t.Error("expect error when the id is both included and excluded")
+	}
+	// the fields given are projected, not the ones of the option
+	projection, err = Option().AddIncludes("Age").makeProjection(schema, []string{"Name"}, nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(projection, bson.D{{Key: "name", Value: 1}}) {
+		t.Errorf("expect the includes given, got %v", projection)
+	}
+}
+
+func Test_Option_Projection(t *testing.T) {
+	schema := newOfflineCollection(t).schema
+
+	findOpts, err := Option().OnlyFields("Name", "Age").makeFindOption(schema)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := bson.D{{Key: "name", Value: 1}, {Key: "happy", Value: 1}}
+	if projection := options.MergeFindOptions(findOpts...).Projection; !reflect.DeepEqual(projection, expect) {
+		t.Errorf("expect %v, got %v", expect, projection)
+	}
+
+	// only the id can be excluded with includes
+	if _, err := Option().OnlyFields("Name").AddExcludes("Id").makeFindOption(schema); err != nil {
+		t.Errorf("expect the id to be excluded with includes, got %v", err)
+	}
+	if _, err := Option().OnlyFields("Name").AddExcludes("Age").makeFindOption(schema); err == nil {
+		t.Error("expect error when includes and excludes are mixed")
+	}
+}
+
+func Test_Option_CompoundSort(t *testing.T) {
+	schema := newOfflineCollection(t).schema
+
+	option := Option().AddOrder("Age", false).AddOrder("Name", true).AddOrderByDBName("address.city", true)
+	sort, err := option.makeSort(schema, option.sorts)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := bson.D{{Key: "happy", Value: -1}, {Key: "name", Value: 1}, {Key: "address.city", Value: 1}}
+	if !reflect.DeepEqual(sort, expect) {
+		t.Errorf("expect %v, got %v", expect, sort)
+	}
+
+	option = Option().AddOrder("address.city", true)
+	if _, err := option.makeSort(schema, option.sorts); err == nil {
+		t.Error("expect error of the field not in the model")
+	}
+}
+
+func Test_Option_MaxTime(t *testing.T) {
+	schema := newOfflineCollection(t).schema
+	option := Merge([]*FindOption{Option().MaxTime(time.Second), Option().Limit(1)})
+
+	findOpts, err := option.makeFindOption(schema)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	// derived from the deadline of ctx, which comes first
+	derived := options.Find().SetMaxTime(time.Minute)
+	if maxTime := options.MergeFindOptions(append([]*options.FindOptions{derived}, findOpts...)...).MaxTime; maxTime == nil || *maxTime != time.Second {
+		t.Errorf("expect the max time of the option, got %v", maxTime)
+	}
+
+	findOneOpts, err := option.makeFindOneOptions(schema)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if maxTime := options.MergeFindOneOptions(findOneOpts...).MaxTime; maxTime == nil || *maxTime != time.Second {
+		t.Errorf("expect the max time of the option, got %v", maxTime)
+	}
+}
+
+func Test_DeadlineMaxTime(t *testing.T) {
+	if _, ok := deadlineMaxTime(context.Background()); ok {
+		t.Error("expect no max time without a deadline")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if maxTime, ok := deadlineMaxTime(ctx); !ok || maxTime <= 59*time.Second || maxTime > time.Minute {
+		t.Errorf("expect the time left before the deadline, got %v", maxTime)
+	}
+
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	if _, ok := deadlineMaxTime(ctx); ok {
+		t.Error("expect no max time after the deadline")
+	}
+}
+
+func Test_Option_FindOneAndUpdate(t *testing.T) {
+	schema := newOfflineCollection(t).schema
+
+	option := Merge([]*FindOption{Option().ReturnAfter(true).Upsert(true), Option().OnlyFields("Name").AddOrder("Age", false)})
+	opts, err := option.makeFindOneAndUpdateOptions(schema)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if opts.ReturnDocument == nil || *opts.ReturnDocument != options.After || opts.Upsert == nil || !*opts.Upsert {
+		t.Errorf("expect the updated document to be returned with upsert, got %+v", opts)
+	}
+	if !reflect.DeepEqual(opts.Projection, bson.D{{Key: "name", Value: 1}}) || !reflect.DeepEqual(opts.Sort, bson.D{{Key: "happy", Value: -1}}) {
+		t.Errorf("unexpected projection %v or sort %v", opts.Projection, opts.Sort)
+	}
+
+	opts, err = Option().makeFindOneAndUpdateOptions(schema)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if opts.ReturnDocument != nil || *opts.Upsert {
+		t.Errorf("expect the original document without upsert, got %+v", opts)
+	}
+}
+
+func Test_Option_NoCursorTimeout(t *testing.T) {
+	schema := newOfflineCollection(t).schema
+	option := Merge([]*FindOption{Option().Limit(10), Option().NoCursorTimeout()})
+
+	findOptions, err := option.makeFindOption(schema)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if findOptions[0].NoCursorTimeout == nil || !*findOptions[0].NoCursorTimeout {
+		t.Error("expect NoCursorTimeout on FindOptions")
+	}
+
+	// FindOne does not keep a cursor
+	findOneOptions, err := option.makeFindOneOptions(schema)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if findOneOptions[0].NoCursorTimeout != nil {
+		t.Error("expect no NoCursorTimeout on FindOneOptions")
+	}
+}
+
+func Test_Option_DriverOptions(t *testing.T) {
+	schema := newOfflineCollection(t).schema
+	option := Merge([]*FindOption{
+		Option().FindOptions(options.Find().SetBatchSize(10).SetLimit(5)),
+		Option().Limit(2),
+	})
+
+	findOptions, err := option.makeFindOption(schema)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	merged := options.MergeFindOptions(findOptions...)
+	if *merged.BatchSize != 10 || *merged.Limit != 2 {
+		t.Errorf("expect the driver options kept and the limit of FindOption to win, got %+v", merged)
+	}
+}
+
+func Test_Option_CountOptions(t *testing.T) {
+	if countOptions(nil) != nil {
+		t.Error("expect no count options without an option")
+	}
+
+	option := Merge([]*FindOption{
+		Option().CountOptions(options.Count().SetMaxTime(time.Second).SetLimit(5)),
+		Option().Offset(1).Limit(2),
+	})
+	merged := options.MergeCountOptions(countOptions(option)...)
+	if *merged.MaxTime != time.Second || *merged.Skip != 1 || *merged.Limit != 2 {
+		t.Errorf("expect the driver options kept and the window of FindOption to win, got %+v", merged)
+	}
+}
+
+func Test_Option_MaxUnboundedLimit(t *testing.T) {
+	col := newOfflineCollection(t).MaxUnboundedLimit(100)
+
+	capped := options.MergeFindOptions(col.capLimit(nil, nil)...)
+	if capped.Limit == nil || *capped.Limit != 100 {
+		t.Errorf("expect the find without a limit to be capped at 100, got %v", capped.Limit)
+	}
+
+	for _, option := range []*FindOption{Option().Limit(500), Option().NoLimit(), Merge([]*FindOption{Option().Offset(1), Option().NoLimit()})} {
+		findOpts, err := option.makeFindOption(col.schema)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if limit := options.MergeFindOptions(col.capLimit(option, findOpts)...).Limit; limit != nil && *limit == 100 {
+			t.Errorf("expect the explicit limit to be kept, got %d", *limit)
+		}
+	}
+
+	driverLimit := []*options.FindOptions{options.Find().SetLimit(1000)}
+	if limit := options.MergeFindOptions(col.capLimit(Option(), driverLimit)...).Limit; *limit != 1000 {
+		t.Errorf("expect the limit of the driver options to be kept, got %d", *limit)
+	}
+
+	if limit := options.MergeFindOptions(newOfflineCollection(t).capLimit(nil, nil)...).Limit; limit != nil {
+		t.Errorf("expect no cap by default, got %d", *limit)
+	}
+
+	// a lookup by ids is bounded by the ids, a chunk of them is not capped
+	option := Merge(byIdOptions([]*FindOption{Option().Offset(1)}))
+	findOpts, err := option.makeFindOption(col.schema)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if limit := options.MergeFindOptions(col.capLimit(option, findOpts)...).Limit; limit != nil {
+		t.Errorf("expect no cap of a lookup by ids, got %d", *limit)
+	}
+}
@@ -0,0 +1,200 @@
+package jmongo
+
+import (
+	"context"
+	"fmt"
+	"github.com/JackWSK/jmongo/entity"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"reflect"
+)
+
+// PopulateBy load the documents of foreign whose foreignField matches localField of docs by one $in query,
+// and set them to targetField of docs. targetField must be FOREIGN, which gets the first match,
+// or []FOREIGN, which gets all the matches. localField can be a slice, every element of it is matched.
+// Fields can be model field names or db names, targetField can also be a field skipped by bson:"-".
+// docs must be pointers
+func PopulateBy[MODEL any, ID any, FOREIGN any, FID any](ctx context.Context, col *Collection[MODEL, ID], docs []MODEL, localField string,
+	foreign *Collection[FOREIGN, FID], foreignField string, targetField string) error {
+
+	local, err := col.mustSchemaField(localField)
+	if err != nil {
+		return err
+	}
+	target, err := populateTarget(col.schema, targetField)
+	if err != nil {
+		return err
+	}
+	remote, err := foreign.mustSchemaField(foreignField)
+	if err != nil {
+		return err
+	}
+
+	foreignType := reflect.TypeOf((*FOREIGN)(nil)).Elem()
+	if target.Type != foreignType && target.Type != reflect.SliceOf(foreignType) {
+		return errors.WithStack(fmt.Errorf("target field %s must be %s or []%s", target.Name, foreignType, foreignType))
+	}
+
+	var values []any
+	seen := map[string]bool{}
+	for _, doc := range docs {
+		docValue := reflect.ValueOf(doc)
+		if docValue.Kind() != reflect.Ptr {
+			return errors.WithStack(fmt.Errorf("docs to populate must be pointers, got %s", docValue.Type()))
+		}
+		for _, value := range localValues(local, docValue) {
+			key, err := valueKey(value)
+			if err != nil {
+				return err
+			}
+			if !seen[key] {
+				seen[key] = true
+				values = append(values, value)
+			}
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	// bounded by the values, the documents of every one are read
+	foreigns, err := foreign.Find(ctx, bson.M{remote.DBName: bson.M{"$in": values}}, byIdOptions(nil)...)
+	if err != nil {
+		return err
+	}
+	return assignPopulated(docs, local, foreigns, remote, target)
+}
+
+// CountRelated count the documents of foreign whose foreignField matches each of localIDs by one aggregation,
+// e.g. the number of orders of every user in a list. Ids without a match count 0.
+// foreignField can be a model field name or a db name
+func CountRelated[K comparable, FOREIGN any, FID any](ctx context.Context, localIDs []K, foreign *Collection[FOREIGN, FID], foreignField string) (map[K]int64, error) {
+	counts := make(map[K]int64, len(localIDs))
+	if len(localIDs) == 0 {
+		return counts, nil
+	}
+
+	byKey := map[string]K{}
+	for _, id := range localIDs {
+		key, err := valueKey(id)
+		if err != nil {
+			return nil, err
+		}
+		byKey[key] = id
+		counts[id] = 0
+	}
+
+	pipeline, err := countRelatedPipeline(foreign, localIDs, foreignField)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []struct {
+		Value bson.RawValue `bson:"_id"`
+		Count int64         `bson:"n"`
+	}
+	err = foreign.Aggregate(ctx, pipeline, &results)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for _, result := range results {
+		key, err := valueKey(result.Value)
+		if err != nil {
+			return nil, err
+		}
+		if id, ok := byKey[key]; ok {
+			counts[id] = result.Count
+		}
+	}
+	return counts, nil
+}
+
+func countRelatedPipeline[K any, FOREIGN any, FID any](foreign *Collection[FOREIGN, FID], localIDs []K, foreignField string) (mongo.Pipeline, error) {
+	remote, err := foreign.mustSchemaField(foreignField)
+	if err != nil {
+		return nil, err
+	}
+
+	group := bson.D{{Key: "$group", Value: bson.D{
+		{Key: "_id", Value: "$" + remote.DBName},
+		{Key: "n", Value: bson.D{{Key: "$sum", Value: 1}}},
+	}}}
+	return foreign.Aggregation().Match(bson.M{remote.DBName: bson.M{"$in": localIDs}}).Stage(group).Build()
+}
+
+// assignPopulated set the foreigns matching the local values of every doc to the target field
+func assignPopulated[MODEL any, FOREIGN any](docs []MODEL, local *entity.EntityField, foreigns []FOREIGN, remote *entity.EntityField, target reflect.StructField) error {
+	byKey := map[string][]FOREIGN{}
+	for _, f := range foreigns {
+		value, _ := remote.ValueOf(reflect.ValueOf(f))
+		key, err := valueKey(value)
+		if err != nil {
+			return err
+		}
+		byKey[key] = append(byKey[key], f)
+	}
+
+	many := target.Type.Kind() == reflect.Slice
+	for _, doc := range docs {
+		docValue := reflect.ValueOf(doc)
+
+		var matches []FOREIGN
+		for _, value := range localValues(local, docValue) {
+			key, err := valueKey(value)
+			if err != nil {
+				return err
+			}
+			matches = append(matches, byKey[key]...)
+		}
+
+		targetValue := reflect.Indirect(docValue).FieldByIndex(target.Index)
+		switch {
+		case many:
+			targetValue.Set(reflect.ValueOf(matches))
+		case len(matches) > 0:
+			targetValue.Set(reflect.ValueOf(matches[0]))
+		}
+	}
+	return nil
+}
+
+// populateTarget the struct field to populate, looked up in the schema first,
+// then by go name, which allows fields not stored in the database
+func populateTarget(schema *entity.Entity, name string) (reflect.StructField, error) {
+	if field := schema.LookUpField(name); field != nil {
+		name = field.Name
+	}
+	if structField, ok := schema.ModelType.FieldByName(name); ok {
+		return structField, nil
+	}
+	return reflect.StructField{}, errors.WithStack(fmt.Errorf("target field %s can not be found in %s", name, schema.ModelType.Name()))
+}
+
+// values of the local field to match, the elements when it is a slice
+func localValues(field *entity.EntityField, docValue reflect.Value) []any {
+	value, zero := field.ValueOf(docValue)
+	if zero {
+		return nil
+	}
+
+	reflectValue := reflect.ValueOf(value)
+	if reflectValue.Kind() != reflect.Slice || field.FieldType.Elem().Kind() == reflect.Uint8 {
+		return []any{value}
+	}
+	values := make([]any, 0, reflectValue.Len())
+	for i := 0; i < reflectValue.Len(); i++ {
+		values = append(values, reflectValue.Index(i).Interface())
+	}
+	return values
+}
+
+// valueKey the encoded value, so values of different go types stored the same way are matched, e.g. SObjectId and ObjectID
+func valueKey(value any) (string, error) {
+	t, data, err := bson.MarshalValueWithRegistry(DefaultRegistry, value)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return string(rune(t)) + string(data), nil
+}
@@ -0,0 +1,145 @@
+package jmongo
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"reflect"
+	"testing"
+)
+
+type Buyer struct {
+	Id   SObjectId `bson:"_id,omitempty"`
+	Code string    `bson:"code"`
+	Name string    `bson:"name"`
+}
+
+type Purchase struct {
+	Id        SObjectId `bson:"_id,omitempty"`
+	BuyerCode string    `bson:"buyerCode"`
+	BuyerTags []string  `bson:"buyerTags"`
+	Buyer     *Buyer    `bson:"-"`
+	Buyers    []*Buyer  `bson:"-"`
+	Amount    int       `bson:"amount"`
+}
+
+func Test_AssignPopulated(t *testing.T) {
+	purchases := NewCollection[*Purchase, SObjectId](&Purchase{}, newOfflineDatabase(t))
+	buyers := NewCollection[*Buyer, SObjectId](&Buyer{}, newOfflineDatabase(t))
+
+	local, _ := purchases.mustSchemaField("buyerCode")
+	remote, _ := buyers.mustSchemaField("Code")
+	target, err := populateTarget(purchases.schema, "Buyer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs := []*Purchase{{BuyerCode: "c1"}, {BuyerCode: "c2"}, {BuyerCode: "c3"}}
+	foreigns := []*Buyer{{Code: "c1", Name: "alice"}, {Code: "c2", Name: "bob"}}
+	if err := assignPopulated(docs, local, foreigns, remote, target); err != nil {
+		t.Fatal(err)
+	}
+
+	if docs[0].Buyer == nil || docs[0].Buyer.Name != "alice" || docs[1].Buyer == nil || docs[1].Buyer.Name != "bob" {
+		t.Errorf("unexpected populated buyers %+v %+v", docs[0].Buyer, docs[1].Buyer)
+	}
+	if docs[2].Buyer != nil {
+		t.Errorf("expect no customer for c3, got %+v", docs[2].Buyer)
+	}
+
+	// a slice local field populates every element into a slice target
+	local, _ = purchases.mustSchemaField("buyerTags")
+	target, _ = populateTarget(purchases.schema, "Buyers")
+	docs = []*Purchase{{BuyerTags: []string{"c1", "c2"}}}
+	if err := assignPopulated(docs, local, foreigns, remote, target); err != nil {
+		t.Fatal(err)
+	}
+	if len(docs[0].Buyers) != 2 {
+		t.Errorf("expect 2 buyers, got %+v", docs[0].Buyers)
+	}
+}
+
+func Test_PopulateBy_InvalidTarget(t *testing.T) {
+	purchases := NewCollection[*Purchase, SObjectId](&Purchase{}, newOfflineDatabase(t))
+	buyers := NewCollection[*Buyer, SObjectId](&Buyer{}, newOfflineDatabase(t))
+
+	docs := []*Purchase{{BuyerCode: "c1"}}
+	if err := PopulateBy(context.Background(), purchases, docs, "buyerCode", buyers, "code", "amount"); err == nil {
+		t.Errorf("expect an error for a target field of another type")
+	}
+	if err := PopulateBy(context.Background(), purchases, docs, "buyerCode", buyers, "missing", "Buyer"); err == nil {
+		t.Errorf("expect an error for an unknown foreign field")
+	}
+}
+
+func Test_PopulateBy(t *testing.T) {
+	client := setupMongoClient(t, MongoUrl)
+	database := client.Database("test")
+	purchases := NewCollection[*Purchase, SObjectId](&Purchase{}, database)
+	buyers := NewCollection[*Buyer, SObjectId](&Buyer{}, database)
+
+	ctx := context.Background()
+	_, _ = buyers.Delete(ctx, nil)
+	if _, err := buyers.InsertMany(ctx, []*Buyer{{Code: "c1", Name: "alice"}, {Code: "c2", Name: "bob"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	docs := []*Purchase{{BuyerCode: "c1", Amount: 1}, {BuyerCode: "c2", Amount: 2}, {BuyerCode: "c1", Amount: 3}}
+	if err := PopulateBy(ctx, purchases, docs, "buyerCode", buyers, "code", "Buyer"); err != nil {
+		t.Fatal(err)
+	}
+	for _, doc := range docs {
+		if doc.Buyer == nil || doc.Buyer.Code != doc.BuyerCode {
+			t.Errorf("unexpected customer %+v for %s", doc.Buyer, doc.BuyerCode)
+		}
+	}
+}
+
+func Test_CountRelated_Pipeline(t *testing.T) {
+	purchases := NewCollection[*Purchase, SObjectId](&Purchase{}, newOfflineDatabase(t))
+
+	pipeline, err := countRelatedPipeline(purchases, []string{"c1", "c2"}, "BuyerCode")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"buyerCode": bson.M{"$in": []string{"c1", "c2"}}}}},
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$buyerCode"}, {Key: "n", Value: bson.D{{Key: "$sum", Value: 1}}}}}},
+	}
+	if !reflect.DeepEqual(pipeline, expect) {
+		t.Errorf("unexpected pipeline %v", pipeline)
+	}
+
+	// the group values read back match the ids they were queried by
+	id := NewSObjectId()
+	objectId, _ := primitive.ObjectIDFromHex(id.ToString())
+	bsonType, data, _ := bson.MarshalValue(objectId)
+	idKey, _ := valueKey(id)
+	rawKey, _ := valueKey(bson.RawValue{Type: bsonType, Value: data})
+	if idKey != rawKey {
+		t.Error("expect the group value to match the id")
+	}
+}
+
+func Test_CountRelated(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	purchases := NewCollection[*Purchase, SObjectId](&Purchase{}, c.Database("test"))
+	ctx := context.Background()
+
+	codes := []string{NewSObjectId().ToString(), NewSObjectId().ToString(), NewSObjectId().ToString()}
+	for _, code := range []string{codes[0], codes[0], codes[1]} {
+		if err := purchases.InsertOne(ctx, &Purchase{BuyerCode: code}); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+
+	counts, err := CountRelated(ctx, codes, purchases, "BuyerCode")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := map[string]int64{codes[0]: 2, codes[1]: 1, codes[2]: 0}
+	if !reflect.DeepEqual(counts, expect) {
+		t.Errorf("expect %v, got %v", expect, counts)
+	}
+}
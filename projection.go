@@ -0,0 +1,126 @@
+package jmongo
+
+import (
+	"github.com/JackWSK/jmongo/entity"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Projection build a $project stage, model fields can be model field name or db name and are resolved to db names,
+// computed fields and aliases are used as literal keys.
+// _id is always explicit: an inclusion projection keeps it unless ExcludeID is called
+type Projection struct {
+	items     []projectionItem
+	excludeId bool
+	err       error
+}
+
+type projectionItem struct {
+	// model field, empty for a computed field
+	field string
+	// output key, the db name of field when empty
+	alias   string
+	exclude bool
+	// output whether the field has a value instead of the value
+	exists bool
+	// expression of a computed field
+	expr any
+}
+
+// Project create a projection for AggregateBuilder.Project
+func Project() *Projection {
+	return &Projection{}
+}
+
+// Include keep the model field
+func (th *Projection) Include(field string) *Projection {
+	th.items = append(th.items, projectionItem{field: field})
+	return th
+}
+
+// As rename the output of the last included field
+func (th *Projection) As(alias string) *Projection {
+	if len(th.items) == 0 || th.items[len(th.items)-1].field == "" || th.items[len(th.items)-1].exclude {
+		if th.err == nil {
+			th.err = errors.Errorf("As(%s) must follow Include", alias)
+		}
+		return th
+	}
+	th.items[len(th.items)-1].alias = alias
+	return th
+}
+
+// Exclude drop the model field, can not be mixed with Include or Compute
+func (th *Projection) Exclude(field string) *Projection {
+	th.items = append(th.items, projectionItem{field: field, exclude: true})
+	return th
+}
+
+// Compute add a field named alias with the value of expr
+func (th *Projection) Compute(alias string, expr any) *Projection {
+	th.items = append(th.items, projectionItem{alias: alias, expr: expr})
+	return th
+}
+
+// HasValue add a boolean field named alias, true when the model field is neither missing nor null,
+// e.g. hasAvatar without fetching the avatar
+func (th *Projection) HasValue(alias string, field string) *Projection {
+	th.items = append(th.items, projectionItem{field: field, alias: alias, exists: true})
+	return th
+}
+
+// ExcludeID drop _id from the output
+func (th *Projection) ExcludeID() *Projection {
+	th.excludeId = true
+	return th
+}
+
+func (th *Projection) toStage(schema *entity.Entity) (bson.D, error) {
+	if th.err != nil {
+		return nil, th.err
+	}
+
+	var fields bson.D
+	inclusion, exclusion := false, false
+	for _, item := range th.items {
+		if item.field == "" {
+			inclusion = true
+			fields = append(fields, bson.E{Key: item.alias, Value: item.expr})
+			continue
+		}
+
+		field, err := schema.MustLookUpField(item.field)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case item.exists:
+			inclusion = true
+			// a missing field is not equal to null in expressions, so it is turned into null first
+			hasValue := bson.M{"$ne": bson.A{bson.M{"$ifNull": bson.A{"$" + field.DBName, nil}}, nil}}
+			fields = append(fields, bson.E{Key: item.alias, Value: hasValue})
+		case item.exclude:
+			exclusion = true
+			fields = append(fields, bson.E{Key: field.DBName, Value: 0})
+		case item.alias != "":
+			inclusion = true
+			fields = append(fields, bson.E{Key: item.alias, Value: "$" + field.DBName})
+		default:
+			inclusion = true
+			fields = append(fields, bson.E{Key: field.DBName, Value: 1})
+		}
+	}
+
+	if inclusion && exclusion {
+		return nil, errors.New("projection can not mix inclusion and exclusion")
+	}
+
+	switch {
+	case th.excludeId:
+		fields = append(bson.D{{Key: "_id", Value: 0}}, fields...)
+	case !exclusion:
+		fields = append(bson.D{{Key: "_id", Value: 1}}, fields...)
+	}
+	return fields, nil
+}
@@ -0,0 +1,314 @@
+package jmongo
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/JackWSK/jmongo/entity"
+	"github.com/JackWSK/jmongo/internal/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// DefaultRegistry registry applied to every collection created by NewCollection.
+// Aggregations such as $sum or $avg may return int32, int64, double or decimal depending on the inputs,
+// so numeric fields decode from any bson number type, an integer field only from a number without a fraction
+var DefaultRegistry = newRegistry(false)
+
+// aggregateRegistry decode the results of aggregations into other types than the model, as DefaultRegistry
+// but a double or a decimal decoded into an integer field drops its fraction, e.g. an $avg into an int
+var aggregateRegistry = newRegistry(true)
+
+func newRegistry(truncate bool) *bsoncodec.Registry {
+	base := bson.NewRegistryBuilder().Build()
+	builder := bson.NewRegistryBuilder()
+
+	numbers := []any{
+		int(0), int8(0), int16(0), int32(0), int64(0),
+		uint(0), uint8(0), uint16(0), uint32(0), uint64(0),
+		float32(0), float64(0),
+	}
+	for _, number := range numbers {
+		numberType := reflect.TypeOf(number)
+		fallback, err := base.LookupDecoder(numberType)
+		if err != nil {
+			panic(err)
+		}
+		encoder, err := base.LookupEncoder(numberType)
+		if err != nil {
+			panic(err)
+		}
+		builder.RegisterDefaultEncoder(numberType.Kind(), converterCodec{encoder: encoder})
+		builder.RegisterDefaultDecoder(numberType.Kind(), converterCodec{decoder: numberDecoder{fallback: fallback, truncate: truncate}})
+	}
+
+	// structs are encoded with the keys of the entity
+	structCodec, err := bsoncodec.NewStructCodec(structTagParser)
+	if err != nil {
+		panic(err)
+	}
+	builder.RegisterDefaultEncoder(reflect.Struct, converterCodec{encoder: structCodec})
+	builder.RegisterDefaultDecoder(reflect.Struct, converterCodec{decoder: structCodec})
+
+	// the other kinds a converter may be registered for
+	others := []any{false, "", []int{}, map[string]int{}}
+	for _, other := range others {
+		otherType := reflect.TypeOf(other)
+		encoder, err := base.LookupEncoder(otherType)
+		if err != nil {
+			panic(err)
+		}
+		decoder, err := base.LookupDecoder(otherType)
+		if err != nil {
+			panic(err)
+		}
+		builder.RegisterDefaultEncoder(otherType.Kind(), converterCodec{encoder: encoder})
+		builder.RegisterDefaultDecoder(otherType.Kind(), converterCodec{decoder: decoder})
+	}
+
+	rawMessageType := reflect.TypeOf(json.RawMessage(nil))
+	builder.RegisterTypeEncoder(rawMessageType, rawMessageCodec{})
+	builder.RegisterTypeDecoder(rawMessageType, rawMessageCodec{})
+
+	return builder.Build()
+}
+
+// structTagParser parse the bson tag as the driver does, but the key of a field without a name in its tag is
+// the field name with the first letter lowercased as the entity names it, e.g. userPassword rather than userpassword,
+// so the documents written by the driver have the keys the filters and the updates refer to
+var structTagParser bsoncodec.StructTagParserFunc = func(sf reflect.StructField) (bsoncodec.StructTags, error) {
+	tags, err := bsoncodec.DefaultStructTagParser(sf)
+	if err != nil || tags.Skip {
+		return tags, err
+	}
+
+	tag, ok := sf.Tag.Lookup("bson")
+	if !ok && !strings.Contains(string(sf.Tag), ":") {
+		tag = string(sf.Tag)
+	}
+	if name, _, _ := strings.Cut(tag, ","); name == "" {
+		tags.Name = utils.LowerFirst(sf.Name)
+	}
+	return tags, nil
+}
+
+// converterCodec use the converter registered by entity.RegisterConverter for the type of the value,
+// the converters are looked up on every value, so they can be registered after the registry is built
+type converterCodec struct {
+	encoder bsoncodec.ValueEncoder
+	decoder bsoncodec.ValueDecoder
+}
+
+func (th converterCodec) EncodeValue(ec bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+	converter := entity.LookUpConverter(val.Type())
+	if converter == nil {
+		return th.encoder.EncodeValue(ec, vw, val)
+	}
+
+	encoded := reflect.ValueOf(converter.Encode(val.Interface()))
+	if !encoded.IsValid() {
+		return vw.WriteNull()
+	}
+	encoder, err := ec.LookupEncoder(encoded.Type())
+	if err != nil {
+		return err
+	}
+	return encoder.EncodeValue(ec, vw, encoded)
+}
+
+func (th converterCodec) DecodeValue(dc bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+	converter := entity.LookUpConverter(val.Type())
+	if converter == nil {
+		if setter, ok := fallbackSetter(vr, val); ok {
+			t, data, err := bsonrw.Copier{}.CopyValueToBytes(vr)
+			if err != nil {
+				return err
+			}
+			return setter.SetBSON(bson.RawValue{Type: t, Value: data})
+		}
+		return th.decoder.DecodeValue(dc, vr, val)
+	}
+
+	if !val.CanSet() {
+		return bsoncodec.ValueDecoderError{Name: "converterCodec", Received: val}
+	}
+
+	t, data, err := bsonrw.Copier{}.CopyValueToBytes(vr)
+	if err != nil {
+		return err
+	}
+	var stored any
+	err = bson.RawValue{Type: t, Value: data}.UnmarshalWithRegistry(dc.Registry, &stored)
+	if err != nil {
+		return err
+	}
+
+	decoded := reflect.ValueOf(converter.Decode(stored))
+	if !decoded.IsValid() {
+		val.Set(reflect.Zero(val.Type()))
+		return nil
+	}
+	if !decoded.Type().ConvertibleTo(val.Type()) {
+		return fmt.Errorf("converter of %s decoded %s", val.Type(), decoded.Type())
+	}
+	val.Set(decoded.Convert(val.Type()))
+	return nil
+}
+
+// Setter is implemented by a struct stored as a scalar to read itself from the stored value,
+// e.g. a Money{Amount} stored as a number. It is called only when the value is not a document,
+// documents decode into the struct as usual
+type Setter interface {
+	SetBSON(value bson.RawValue) error
+}
+
+var setterType = reflect.TypeOf((*Setter)(nil)).Elem()
+
+// fallbackSetter the Setter of val when the value of vr can not decode into the struct val
+func fallbackSetter(vr bsonrw.ValueReader, val reflect.Value) (Setter, bool) {
+	if val.Kind() != reflect.Struct || !val.CanAddr() || !reflect.PtrTo(val.Type()).Implements(setterType) {
+		return nil, false
+	}
+	switch vr.Type() {
+	case bsontype.EmbeddedDocument, bsontype.Null, bsontype.Undefined:
+		return nil, false
+	}
+	return val.Addr().Interface().(Setter), true
+}
+
+// rawMessageCodec store a json.RawMessage as the bson value of the json, e.g. {"a":1} as a document,
+// and read the value back as relaxed extended json, so the field passes subdocuments through as json
+type rawMessageCodec struct{}
+
+// the key wrapping a json value into a document, extended json converts documents only
+const rawMessageKey = "v"
+
+func (rawMessageCodec) EncodeValue(ec bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+	message := val.Bytes()
+	if len(message) == 0 {
+		return vw.WriteNull()
+	}
+
+	wrapped := make([]byte, 0, len(message)+6)
+	wrapped = append(wrapped, `{"`+rawMessageKey+`":`...)
+	wrapped = append(append(wrapped, message...), '}')
+	var doc bson.Raw
+	if err := bson.UnmarshalExtJSON(wrapped, false, &doc); err != nil {
+		return err
+	}
+	value := doc.Lookup(rawMessageKey)
+	return bsonrw.Copier{}.CopyValueFromBytes(vw, value.Type, value.Value)
+}
+
+func (rawMessageCodec) DecodeValue(dc bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+	if !val.CanSet() {
+		return bsoncodec.ValueDecoderError{Name: "rawMessageCodec", Received: val}
+	}
+
+	t, data, err := bsonrw.Copier{}.CopyValueToBytes(vr)
+	if err != nil {
+		return err
+	}
+	if t == bsontype.Null || t == bsontype.Undefined {
+		val.SetBytes(nil)
+		return nil
+	}
+
+	index, doc := bsoncore.AppendDocumentStart(nil)
+	doc = bsoncore.AppendValueElement(doc, rawMessageKey, bsoncore.Value{Type: t, Data: data})
+	doc, err = bsoncore.AppendDocumentEnd(doc, index)
+	if err != nil {
+		return err
+	}
+	extJSON, err := bson.MarshalExtJSON(bson.Raw(doc), false, false)
+	if err != nil {
+		return err
+	}
+
+	var wrapped map[string]json.RawMessage
+	if err := json.Unmarshal(extJSON, &wrapped); err != nil {
+		return err
+	}
+	val.SetBytes(wrapped[rawMessageKey])
+	return nil
+}
+
+// numberDecoder decode any bson number into go numeric kinds
+type numberDecoder struct {
+	fallback bsoncodec.ValueDecoder
+	// drop the fraction of a number decoded into an integer instead of returning an error
+	truncate bool
+}
+
+func (th numberDecoder) DecodeValue(dc bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+	if vr.Type() != bsontype.Decimal128 {
+		// double into integer is allowed, the fraction is dropped when truncating
+		dc.Truncate = dc.Truncate || th.truncate
+		return th.fallback.DecodeValue(dc, vr, val)
+	}
+
+	if !val.CanSet() {
+		return bsoncodec.ValueDecoderError{Name: "numberDecoder", Received: val}
+	}
+
+	decimal, err := vr.ReadDecimal128()
+	if err != nil {
+		return err
+	}
+
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := decimalInteger(decimal, th.truncate)
+		if err != nil {
+			return err
+		}
+		if !n.IsInt64() || val.OverflowInt(n.Int64()) {
+			return fmt.Errorf("%s overflows %s", decimal.String(), val.Type())
+		}
+		val.SetInt(n.Int64())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := decimalInteger(decimal, th.truncate)
+		if err != nil {
+			return err
+		}
+		if !n.IsUint64() || val.OverflowUint(n.Uint64()) {
+			return fmt.Errorf("%s overflows %s", decimal.String(), val.Type())
+		}
+		val.SetUint(n.Uint64())
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(decimal.String(), 64)
+		if err != nil {
+			return err
+		}
+		val.SetFloat(f)
+	}
+
+	return nil
+}
+
+// decimalInteger the integer part of decimal, computed exactly so integers beyond the precision of a float64 are kept.
+// As for a double, the fraction is dropped when truncating, otherwise it is an error
+func decimalInteger(decimal primitive.Decimal128, truncate bool) (*big.Int, error) {
+	n, exp, err := decimal.BigInt()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case exp > 0:
+		return n.Mul(n, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exp)), nil)), nil
+	case exp < 0:
+		integer, fraction := n.QuoRem(n, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(-exp)), nil), new(big.Int))
+		if fraction.Sign() != 0 && !truncate {
+			return nil, fmt.Errorf("%s has a fraction, it can not be decoded into an integer", decimal.String())
+		}
+		return integer, nil
+	}
+	return n, nil
+}
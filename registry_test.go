@@ -0,0 +1,356 @@
+package jmongo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/JackWSK/jmongo/entity"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_Registry_DecodeSum(t *testing.T) {
+
+	type Stat struct {
+		Id    string  `bson:"_id"`
+		Sum   int64   `bson:"sum"`
+		Avg   float64 `bson:"avg"`
+		Total uint32  `bson:"total"`
+	}
+
+	total, err := primitive.ParseDecimal128("42")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// $sum over doubles comes back as double, $avg over integers may come back as int
+	cursor, err := mongo.NewCursorFromDocuments([]any{
+		bson.M{"_id": "a", "sum": 12.75, "avg": int32(3), "total": total},
+	}, nil, DefaultRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the results of aggregations are decoded by Aggregate
+	var stats []Stat
+	if err := newOfflineCollection(t).decodeAggregated(context.Background(), cursor, &stats); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if len(stats) != 1 {
+		t.Fatalf("expect 1 result, got %d", len(stats))
+	}
+	if stats[0].Sum != 12 {
+		t.Errorf("expect sum 12, got %d", stats[0].Sum)
+	}
+	if stats[0].Avg != 3 {
+		t.Errorf("expect avg 3, got %f", stats[0].Avg)
+	}
+	if stats[0].Total != 42 {
+		t.Errorf("expect total 42, got %d", stats[0].Total)
+	}
+	// decimals are converted exactly, beyond the precision of a float64 too
+	for _, c := range []struct {
+		decimal string
+		expect  int64
+		fails   bool
+	}{
+		{decimal: "9007199254740993", expect: 9007199254740993},
+		{decimal: "-12.75", expect: -12},
+		{decimal: "1.2E3", expect: 1200},
+		{decimal: "9223372036854775808", fails: true},
+		{decimal: "NaN", fails: true},
+	} {
+		decimal, err := primitive.ParseDecimal128(c.decimal)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := bson.Marshal(bson.M{"sum": decimal})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var stat Stat
+		err = bson.UnmarshalWithRegistry(aggregateRegistry, data, &stat)
+		if c.fails {
+			if err == nil {
+				t.Errorf("expect error for %s, got %d", c.decimal, stat.Sum)
+			}
+			continue
+		}
+		if err != nil || stat.Sum != c.expect {
+			t.Errorf("expect %d for %s, got %d, %v", c.expect, c.decimal, stat.Sum, err)
+		}
+	}
+
+	negative, err := primitive.ParseDecimal128("-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := bson.Marshal(bson.M{"total": negative})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var unsigned Stat
+	if err := bson.UnmarshalWithRegistry(DefaultRegistry, data, &unsigned); err == nil {
+		t.Errorf("expect error for a negative unsigned, got %d", unsigned.Total)
+	}
+
+	// other reads keep a fraction from being dropped silently
+	fraction, err := primitive.ParseDecimal128("-12.75")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []any{3.7, fraction} {
+		data, err := bson.Marshal(bson.M{"sum": value})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var stat Stat
+		if err := bson.UnmarshalWithRegistry(DefaultRegistry, data, &stat); err == nil {
+			t.Errorf("expect error for %v, got %d", value, stat.Sum)
+		}
+	}
+	data, err = bson.Marshal(bson.M{"sum": 12.0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var integral Stat
+	if err := bson.UnmarshalWithRegistry(DefaultRegistry, data, &integral); err != nil || integral.Sum != 12 {
+		t.Errorf("expect 12 from an integral double, got %d, %v", integral.Sum, err)
+	}
+}
+
+type Task struct {
+	Id      SObjectId     `bson:"_id,omitempty"`
+	Name    string        `bson:"name"`
+	Timeout time.Duration `bson:"timeout"`
+}
+
+// registerDurationConverter store durations as milliseconds until the test ends, other tests keep the driver's encoding
+func registerDurationConverter(t testing.TB) {
+	durationType := reflect.TypeOf(time.Duration(0))
+	t.Cleanup(func() {
+		entity.UnregisterConverter(durationType)
+	})
+	entity.RegisterConverter(durationType, func(v any) any {
+		return v.(time.Duration).Milliseconds()
+	}, func(v any) any {
+		switch n := v.(type) {
+		case int64:
+			return time.Duration(n) * time.Millisecond
+		case int32:
+			return time.Duration(n) * time.Millisecond
+		}
+		return nil
+	})
+}
+
+func Test_Registry_Converter(t *testing.T) {
+	t.Run("registered", func(t *testing.T) {
+		registerDurationConverter(t)
+
+		data, err := bson.MarshalWithRegistry(DefaultRegistry, &Task{Name: "a", Timeout: 1500 * time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored := bson.Raw(data).Lookup("timeout"); stored.Type != bsontype.Int64 || stored.Int64() != 1500 {
+			t.Errorf("expect 1500 milliseconds stored, got %v", stored)
+		}
+
+		var task Task
+		if err := bson.UnmarshalWithRegistry(DefaultRegistry, data, &task); err != nil {
+			t.Fatal(err)
+		}
+		if task.Timeout != 1500*time.Millisecond || task.Name != "a" {
+			t.Errorf("unexpected task %+v", task)
+		}
+	})
+
+	// unregistered when the test ends, durations are stored as nanoseconds again
+	data, err := bson.MarshalWithRegistry(DefaultRegistry, &Task{Name: "a", Timeout: 1500 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored := bson.Raw(data).Lookup("timeout"); stored.Int64() != int64(1500*time.Millisecond) {
+		t.Errorf("expect nanoseconds stored after the test, got %v", stored)
+	}
+}
+
+func Test_Registry_ConverterRoundTrip(t *testing.T) {
+	registerDurationConverter(t)
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Task, SObjectId](&Task{}, c.Database("test"))
+	ctx := context.Background()
+
+	task := &Task{Name: "converter", Timeout: 3 * time.Second}
+	if err := col.InsertOne(ctx, task); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	found, err := col.FindOneById(ctx, task.Id)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if found == nil || found.Timeout != 3*time.Second {
+		t.Errorf("expect timeout 3s, got %+v", found)
+	}
+}
+
+// Money stored as the number of cents
+type Money struct {
+	Amount int64
+}
+
+func (th *Money) SetBSON(value bson.RawValue) error {
+	amount, ok := value.AsInt64OK()
+	if !ok {
+		return fmt.Errorf("can not read money from %s", value.Type)
+	}
+	th.Amount = amount
+	return nil
+}
+
+type Invoice struct {
+	Total    Money  `bson:"total"`
+	Discount *Money `bson:"discount"`
+}
+
+func Test_Registry_Setter(t *testing.T) {
+	data, err := bson.Marshal(bson.M{"total": int64(1250), "discount": int32(50)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var invoice Invoice
+	if err := bson.UnmarshalWithRegistry(DefaultRegistry, data, &invoice); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if invoice.Total.Amount != 1250 || invoice.Discount == nil || invoice.Discount.Amount != 50 {
+		t.Errorf("unexpected invoice %+v %+v", invoice, invoice.Discount)
+	}
+
+	// a document decodes into the struct as usual
+	data, err = bson.Marshal(bson.M{"total": bson.M{"amount": int64(7)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	invoice = Invoice{}
+	if err := bson.UnmarshalWithRegistry(DefaultRegistry, data, &invoice); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if invoice.Total.Amount != 7 {
+		t.Errorf("unexpected invoice %+v", invoice)
+	}
+}
+
+type Webhook struct {
+	Id      SObjectId       `bson:"_id,omitempty"`
+	Payload json.RawMessage `bson:"payload"`
+}
+
+func Test_Registry_RawMessage(t *testing.T) {
+	for _, payload := range []string{`{"a":1}`, `[1,"x",{"b":true}]`, `"text"`} {
+		data, err := bson.MarshalWithRegistry(DefaultRegistry, &Webhook{Payload: json.RawMessage(payload)})
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+
+		var decoded Webhook
+		if err := bson.UnmarshalWithRegistry(DefaultRegistry, data, &decoded); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if string(decoded.Payload) != payload {
+			t.Errorf("expect %s, got %s", payload, decoded.Payload)
+		}
+	}
+
+	data, err := bson.MarshalWithRegistry(DefaultRegistry, &Webhook{Payload: json.RawMessage(`{"a":1}`)})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if payload := bson.Raw(data).Lookup("payload"); payload.Type != bsontype.EmbeddedDocument || payload.Document().Lookup("a").Int32() != 1 {
+		t.Errorf("expect the payload stored as a document, got %v", payload)
+	}
+
+	data, err = bson.MarshalWithRegistry(DefaultRegistry, &Webhook{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var decoded Webhook
+	if err := bson.UnmarshalWithRegistry(DefaultRegistry, data, &decoded); err != nil || decoded.Payload != nil {
+		t.Errorf("expect a nil payload stored as null, got %s, %v", decoded.Payload, err)
+	}
+}
+
+func Test_RawMessage_InsertRead(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Webhook, SObjectId](&Webhook{}, c.Database("test"))
+	ctx := context.Background()
+
+	hook := &Webhook{Id: NewSObjectId(), Payload: json.RawMessage(`{"a":1}`)}
+	if err := col.InsertOne(ctx, hook); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	found, err := col.FindOneById(ctx, hook.Id)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if string(found.Payload) != `{"a":1}` {
+		t.Errorf("expect {\"a\":1}, got %s", found.Payload)
+	}
+}
+
+func Test_Registry_UntaggedKey(t *testing.T) {
+	col := newOfflineCollection(t)
+
+	data, err := bson.MarshalWithRegistry(DefaultRegistry, &Test{Name: "untagged", UserPassword: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the driver writes the key the filters refer to
+	query, _, err := col.convertFilter(Cond().Eq("UserPassword", 7))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	key := col.schema.LookUpField("UserPassword").DBName
+	if _, ok := query.(bson.M)[key]; !ok || key != "userPassword" {
+		t.Errorf("expect the filter on userPassword, got %v", query)
+	}
+	if stored := bson.Raw(data).Lookup(key); stored.Type != bsontype.Int64 && stored.Type != bsontype.Int32 || stored.AsInt64() != 7 {
+		t.Errorf("expect %s stored, got %s", key, data)
+	}
+
+	var decoded *Test
+	if err := col.decodeRaw(data, col.schema, &decoded); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if decoded.UserPassword != 7 {
+		t.Errorf("expect the field decoded back, got %+v", decoded)
+	}
+}
+
+func Test_Registry_UntaggedKeyInsertRead(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Test, SObjectId](&Test{}, c.Database("test"))
+	ctx := context.Background()
+
+	password := int(time.Now().UnixNano() % 1000000)
+	test := &Test{Id: NewSObjectId(), Name: "untagged", UserPassword: password}
+	if err := col.InsertOne(ctx, test); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	found, err := col.FindOneByFilter(ctx, Cond().Eq("UserPassword", password))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if found == nil || found.Id != test.Id {
+		t.Errorf("expect the inserted test by its untagged field, got %+v", found)
+	}
+}
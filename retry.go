@@ -0,0 +1,85 @@
+package jmongo
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+// RetryPolicy retry operations failed by transient errors.
+// Reads are retried when Retryable returns true, writes only when the driver labels the error RetryableWriteError,
+// set UnsafeRetryWrites to retry writes by Retryable too, a write may then be applied more than once
+type RetryPolicy struct {
+	// attempts including the first one
+	MaxAttempts int
+	// wait before the second attempt, doubled for every next attempt
+	Backoff time.Duration
+	// classify errors of reads, IsTransientError when nil
+	Retryable func(err error) bool
+	// retry non idempotent writes by Retryable
+	UnsafeRetryWrites bool
+}
+
+// IsTransientError network errors and errors the server labels as retryable
+func IsTransientError(err error) bool {
+	if mongo.IsNetworkError(err) {
+		return true
+	}
+	return hasErrorLabel(err, "RetryableWriteError") || hasErrorLabel(err, "TransientTransactionError")
+}
+
+func hasErrorLabel(err error, label string) bool {
+	var serverError mongo.ServerError
+	return errors.As(err, &serverError) && serverError.HasErrorLabel(label)
+}
+
+// SetRetryPolicy set the policy of every operation of the client, nil disables retrying,
+// call it when setting up the client
+func (c *Client) SetRetryPolicy(policy *RetryPolicy) {
+	c.retryPolicy = policy
+}
+
+func (th *RetryPolicy) shouldRetry(err error, write bool) bool {
+	if write && !th.UnsafeRetryWrites {
+		return hasErrorLabel(err, "RetryableWriteError")
+	}
+	if th.Retryable != nil {
+		return th.Retryable(err)
+	}
+	return IsTransientError(err)
+}
+
+// safeWrites the policy retrying writes only when the driver labels the error RetryableWriteError, whatever UnsafeRetryWrites is,
+// for writes which must not be applied twice, e.g. an $inc returning the document
+func (th *RetryPolicy) safeWrites() *RetryPolicy {
+	if th == nil || !th.UnsafeRetryWrites {
+		return th
+	}
+	policy := *th
+	policy.UnsafeRetryWrites = false
+	return &policy
+}
+
+// retry run op until it succeeds, the error is not retryable, the attempts are used up or ctx is done
+func retry[T any](ctx context.Context, policy *RetryPolicy, write bool, op func() (T, error)) (T, error) {
+	result, err := op()
+	if policy == nil {
+		return result, err
+	}
+
+	backoff := policy.Backoff
+	for attempt := 1; err != nil && attempt < policy.MaxAttempts && policy.shouldRetry(err, write); attempt++ {
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return result, err
+		case <-timer.C:
+		}
+		backoff *= 2
+
+		result, err = op()
+	}
+	return result, err
+}
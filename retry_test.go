@@ -0,0 +1,74 @@
+package jmongo
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"testing"
+)
+
+// flakyCollection fails the first failures calls with err
+type flakyCollection struct {
+	failures int
+	err      error
+	calls    int
+}
+
+func (th *flakyCollection) Count() (int64, error) {
+	th.calls++
+	if th.calls <= th.failures {
+		return 0, th.err
+	}
+	return 3, nil
+}
+
+func Test_Retry(t *testing.T) {
+	ctx := context.Background()
+	networkError := mongo.CommandError{Labels: []string{"NetworkError"}}
+	policy := &RetryPolicy{MaxAttempts: 3}
+
+	// a read fails twice then succeeds
+	fake := &flakyCollection{failures: 2, err: networkError}
+	count, err := retry(ctx, policy, false, fake.Count)
+	if err != nil || count != 3 || fake.calls != 3 {
+		t.Errorf("expect success after 3 calls, got %d %v after %d calls", count, err, fake.calls)
+	}
+
+	// attempts are used up
+	fake = &flakyCollection{failures: 3, err: networkError}
+	if _, err := retry(ctx, policy, false, fake.Count); err == nil || fake.calls != 3 {
+		t.Errorf("expect error after 3 calls, got %v after %d calls", err, fake.calls)
+	}
+
+	// errors which are not transient are returned at once
+	fake = &flakyCollection{failures: 1, err: errors.New("bad query")}
+	if _, err := retry(ctx, policy, false, fake.Count); err == nil || fake.calls != 1 {
+		t.Errorf("expect no retry, got %v after %d calls", err, fake.calls)
+	}
+
+	// a write is retried only when the driver marks it retryable
+	fake = &flakyCollection{failures: 1, err: networkError}
+	if _, err := retry(ctx, policy, true, fake.Count); err == nil || fake.calls != 1 {
+		t.Errorf("expect no retry of the write, got %v after %d calls", err, fake.calls)
+	}
+	fake = &flakyCollection{failures: 1, err: mongo.CommandError{Labels: []string{"RetryableWriteError"}}}
+	if _, err := retry(ctx, policy, true, fake.Count); err != nil || fake.calls != 2 {
+		t.Errorf("expect the retryable write to succeed, got %v after %d calls", err, fake.calls)
+	}
+
+	// unsafe write retries are opt in
+	unsafe := &RetryPolicy{MaxAttempts: 3, UnsafeRetryWrites: true}
+	fake = &flakyCollection{failures: 1, err: networkError}
+	if _, err := retry(ctx, unsafe, true, fake.Count); err != nil || fake.calls != 2 {
+		t.Errorf("expect the write to be retried, got %v after %d calls", err, fake.calls)
+	}
+
+	// but not for writes which must not be applied twice
+	fake = &flakyCollection{failures: 1, err: networkError}
+	if _, err := retry(ctx, unsafe.safeWrites(), true, fake.Count); err == nil || fake.calls != 1 {
+		t.Errorf("expect no retry of the safe write, got %v after %d calls", err, fake.calls)
+	}
+	if !unsafe.UnsafeRetryWrites || (*RetryPolicy)(nil).safeWrites() != nil {
+		t.Error("expect safeWrites to keep the policy of the client")
+	}
+}
@@ -0,0 +1,69 @@
+package jmongo
+
+import (
+	"context"
+	"github.com/JackWSK/jmongo/entity"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ApplyValidator set the $jsonSchema of the model as the validator of the collection by collMod
+func (th *Collection[MODEL, ID]) ApplyValidator(ctx context.Context) error {
+	ctx, cancel := th.withTimeout(ctx)
+	defer cancel()
+
+	command := collModCommand(th.collection.Name(), options.CreateCollection().SetValidator(bson.M{"$jsonSchema": th.schema.JSONSchema()}))
+	_, err := retry(ctx, th.retryPolicy(), true, func() (bson.Raw, error) {
+		return th.collection.Database().RunCommand(ctx, command).DecodeBytes()
+	})
+	return errors.WithStack(err)
+}
+
+// error code of creating a collection which exists
+const namespaceExistsCode = 48
+
+// CreateCollectionWithValidator create the collection of model with the $jsonSchema of the model as the validator,
+// validationLevel is strict and validationAction is error unless opts sets them.
+// The validator is updated by collMod when the collection already exists
+func (th *Database) CreateCollectionWithValidator(ctx context.Context, model any, opts ...*options.CreateCollectionOptions) error {
+	name, createOpts, err := validatorOptions(model, opts...)
+	if err != nil {
+		return err
+	}
+
+	err = th.db.CreateCollection(ctx, name, createOpts)
+	var commandError mongo.CommandError
+	if !errors.As(err, &commandError) || commandError.Code != namespaceExistsCode {
+		return errors.WithStack(err)
+	}
+
+	err = th.db.RunCommand(ctx, collModCommand(name, createOpts)).Err()
+	return errors.WithStack(err)
+}
+
+// validatorOptions the collection name of model, and the create options carrying the validator of the model
+func validatorOptions(model any, opts ...*options.CreateCollectionOptions) (string, *options.CreateCollectionOptions, error) {
+	schema, err := entity.GetOrParse(model)
+	if err != nil {
+		return "", nil, err
+	}
+
+	defaults := options.CreateCollection().
+		SetValidator(bson.M{"$jsonSchema": schema.JSONSchema()}).
+		SetValidationLevel("strict").
+		SetValidationAction("error")
+	return schema.Collection, options.MergeCreateCollectionOptions(append([]*options.CreateCollectionOptions{defaults}, opts...)...), nil
+}
+
+func collModCommand(name string, opts *options.CreateCollectionOptions) bson.D {
+	command := bson.D{{Key: "collMod", Value: name}, {Key: "validator", Value: opts.Validator}}
+	if opts.ValidationLevel != nil {
+		command = append(command, bson.E{Key: "validationLevel", Value: *opts.ValidationLevel})
+	}
+	if opts.ValidationAction != nil {
+		command = append(command, bson.E{Key: "validationAction", Value: *opts.ValidationAction})
+	}
+	return command
+}
@@ -0,0 +1,187 @@
+package jmongo
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/JackWSK/jmongo/entity"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_JSONSchema(t *testing.T) {
+	col := newOfflineCollection(t)
+
+	expect := bson.M{
+		"bsonType": "object",
+		"properties": bson.M{
+			"like":         bson.M{"bsonType": "string"},
+			"_id":          bson.M{"bsonType": bson.A{"objectId", "string"}},
+			"name":         bson.M{"bsonType": "string"},
+			"happy":        bson.M{"bsonType": bson.A{"int", "long"}},
+			"helloWorld":   bson.M{"bsonType": bson.A{"int", "long"}},
+			"userPassword": bson.M{"bsonType": bson.A{"int", "long"}},
+			"orderId":      bson.M{"bsonType": bson.A{"objectId", "string"}},
+		},
+	}
+	if schema := col.Entity().JSONSchema(); !reflect.DeepEqual(schema, expect) {
+		t.Errorf("unexpected schema %v", schema)
+	}
+}
+
+type Shipment struct {
+	Id        SObjectId         `bson:"_id,omitempty"`
+	Code      string            `bson:"code" validate:"required"`
+	Weight    float64           `bson:"weight" validate:"gt=0,required"`
+	Items     []ShipmentItem    `bson:"items"`
+	SignedAt  *time.Time        `bson:"signedAt"`
+	Extra     map[string]string `bson:"extra"`
+	Payload   any               `bson:"payload"`
+	Parent    *Shipment         `bson:"parent"`
+	CreatedAt time.Time         `bson:"createdAt"`
+}
+
+type ShipmentItem struct {
+	Sku   string `bson:"sku" validate:"required"`
+	Count int32  `bson:"count"`
+}
+
+func Test_JSONSchema_Nested(t *testing.T) {
+	col := NewCollection[*Shipment, SObjectId](&Shipment{}, newOfflineDatabase(t))
+	schema := col.Entity().JSONSchema()
+
+	if !reflect.DeepEqual(schema["required"], bson.A{"code", "weight"}) {
+		t.Errorf("unexpected required %v", schema["required"])
+	}
+
+	properties := schema["properties"].(bson.M)
+	expect := bson.M{
+		"bsonType": bson.A{"array", "null"},
+		"items": bson.M{
+			"bsonType":   "object",
+			"required":   bson.A{"sku"},
+			"properties": bson.M{"sku": bson.M{"bsonType": "string"}, "count": bson.M{"bsonType": "int"}},
+		},
+	}
+	if !reflect.DeepEqual(properties["items"], expect) {
+		t.Errorf("unexpected items %v", properties["items"])
+	}
+	if !reflect.DeepEqual(properties["signedAt"], bson.M{"bsonType": bson.A{"date", "null"}}) {
+		t.Errorf("unexpected signedAt %v", properties["signedAt"])
+	}
+	if !reflect.DeepEqual(properties["payload"], bson.M{}) {
+		t.Errorf("unexpected payload %v", properties["payload"])
+	}
+	// the recursive reference is only checked to be an object
+	if !reflect.DeepEqual(properties["parent"], bson.M{"bsonType": bson.A{"object", "null"}}) {
+		t.Errorf("unexpected parent %v", properties["parent"])
+	}
+}
+
+func Test_ApplyValidator_Command(t *testing.T) {
+	col := newOfflineCollection(t)
+
+	validator := bson.M{"$jsonSchema": col.Entity().JSONSchema()}
+	command := collModCommand(col.collection.Name(), options.CreateCollection().SetValidator(validator))
+	expect := bson.D{{Key: "collMod", Value: col.collection.Name()}, {Key: "validator", Value: validator}}
+	if !reflect.DeepEqual(command, expect) {
+		t.Errorf("unexpected command %v", command)
+	}
+}
+
+func Test_CreateCollectionWithValidator_Options(t *testing.T) {
+	name, opts, err := validatorOptions(&Shipment{}, options.CreateCollection().SetValidationAction("warn"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	schema, _ := entity.GetOrParse(&Shipment{})
+	if name != schema.Collection {
+		t.Errorf("expect collection %s, got %s", schema.Collection, name)
+	}
+	if !reflect.DeepEqual(opts.Validator, bson.M{"$jsonSchema": schema.JSONSchema()}) {
+		t.Errorf("unexpected validator %v", opts.Validator)
+	}
+	if *opts.ValidationLevel != "strict" || *opts.ValidationAction != "warn" {
+		t.Errorf("unexpected validation level %s action %s", *opts.ValidationLevel, *opts.ValidationAction)
+	}
+
+	// an existing collection gets the same validator by collMod
+	command := collModCommand(name, opts)
+	expect := bson.D{
+		{Key: "collMod", Value: name},
+		{Key: "validator", Value: opts.Validator},
+		{Key: "validationLevel", Value: "strict"},
+		{Key: "validationAction", Value: "warn"},
+	}
+	if !reflect.DeepEqual(command, expect) {
+		t.Errorf("unexpected command %v", command)
+	}
+}
+
+func Test_ApplyValidator(t *testing.T) {
+	client := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Shipment, SObjectId](&Shipment{}, client.Database("test"))
+
+	ctx := context.Background()
+	if err := col.InsertOne(ctx, &Shipment{Code: "s1", Weight: 1}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := col.ApplyValidator(ctx); err != nil {
+		t.Fatalf("%+v", err)
+	}
+}
+
+func Test_CreateCollectionWithValidator(t *testing.T) {
+	client := setupMongoClient(t, MongoUrl)
+	database := client.Database("test")
+
+	ctx := context.Background()
+	// created the first time, updated by collMod the second time
+	for i := 0; i < 2; i++ {
+		if err := database.CreateCollectionWithValidator(ctx, &Shipment{}); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+}
+
+func Test_Entity_Describe(t *testing.T) {
+	data, err := json.Marshal(newOfflineCollection(t).Entity().Describe())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var descriptor entity.EntityDescriptor
+	if err := json.Unmarshal(data, &descriptor); err != nil {
+		t.Fatal(err)
+	}
+	if descriptor.Collection != "test" || descriptor.IdField != "_id" {
+		t.Errorf("expect collection test with id _id, got %s", data)
+	}
+
+	dbNames := map[string]string{}
+	for _, field := range descriptor.Fields {
+		dbNames[field.Name] = field.DBName
+	}
+	if dbNames["Age"] != "happy" || dbNames["Like"] != "like" {
+		t.Errorf("expect Age stored as happy, got %s", data)
+	}
+}
+
+func Test_Entity_LookUpField(t *testing.T) {
+	e, err := entity.GetOrParse(&Test{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	for dbName, name := range map[string]string{"name": "Name", "happy": "Age", "like": "Like", "_id": "Id"} {
+		if field := e.LookUpField(dbName); field == nil || field.Name != name || field.DBName != dbName {
+			t.Errorf("expect %s to be the field %s, got %+v", dbName, name, field)
+		}
+	}
+	if field := e.LookUpField("Age"); field == nil || field.DBName != "happy" {
+		t.Errorf("expect Age to be stored as happy, got %+v", field)
+	}
+}
@@ -0,0 +1,38 @@
+package jmongo
+
+import (
+	"context"
+	"time"
+)
+
+// QueryStats the stats of a query measured by the client, set by Option().WithStats
+type QueryStats struct {
+	// from sending the query to decoding the last document, including the round trips of getMore
+	Duration time.Duration
+	// number of documents decoded
+	Returned int
+}
+
+// QueryResult the documents of a query with its stats
+type QueryResult[MODEL any] struct {
+	Documents []MODEL
+	QueryStats
+}
+
+// FindWithStats same as Find, and return the stats of the query with the documents,
+// use Explain for the plan and the documents examined by the server
+func (th *Collection[MODEL, ID]) FindWithStats(ctx context.Context, filter any, opts ...*FindOption) (*QueryResult[MODEL], error) {
+	result := &QueryResult[MODEL]{}
+	opts = append(append([]*FindOption{}, opts...), Option().WithStats(&result.QueryStats))
+	docs, err := th.Find(ctx, filter, opts...)
+	result.Documents = docs
+	return result, err
+}
+
+// queryStats the stats option fills, nil when not asked
+func queryStats(option *FindOption) *QueryStats {
+	if option == nil {
+		return nil
+	}
+	return option.stats
+}
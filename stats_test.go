@@ -0,0 +1,37 @@
+package jmongo
+
+import (
+	"context"
+	"testing"
+)
+
+func Test_QueryStats_Option(t *testing.T) {
+	if queryStats(nil) != nil || queryStats(Option().Limit(1)) != nil {
+		t.Error("expect no stats without WithStats")
+	}
+
+	stats := &QueryStats{}
+	if queryStats(Merge([]*FindOption{Option().Limit(1), Option().WithStats(stats)})) != stats {
+		t.Error("expect the stats to be kept by Merge")
+	}
+}
+
+func Test_FindWithStats(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Test, SObjectId](&Test{}, c.Database("test"))
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if err := col.InsertOne(ctx, &Test{Id: NewSObjectId(), Name: "stats"}); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+
+	result, err := col.FindWithStats(ctx, Cond().Eq("Name", "stats"), Option().Limit(2))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if result.Duration <= 0 || result.Returned != 2 || len(result.Documents) != 2 {
+		t.Errorf("expect the duration and 2 documents, got %v and %d", result.Duration, result.Returned)
+	}
+}
@@ -41,6 +41,11 @@ func (th MustSObjectId) MarshalBSONValue() (bsontype.Type, []byte, error) {
 	return bson.MarshalValue(id)
 }
 
+// BSONType stored as objectId
+func (th MustSObjectId) BSONType() any {
+	return "objectId"
+}
+
 func NewMustObjectIdString() SObjectId {
 	return SObjectId(primitive.NewObjectID().Hex())
 }
@@ -85,6 +90,11 @@ func (th SObjectId) MarshalBSONValue() (bsontype.Type, []byte, error) {
 	return t, v, err
 }
 
+// BSONType stored as objectId, or string when it is not a hex objectId
+func (th SObjectId) BSONType() any {
+	return bson.A{"objectId", "string"}
+}
+
 func NewSObjectId() SObjectId {
 	return SObjectId(primitive.NewObjectID().Hex())
 }
@@ -0,0 +1,300 @@
+package jmongo
+
+import (
+	"fmt"
+	"github.com/JackWSK/jmongo/entity"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"reflect"
+	"strings"
+)
+
+// UpdateBuilder build an update document by operators, field can be model field name or db name,
+// they are resolved by the entity of the collection when the update is used
+type UpdateBuilder struct {
+	items []updateItem
+	// number of the array element identifiers, see SetArrayElement
+	identifiers int
+}
+
+type updateItem struct {
+	operator string
+	field    string
+	value    any
+	// resolve the value by the field, e.g. names inside the value, replace value when set
+	resolve func(field *entity.EntityField) (any, error)
+	// expand the item into several paths under the field with the array filters they need, replace value when set
+	expand func(field *entity.EntityField) (bson.M, []any, error)
+}
+
+// Update create an update builder
+func Update() *UpdateBuilder {
+	return &UpdateBuilder{}
+}
+
+// Set $set the field to value
+func (th *UpdateBuilder) Set(field string, value any) *UpdateBuilder {
+	return th.add("$set", field, value)
+}
+
+// Inc $inc the number field by n, a negative n decrements it
+func (th *UpdateBuilder) Inc(field string, n any) *UpdateBuilder {
+	return th.add("$inc", field, n)
+}
+
+// Push append values to the array field, several values are appended by $each, see PushEach for the modifiers
+func (th *UpdateBuilder) Push(field string, values ...any) *UpdateBuilder {
+	if len(values) == 1 {
+		return th.add("$push", field, values[0])
+	}
+	return th.PushEach(field, values)
+}
+
+// Unset $unset the fields, removing them from the documents
+func (th *UpdateBuilder) Unset(fields ...string) *UpdateBuilder {
+	for _, field := range fields {
+		th.add("$unset", field, "")
+	}
+	return th
+}
+
+// Rename rename the field to the key to, to is used literally since it may not exist on the model yet
+func (th *UpdateBuilder) Rename(from string, to string) *UpdateBuilder {
+	return th.add("$rename", from, to)
+}
+
+// PushEach append values to the array field, modifiers sort, slice or position the array,
+// e.g. PushEach("Comments", comments, Modifiers().Sort("CreatedAt", false).Slice(10))
+func (th *UpdateBuilder) PushEach(field string, values any, modifiers ...*PushModifiers) *UpdateBuilder {
+	th.items = append(th.items, updateItem{operator: "$push", field: field, resolve: func(field *entity.EntityField) (any, error) {
+		each := bson.D{{Key: "$each", Value: values}}
+		for _, m := range modifiers {
+			resolved, err := m.toModifiers(field)
+			if err != nil {
+				return nil, err
+			}
+			each = append(each, resolved...)
+		}
+		return each, nil
+	}})
+	return th
+}
+
+// SetArrayElement $set the fields of value in the elements of the array matching elemCond, e.g.
+// SetArrayElement("Items", bson.M{"sku": "a"}, bson.M{"status": "done"}) sets items.$[elem].status
+// with the array filter {"elem.sku": "a"}. Keys of elemCond and value are field names or db names of the element,
+// the array filters are passed by UpdateManyWith
+func (th *UpdateBuilder) SetArrayElement(arrayPath string, elemCond bson.M, value bson.M) *UpdateBuilder {
+	identifier := "elem"
+	if th.identifiers > 0 {
+		identifier = fmt.Sprintf("elem%d", th.identifiers)
+	}
+	th.identifiers++
+
+	th.items = append(th.items, updateItem{operator: "$set", field: arrayPath, expand: func(field *entity.EntityField) (bson.M, []any, error) {
+		elemSchema, err := elementSchema(field)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		set := bson.M{}
+		for key, v := range value {
+			elemField, err := elemSchema.MustLookUpField(key)
+			if err != nil {
+				return nil, nil, err
+			}
+			set[field.DBName+".$["+identifier+"]."+elemField.DBName] = v
+		}
+
+		filter := bson.M{}
+		for key, v := range elemCond {
+			elemField, err := elemSchema.MustLookUpField(key)
+			if err != nil {
+				return nil, nil, err
+			}
+			filter[identifier+"."+elemField.DBName] = v
+		}
+		return set, []any{filter}, nil
+	}})
+	return th
+}
+
+func (th *UpdateBuilder) add(operator string, field string, value any) *UpdateBuilder {
+	th.items = append(th.items, updateItem{operator: operator, field: field, value: value})
+	return th
+}
+
+// toUpdate make {operator: {dbName: value}}, fields of the same operator are merged.
+// A path updated twice, or with its parent, is an error as mongo rejects the conflict
+func (th *UpdateBuilder) toUpdate(schema *entity.Entity) (bson.M, error) {
+	update := bson.M{}
+	operators := map[string]string{}
+	for _, item := range th.items {
+		field, err := schema.MustLookUpField(item.field)
+		if err != nil {
+			return nil, err
+		}
+
+		fields, ok := update[item.operator].(bson.M)
+		if !ok {
+			fields = bson.M{}
+			update[item.operator] = fields
+		}
+
+		if item.expand != nil {
+			paths, _, err := item.expand(field)
+			if err != nil {
+				return nil, err
+			}
+			for path, value := range paths {
+				if err := checkUpdateConflict(operators, path, item.operator); err != nil {
+					return nil, err
+				}
+				fields[path] = value
+			}
+			continue
+		}
+
+		value := item.value
+		if item.resolve != nil {
+			if value, err = item.resolve(field); err != nil {
+				return nil, err
+			}
+		}
+		if err := checkUpdateConflict(operators, field.DBName, item.operator); err != nil {
+			return nil, err
+		}
+		fields[field.DBName] = value
+	}
+	return update, nil
+}
+
+// checkUpdateConflict record path of operator in operators, error when path, its parent or a child is already updated
+func checkUpdateConflict(operators map[string]string, path string, operator string) error {
+	for updated, updatedBy := range operators {
+		if updated == path || strings.HasPrefix(path, updated+".") || strings.HasPrefix(updated, path+".") {
+			return errors.WithStack(fmt.Errorf("%s by %s conflicts with %s by %s", path, operator, updated, updatedBy))
+		}
+	}
+	operators[path] = operator
+	return nil
+}
+
+// toArrayFilters the array filters of the identifiers used by the update, nil when there is none
+func (th *UpdateBuilder) toArrayFilters(schema *entity.Entity) ([]any, error) {
+	var filters []any
+	for _, item := range th.items {
+		if item.expand == nil {
+			continue
+		}
+		field, err := schema.MustLookUpField(item.field)
+		if err != nil {
+			return nil, err
+		}
+		_, itemFilters, err := item.expand(field)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, itemFilters...)
+	}
+	return filters, nil
+}
+
+// PushModifiers modifiers of PushEach
+type PushModifiers struct {
+	sorts      []*Sort
+	sortValues *int
+	slice      *int
+	position   *int
+}
+
+// Modifiers create modifiers of PushEach
+func Modifiers() *PushModifiers {
+	return &PushModifiers{}
+}
+
+// Sort sort the array of subdocuments by the field of the element, model field name or db name,
+// call it again to sort by more fields
+func (th *PushModifiers) Sort(field string, asc bool) *PushModifiers {
+	th.sorts = append(th.sorts, &Sort{Field: field, Asc: asc})
+	return th
+}
+
+// SortValues sort the array of scalars by the values
+func (th *PushModifiers) SortValues(asc bool) *PushModifiers {
+	order := sortOrder(asc)
+	th.sortValues = &order
+	return th
+}
+
+// Slice keep the first n elements, or the last -n elements when n is negative
+func (th *PushModifiers) Slice(n int) *PushModifiers {
+	th.slice = &n
+	return th
+}
+
+// Position insert the values at the index instead of appending them
+func (th *PushModifiers) Position(index int) *PushModifiers {
+	th.position = &index
+	return th
+}
+
+func (th *PushModifiers) toModifiers(field *entity.EntityField) (bson.D, error) {
+	var modifiers bson.D
+	if len(th.sorts) > 0 {
+		sort, err := th.elementSort(field)
+		if err != nil {
+			return nil, err
+		}
+		modifiers = append(modifiers, bson.E{Key: "$sort", Value: sort})
+	} else if th.sortValues != nil {
+		modifiers = append(modifiers, bson.E{Key: "$sort", Value: *th.sortValues})
+	}
+	if th.slice != nil {
+		modifiers = append(modifiers, bson.E{Key: "$slice", Value: *th.slice})
+	}
+	if th.position != nil {
+		modifiers = append(modifiers, bson.E{Key: "$position", Value: *th.position})
+	}
+	return modifiers, nil
+}
+
+// elementSort resolve the sort fields against the entity of the element type of the array
+func (th *PushModifiers) elementSort(field *entity.EntityField) (bson.D, error) {
+	elemSchema, err := elementSchema(field)
+	if err != nil {
+		return nil, err
+	}
+
+	sort := bson.D{}
+	for _, s := range th.sorts {
+		elemField, err := elemSchema.MustLookUpField(s.Field)
+		if err != nil {
+			return nil, err
+		}
+		sort = append(sort, bson.E{Key: elemField.DBName, Value: sortOrder(s.Asc)})
+	}
+	return sort, nil
+}
+
+// elementSchema the entity of the subdocuments of the array field
+func elementSchema(field *entity.EntityField) (*entity.Entity, error) {
+	if field.FieldType.Kind() != reflect.Slice && field.FieldType.Kind() != reflect.Array {
+		return nil, errors.WithStack(fmt.Errorf("field %s is not an array", field.Name))
+	}
+	elemType := field.FieldType.Elem()
+	if elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct {
+		return nil, errors.WithStack(fmt.Errorf("elements of field %s are not subdocuments", field.Name))
+	}
+	return entity.GetOrParseDocument(reflect.New(elemType).Interface())
+}
+
+func sortOrder(asc bool) int {
+	if asc {
+		return 1
+	}
+	return -1
+}
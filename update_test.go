@@ -0,0 +1,246 @@
+package jmongo
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_Update_Rename(t *testing.T) {
+	schema := newOfflineCollection(t).schema
+
+	update, err := Update().Rename("Age", "years").Rename("helloWorld", "greeting").toUpdate(schema)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := bson.M{"$rename": bson.M{"happy": "years", "helloWorld": "greeting"}}
+	if !reflect.DeepEqual(update, expect) {
+		t.Errorf("expect %v, got %v", expect, update)
+	}
+
+	if _, err := Update().Rename("Unknown", "x").toUpdate(schema); err == nil {
+		t.Error("expect error for unknown field")
+	}
+}
+
+func Test_RenameField(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Test, SObjectId](&Test{}, c.Database("test"))
+	ctx := context.Background()
+
+	if err := col.InsertOne(ctx, &Test{Name: "rename", HelloWorld: 1}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	count, err := col.RenameField(ctx, bson.M{"name": "rename"}, "HelloWorld", "greeting")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if count == 0 {
+		t.Error("expect documents to be renamed")
+	}
+}
+
+type Thread struct {
+	Id       SObjectId  `bson:"_id,omitempty"`
+	Comments []*Comment `bson:"comments"`
+	Scores   []int      `bson:"scores"`
+}
+
+type Comment struct {
+	Text      string    `bson:"text"`
+	CreatedAt time.Time `bson:"createdAt"`
+}
+
+func Test_Update_PushEach(t *testing.T) {
+	schema := NewCollection[*Thread, SObjectId](&Thread{}, newOfflineDatabase(t)).schema
+	comments := []*Comment{{Text: "a"}}
+
+	update, err := Update().
+		PushEach("Comments", comments, Modifiers().Sort("CreatedAt", false).Slice(10)).
+		PushEach("scores", []int{3}, Modifiers().SortValues(true)).
+		toUpdate(schema)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := bson.M{"$push": bson.M{
+		"comments": bson.D{{Key: "$each", Value: comments}, {Key: "$sort", Value: bson.D{{Key: "createdAt", Value: -1}}}, {Key: "$slice", Value: 10}},
+		"scores":   bson.D{{Key: "$each", Value: []int{3}}, {Key: "$sort", Value: 1}},
+	}}
+	if !reflect.DeepEqual(update, expect) {
+		t.Errorf("expect %v, got %v", expect, update)
+	}
+
+	if _, err := Update().PushEach("Comments", comments, Modifiers().Sort("Unknown", true)).toUpdate(schema); err == nil {
+		t.Error("expect error for unknown field of the element")
+	}
+	if _, err := Update().PushEach("Scores", []int{1}, Modifiers().Sort("Value", true)).toUpdate(schema); err == nil {
+		t.Error("expect error for sorting scalars by field")
+	}
+}
+
+type Cart struct {
+	Id    SObjectId   `bson:"_id,omitempty"`
+	Items []*CartItem `bson:"items"`
+}
+
+type CartItem struct {
+	Sku    string `bson:"sku"`
+	Status string `bson:"status"`
+}
+
+func Test_Update_SetArrayElement(t *testing.T) {
+	schema := NewCollection[*Cart, SObjectId](&Cart{}, newOfflineDatabase(t)).schema
+
+	update := Update().SetArrayElement("Items", bson.M{"Sku": "a"}, bson.M{"Status": "shipped"}).
+		SetArrayElement("items", bson.M{"status": bson.M{"$ne": "shipped"}}, bson.M{"status": "pending"})
+	doc, err := update.toUpdate(schema)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := bson.M{"$set": bson.M{"items.$[elem].status": "shipped", "items.$[elem1].status": "pending"}}
+	if !reflect.DeepEqual(doc, expect) {
+		t.Errorf("expect %v, got %v", expect, doc)
+	}
+
+	filters, err := update.toArrayFilters(schema)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expectFilters := []any{bson.M{"elem.sku": "a"}, bson.M{"elem1.status": bson.M{"$ne": "shipped"}}}
+	if !reflect.DeepEqual(filters, expectFilters) {
+		t.Errorf("expect %v, got %v", expectFilters, filters)
+	}
+
+	if _, err := Update().SetArrayElement("Id", bson.M{"sku": "a"}, bson.M{"status": "x"}).toUpdate(schema); err == nil {
+		t.Error("expect error for a field which is not an array")
+	}
+}
+
+func Test_UpdateArrayElement(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Cart, SObjectId](&Cart{}, c.Database("test"))
+	ctx := context.Background()
+
+	cart := &Cart{Id: NewSObjectId(), Items: []*CartItem{{Sku: "a", Status: "new"}, {Sku: "b", Status: "new"}}}
+	if err := col.InsertOne(ctx, cart); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	count, err := col.UpdateArrayElement(ctx, bson.M{"_id": cart.Id}, "Items", bson.M{"Sku": "b"}, bson.M{"Status": "shipped"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if count != 1 {
+		t.Errorf("expect 1 modified, got %d", count)
+	}
+
+	found, err := col.FindOneById(ctx, cart.Id)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if found.Items[0].Status != "new" || found.Items[1].Status != "shipped" {
+		t.Errorf("expect only b to be shipped, got %+v %+v", found.Items[0], found.Items[1])
+	}
+}
+
+func Test_Update_Operators(t *testing.T) {
+	schema := newOfflineCollection(t).schema
+
+	update, err := Update().Set("Name", "x").Inc("Age", 2).Push("Like", "a", "b").Unset("HelloWorld", "orderId").toUpdate(schema)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expect := bson.M{
+		"$set":   bson.M{"name": "x"},
+		"$inc":   bson.M{"happy": 2},
+		"$push":  bson.M{"like": bson.D{{Key: "$each", Value: []any{"a", "b"}}}},
+		"$unset": bson.M{"helloWorld": "", "orderId": ""},
+	}
+	if !reflect.DeepEqual(update, expect) {
+		t.Errorf("expect %v, got %v", expect, update)
+	}
+
+	update, err = Update().Push("Like", "a").toUpdate(schema)
+	if err != nil || !reflect.DeepEqual(update, bson.M{"$push": bson.M{"like": "a"}}) {
+		t.Errorf("expect a single value pushed as it is, got %v %v", update, err)
+	}
+
+	// mongo rejects a field updated by two operators
+	if _, err := Update().Set("Age", 1).Inc("happy", 1).toUpdate(schema); err == nil {
+		t.Error("expect error of the field updated twice")
+	}
+}
+
+func Test_UpdateOneWith(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Test, SObjectId](&Test{}, c.Database("test"))
+	ctx := context.Background()
+
+	test := &Test{Name: "operators", Age: 1, HelloWorld: 5}
+	if err := col.InsertOne(ctx, test); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	matched, modified, err := col.UpdateOneWith(ctx, bson.M{"_id": test.Id}, Update().Inc("Age", 2).Unset("HelloWorld").Set("Like", "go"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if matched != 1 || modified != 1 {
+		t.Errorf("expect 1 matched and modified, got %d %d", matched, modified)
+	}
+
+	found, err := col.FindOneById(ctx, test.Id)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if found.Age != 3 || found.HelloWorld != 0 || found.Like != "go" {
+		t.Errorf("unexpected document %+v", found)
+	}
+
+	matched, _, err = col.UpdateManyWithCounts(ctx, bson.M{"_id": test.Id}, Update().Inc("Age", 1))
+	if err != nil || matched != 1 {
+		t.Errorf("expect 1 matched, got %d %v", matched, err)
+	}
+}
+
+func Test_FindOneAndUpdate(t *testing.T) {
+	c := setupMongoClient(t, MongoUrl)
+	col := NewCollection[*Test, SObjectId](&Test{}, c.Database("test"))
+	ctx := context.Background()
+
+	test := &Test{Name: NewSObjectId().ToString(), Age: 1}
+	if err := col.InsertOne(ctx, test); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	before, ok, err := col.FindOneAndUpdate(ctx, bson.M{"_id": test.Id}, Update().Inc("Age", 1))
+	if err != nil || !ok || before.Age != 1 {
+		t.Fatalf("expect the original document, got %+v %v %v", before, ok, err)
+	}
+
+	after, ok, err := col.FindOneAndUpdate(ctx, bson.M{"_id": test.Id}, Update().Inc("Age", 1), Option().ReturnAfter(true).OnlyFields("Age"))
+	if err != nil || !ok || after.Age != 3 || after.Name != "" {
+		t.Errorf("expect the updated age only, got %+v %v %v", after, ok, err)
+	}
+
+	name := NewSObjectId().ToString()
+	upserted, ok, err := col.FindOneAndUpdate(ctx, bson.M{"name": name}, Update().Set("Age", 7), Option().Upsert(true).ReturnAfter(true))
+	if err != nil || !ok || upserted.Name != name || upserted.Age != 7 {
+		t.Errorf("expect the upserted document, got %+v %v %v", upserted, ok, err)
+	}
+
+	_, ok, err = col.FindOneAndUpdate(ctx, bson.M{"name": NewSObjectId().ToString()}, Update().Set("Age", 7))
+	if err != nil || ok {
+		t.Errorf("expect nothing matched, got %v %v", ok, err)
+	}
+
+	// the upserted document has no original, it is reported still
+	name = NewSObjectId().ToString()
+	original, ok, err := col.FindOneAndUpdate(ctx, bson.M{"name": name}, Update().Set("Age", 8), Option().Upsert(true))
+	if err != nil || !ok || original != nil {
+		t.Errorf("expect the upsert without an original, got %+v %v %v", original, ok, err)
+	}
+}